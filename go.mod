@@ -2,4 +2,22 @@ module github.com/audetv/hex-ecample
 
 go 1.17
 
-require github.com/google/uuid v1.3.0
+require (
+	github.com/glebarez/go-sqlite v1.20.3
+	github.com/google/uuid v1.3.0
+	github.com/lib/pq v1.10.9
+	golang.org/x/crypto v0.9.0
+	golang.org/x/text v0.13.0
+	gopkg.in/yaml.v3 v3.0.1
+	modernc.org/sqlite v1.20.3
+)
+
+require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/mattn/go-isatty v0.0.17 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230126093431-47fa9a501578 // indirect
+	golang.org/x/sys v0.8.0 // indirect
+	modernc.org/libc v1.22.2 // indirect
+	modernc.org/mathutil v1.5.0 // indirect
+	modernc.org/memory v1.5.0 // indirect
+)
@@ -2,38 +2,244 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
 	"os"
 	"os/signal"
-	"sync"
 
 	"github.com/audetv/hex-ecample/reguser/internal/api/handler"
 	"github.com/audetv/hex-ecample/reguser/internal/api/server"
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
+	"github.com/audetv/hex-ecample/reguser/internal/app/config"
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/operator"
 	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
 
 	"github.com/audetv/hex-ecample/reguser/internal/app/starter"
+	"github.com/audetv/hex-ecample/reguser/internal/db/file/operatorfilestore"
+	"github.com/audetv/hex-ecample/reguser/internal/db/file/userfilestore"
+	"github.com/audetv/hex-ecample/reguser/internal/db/mem/operatormemstore"
 	"github.com/audetv/hex-ecample/reguser/internal/db/mem/usermemstore"
+	"github.com/audetv/hex-ecample/reguser/internal/db/pgstore"
+	"github.com/audetv/hex-ecample/reguser/internal/db/sqlitestore"
+	"github.com/audetv/hex-ecample/reguser/internal/libs/jwt"
 )
 
 func main() {
+	cfg, err := config.Load(os.Args[1:], os.Getenv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.PrintConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	// log.Fatal только после run, чтобы приложение успело остановить компоненты
+	if err := run(cfg); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(cfg *config.Config) error {
 	// Создадим глобальный стартовый контекст, относительно бэкграунд контекста,
 	// он будет прерываем по ctrl+c
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
 
-	ust := usermemstore.NewUsers()
+	ust, err := newUserStore(ctx, cfg.Store)
+	if err != nil {
+		return err
+	}
+	a, err := newApp(ctx, cfg, ust)
+	if err != nil {
+		// приложение не запущено и хранилище не закроет, а в нем могут быть не сброшенные на диск записи
+		closeStore(ust)
+		return err
+	}
+	// Serve вернется по ctrl+c или если какой-то компонент упал, хранилище закрывается при остановке
+	return a.Serve(ctx)
+}
+
+// newApp собирает приложение вокруг открытого хранилища пользователей
+func newApp(ctx context.Context, cfg *config.Config, ust user.UserStore) (*starter.App, error) {
 	a := starter.NewApp(ust)
+	// хранилище добавляем первым, тогда оно закроется последним, после остановки сервера
+	if c, ok := ust.(io.Closer); ok {
+		a.Add("user store", starter.Closer(c), 0)
+	}
 	us := user.NewUsers(ust)
 
-	h := handler.NewRouter(us)
+	ost, err := newOperatorStore(cfg.Auth)
+	if err != nil {
+		return nil, err
+	}
+	ops := operator.NewOperators(ost, cfg.Auth.BcryptCost)
+	if err := bootstrapOperator(ctx, ops, cfg.Auth); err != nil {
+		return nil, err
+	}
+
+	auths, err := authenticators(ops, us, cfg)
+	if err != nil {
+		return nil, err
+	}
+	h := handler.NewRouter(us, ops, auths...)
+	h.AllowOrigins(cfg.Server.WatchOrigins...)
+
+	srv := server.NewServer(cfg.Server.Addr, h, server.Timeouts{
+		Read:       cfg.Server.ReadTimeout.Duration,
+		Write:      cfg.Server.WriteTimeout.Duration,
+		ReadHeader: cfg.Server.ReadHeaderTimeout.Duration,
+		Shutdown:   cfg.Server.ShutdownTimeout.Duration,
+	})
+	if cfg.Server.TLS.CertFile != "" {
+		if err := srv.EnableTLS(tlsConfig(cfg.Server.TLS)); err != nil {
+			return nil, err
+		}
+	}
+
+	srv.RegisterOnShutdown(h.Shutdown)
+
+	a.AddHTTPServer("http", srv, cfg.Server.ShutdownTimeout.Duration)
+	return a, nil
+}
+
+// closeStore закрывает хранилище, если его есть чем закрыть
+func closeStore(st interface{}) {
+	if c, ok := st.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Printf("close store: %v", err)
+		}
+	}
+}
 
-	srv := server.NewServer(":8000", h)
+// newUserStore хранилище пользователей по store.type с политикой уникальности имен из store.unique_names.
+// Если хранилище открылось, но не настроилось, оно закрывается здесь же.
+func newUserStore(ctx context.Context, cfg config.StoreConfig) (user.UserStore, error) {
+	names, err := user.ParseNamePolicy(cfg.UniqueNames)
+	if err != nil {
+		return nil, fmt.Errorf("store.unique_names: %w", err)
+	}
+	ust, err := openUserStore(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	nst, ok := ust.(user.NamePolicyStore)
+	if !ok {
+		if names == user.NamesAny {
+			return ust, nil
+		}
+		closeStore(ust)
+		return nil, fmt.Errorf("store %s does not support unique names", cfg.Type)
+	}
+	if err := nst.SetNamePolicy(ctx, names); err != nil {
+		closeStore(ust)
+		return nil, fmt.Errorf("%s store: %w", cfg.Type, err)
+	}
+	return ust, nil
+}
 
-	// Канцелим контекст потом дожидаемся всех горутин
-	wg := &sync.WaitGroup{}
-	wg.Add(1)
+// openUserStore открывает хранилище пользователей по store.type, по умолчанию в памяти
+func openUserStore(ctx context.Context, cfg config.StoreConfig) (user.UserStore, error) {
+	switch cfg.Type {
+	case config.StoreFile:
+		mode := map[string]userfilestore.SyncMode{
+			config.SyncAlways:   userfilestore.SyncAlways,
+			config.SyncInterval: userfilestore.SyncInterval,
+			config.SyncNever:    userfilestore.SyncNever,
+		}[cfg.Sync]
+		ust, err := userfilestore.NewUsers(userfilestore.Config{
+			Path:         cfg.Path,
+			Sync:         mode,
+			SyncInterval: cfg.SyncInterval.Duration,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("open file store: %w", err)
+		}
+		return ust, nil
+	case config.StoreSQLite:
+		ust, err := sqlitestore.NewUsers(ctx, cfg.Path)
+		if err != nil {
+			return nil, fmt.Errorf("open sqlite store: %w", err)
+		}
+		return ust, nil
+	case config.StorePostgres:
+		ust, err := pgstore.NewUsers(ctx, cfg.DSN)
+		if err != nil {
+			return nil, fmt.Errorf("open postgres store: %w", err)
+		}
+		return ust, nil
+	}
+	return usermemstore.NewUsers(), nil
+}
 
-	go a.Serve(ctx, wg, srv)
+// newOperatorStore операторы хранятся в файле, если он задан, иначе в памяти
+func newOperatorStore(cfg config.AuthConfig) (operator.OperatorStore, error) {
+	if cfg.OperatorsFile == "" {
+		return operatormemstore.NewOperators(), nil
+	}
+	ost, err := operatorfilestore.NewOperators(cfg.OperatorsFile)
+	if err != nil {
+		return nil, fmt.Errorf("open operators file: %w", err)
+	}
+	return ost, nil
+}
+
+// bootstrapOperator заводит первого оператора со всеми правами,
+// иначе в пустое хранилище операторов некому будет зайти. Если оператор уже есть, ничего не меняем.
+func bootstrapOperator(ctx context.Context, ops *operator.Operators, cfg config.AuthConfig) error {
+	if cfg.AdminLogin == "" {
+		return nil
+	}
+	_, err := ops.Create(auth.WithSystem(ctx), cfg.AdminLogin, cfg.AdminPassword, auth.PermAll)
+	if err != nil && !errors.Is(err, operator.ErrExists) {
+		return fmt.Errorf("create operator %q: %w", cfg.AdminLogin, err)
+	}
+	return nil
+}
+
+// tlsConfig настройки https сервера из конфигурации
+func tlsConfig(cfg config.TLSConfig) server.TLSConfig {
+	clientAuth := tls.VerifyClientCertIfGiven
+	if cfg.ClientAuth == config.ClientAuthRequire {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	return server.TLSConfig{
+		CertFile:       cfg.CertFile,
+		KeyFile:        cfg.KeyFile,
+		ClientCAFile:   cfg.ClientCAFile,
+		ClientAuth:     clientAuth,
+		ReloadInterval: cfg.ReloadInterval.Duration,
+	}
+}
 
-	<-ctx.Done()
-	cancel()
-	wg.Wait()
+// authenticators basic auth операторов всегда, клиентские сертификаты при mTLS,
+// а JWT если задан файл с ключами
+func authenticators(ops *operator.Operators, us *user.Users, c *config.Config) ([]handler.Authenticator, error) {
+	auths := []handler.Authenticator{handler.NewBasicAuthenticator(ops)}
+	if c.Server.TLS.ClientCAFile != "" {
+		auths = append(auths, handler.NewClientCertAuthenticator(ops))
+	}
+	cfg := c.Auth.JWT
+	if cfg.JWKS == "" {
+		return auths, nil
+	}
+	ks, err := jwt.LoadJWKS(cfg.JWKS)
+	if err != nil {
+		return nil, fmt.Errorf("load jwks: %w", err)
+	}
+	v := jwt.NewVerifier(jwt.Config{
+		Keys:     ks,
+		Issuer:   cfg.Issuer,
+		Audience: cfg.Audience,
+		Leeway:   cfg.Leeway.Duration,
+	})
+	return append(auths, handler.NewBearerAuthenticator(v, us)), nil
 }
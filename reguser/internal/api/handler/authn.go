@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/operator"
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/audetv/hex-ecample/reguser/internal/libs/jwt"
+	"github.com/google/uuid"
+)
+
+// ErrNoCredentials в запросе нет учетных данных того вида, который понимает Authenticator,
+// тогда AuthMiddleware пробует следующий в цепочке.
+var ErrNoCredentials = errors.New("no credentials")
+
+// ErrUnauthorized учетные данные есть, но они неверные
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Authenticator один способ аутентификации в цепочке AuthMiddleware.
+// Scheme схема для заголовка WWW-Authenticate в ответе 401.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*auth.Principal, error)
+	Scheme() string
+}
+
+// BasicAuthenticator логин и пароль оператора через basic auth
+type BasicAuthenticator struct {
+	ops *operator.Operators
+}
+
+func NewBasicAuthenticator(ops *operator.Operators) *BasicAuthenticator {
+	return &BasicAuthenticator{ops: ops}
+}
+
+func (a *BasicAuthenticator) Scheme() string {
+	return `Basic realm="reguser"`
+}
+
+func (a *BasicAuthenticator) Authenticate(r *http.Request) (*auth.Principal, error) {
+	u, p, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	o, err := a.ops.Authenticate(r.Context(), u, p)
+	if err != nil {
+		if errors.Is(err, operator.ErrInvalidCredentials) {
+			return nil, ErrUnauthorized
+		}
+		return nil, err
+	}
+	return &auth.Principal{
+		Subject:     o.Login,
+		Method:      auth.MethodBasic,
+		Permissions: o.Permissions,
+	}, nil
+}
+
+// ClientCertAuthenticator клиентский сертификат, проверенный TLS по CA из настроек сервера.
+// CommonName субъекта сертификата это логин оператора, права берутся у оператора.
+type ClientCertAuthenticator struct {
+	ops *operator.Operators
+}
+
+func NewClientCertAuthenticator(ops *operator.Operators) *ClientCertAuthenticator {
+	return &ClientCertAuthenticator{ops: ops}
+}
+
+func (a *ClientCertAuthenticator) Scheme() string {
+	return `Mutual realm="reguser"`
+}
+
+func (a *ClientCertAuthenticator) Authenticate(r *http.Request) (*auth.Principal, error) {
+	// VerifiedChains пуст, если сертификата нет или его не проверяли,
+	// неподтвержденным PeerCertificates верить нельзя
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredentials
+	}
+	cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if cn == "" {
+		return nil, ErrUnauthorized
+	}
+	o, err := a.ops.AuthenticateCert(r.Context(), cn)
+	if err != nil {
+		if errors.Is(err, operator.ErrInvalidCredentials) {
+			return nil, ErrUnauthorized
+		}
+		return nil, err
+	}
+	return &auth.Principal{
+		Subject:     o.Login,
+		Method:      auth.MethodClientCert,
+		Permissions: o.Permissions,
+	}, nil
+}
+
+// BearerAuthenticator подписанный JWT в заголовке Authorization: Bearer.
+// Права берутся из claim permissions (массив имен прав), а если его нет и sub это ID
+// зарегистрированного пользователя, то из прав этого пользователя в хранилище.
+type BearerAuthenticator struct {
+	v  *jwt.Verifier
+	us *user.Users
+}
+
+func NewBearerAuthenticator(v *jwt.Verifier, us *user.Users) *BearerAuthenticator {
+	return &BearerAuthenticator{v: v, us: us}
+}
+
+func (a *BearerAuthenticator) Scheme() string {
+	return `Bearer realm="reguser"`
+}
+
+func (a *BearerAuthenticator) Authenticate(r *http.Request) (*auth.Principal, error) {
+	h := r.Header.Get("Authorization")
+	const prefix = "bearer "
+	if len(h) < len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return nil, ErrNoCredentials
+	}
+	c, err := a.v.Verify(strings.TrimSpace(h[len(prefix):]))
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+	if c.Subject() == "" {
+		return nil, ErrUnauthorized
+	}
+	perms, err := a.permissions(r, c)
+	if err != nil {
+		return nil, err
+	}
+	return &auth.Principal{
+		Subject:     c.Subject(),
+		Method:      auth.MethodBearer,
+		Claims:      c,
+		Permissions: perms,
+	}, nil
+}
+
+func (a *BearerAuthenticator) permissions(r *http.Request, c jwt.Claims) (int, error) {
+	if raw, ok := c["permissions"].([]interface{}); ok {
+		names := make([]string, 0, len(raw))
+		for _, n := range raw {
+			s, _ := n.(string)
+			names = append(names, s)
+		}
+		perms, err := auth.ParsePermissions(names)
+		if err != nil {
+			return 0, ErrUnauthorized
+		}
+		return perms, nil
+	}
+	uid, err := uuid.Parse(c.Subject())
+	if err != nil {
+		return 0, nil
+	}
+	// Вызывающий еще не аутентифицирован, права для токена читает само приложение
+	u, err := a.us.Read(auth.WithSystem(r.Context()), uid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return u.Permissions, nil
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/audetv/hex-ecample/reguser/internal/db/mem/usermemstore"
+	"github.com/audetv/hex-ecample/reguser/internal/libs/jwt"
+)
+
+func hs256Token(secret []byte, claims string) string {
+	b := base64.RawURLEncoding.EncodeToString
+	s := b([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + b([]byte(claims))
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(s))
+	return s + "." + b(m.Sum(nil))
+}
+
+func TestRouter_AuthMiddleware(t *testing.T) {
+	secret := []byte("secret")
+	v := jwt.NewVerifier(jwt.Config{
+		Keys:     &jwt.KeySet{Keys: []jwt.Key{jwt.NewHMACKey("", secret)}},
+		Audience: "reguser",
+	})
+	base := newTestRouter(t, user.NewUsers(usermemstore.NewUsers()))
+	rt := NewRouter(base.us, base.ops, NewBasicAuthenticator(base.ops), NewBearerAuthenticator(v, base.us), NewClientCertAuthenticator(base.ops))
+
+	var got *auth.Principal
+	h := rt.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = auth.PrincipalFrom(r.Context())
+	}))
+
+	exp := time.Now().Add(time.Minute).Unix()
+	valid := hs256Token(secret, `{"sub":"svc","aud":"reguser","exp":`+strconv.FormatInt(exp, 10)+`}`)
+	expired := hs256Token(secret, `{"sub":"svc","aud":"reguser","exp":1}`)
+
+	// цепочку проверяет TLS, обработчику достаточно VerifiedChains
+	verified := func(cn string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: cn}}}}}
+		}
+	}
+	unverified := func(r *http.Request) {
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "admin"}}}}
+	}
+
+	cases := []struct {
+		name    string
+		set     func(r *http.Request)
+		code    int
+		subject string
+		method  string
+	}{
+		{"basic", func(r *http.Request) { r.SetBasicAuth("admin", "admin") }, http.StatusOK, "admin", auth.MethodBasic},
+		{"bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+valid) }, http.StatusOK, "svc", auth.MethodBearer},
+		{"expired", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+expired) }, http.StatusUnauthorized, "", ""},
+		{"wrong password", func(r *http.Request) { r.SetBasicAuth("admin", "wrong") }, http.StatusUnauthorized, "", ""},
+		{"client cert", verified("admin"), http.StatusOK, "admin", auth.MethodClientCert},
+		{"unknown client cert", verified("nobody"), http.StatusUnauthorized, "", ""},
+		{"unverified client cert", unverified, http.StatusUnauthorized, "", ""},
+		{"none", func(r *http.Request) {}, http.StatusUnauthorized, "", ""},
+	}
+	for _, tt := range cases {
+		got = nil
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/", nil)
+		tt.set(r)
+		h.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			if n := len(w.Header().Values("WWW-Authenticate")); n != 3 {
+				t.Errorf("%s: %d WWW-Authenticate headers, want 3", tt.name, n)
+			}
+			continue
+		}
+		if got == nil || got.Subject != tt.subject || got.Method != tt.method {
+			t.Errorf("%s: principal %+v", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MaxBodySize наибольший размер тела запроса в байтах, запрос больше отклоняется с 413
+const MaxBodySize = 64 << 10
+
+var (
+	errBodyTooLarge = errors.New("request body too large")
+	errTrailingData = errors.New("request body must contain a single JSON value")
+)
+
+// unknownFieldsError в теле есть поля, которых нет в API, Fields все такие поля по алфавиту
+type unknownFieldsError struct {
+	Fields []string
+}
+
+func (e *unknownFieldsError) Error() string {
+	return "unknown fields: " + strings.Join(e.Fields, ", ")
+}
+
+// limitedReader как io.LimitReader, но о превышении сообщает ошибкой errBodyTooLarge, а не концом тела,
+// иначе обрезанный JSON выглядел бы как синтаксическая ошибка клиента.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.n {
+		n, l.n = int(l.n), -1
+		return n, errBodyTooLarge
+	}
+	l.n -= int64(n)
+	return n, err
+}
+
+// body тело запроса не больше MaxBodySize, по заявленному Content-Length отказывает сразу
+func body(r *http.Request) io.Reader {
+	if r.ContentLength > MaxBodySize {
+		return &limitedReader{r: r.Body, n: -1}
+	}
+	return &limitedReader{r: r.Body, n: MaxBodySize}
+}
+
+// decodeJSON строгий разбор тела: ровно одно JSON значение, неизвестные поля структуры это ошибка
+func decodeJSON(rd io.Reader, v interface{}) error {
+	dec := json.NewDecoder(rd)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		// encoding/json не дает отдельного типа для неизвестного поля, только текст ошибки
+		if s := strings.TrimPrefix(err.Error(), "json: unknown field "); s != err.Error() {
+			if name, uerr := strconv.Unquote(s); uerr == nil {
+				return &unknownFieldsError{Fields: []string{name}}
+			}
+		}
+		return err
+	}
+	if err := dec.Decode(&json.RawMessage{}); err != io.EOF {
+		if errors.Is(err, errBodyTooLarge) {
+			return err
+		}
+		return errTrailingData
+	}
+	return nil
+}
+
+// checkFields проверяет ключи разобранного в map тела, все неизвестные возвращает одной ошибкой
+func checkFields(m map[string]json.RawMessage, known ...string) error {
+	var unknown []string
+next:
+	for k := range m {
+		for _, f := range known {
+			if k == f {
+				continue next
+			}
+		}
+		unknown = append(unknown, k)
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return &unknownFieldsError{Fields: unknown}
+}
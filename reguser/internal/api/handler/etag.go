@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+)
+
+// maxIfMatch сколько разных версий можно перечислить в If-Match, каждую проверяет отдельная попытка изменения
+const maxIfMatch = 16
+
+var (
+	errNoIfMatch      = errors.New("If-Match has no current ETag of the user")
+	errTooManyIfMatch = errors.New("too many ETags in If-Match")
+)
+
+// etag сильный ETag карточки, это ее версия в кавычках
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ifMatch ожидаемые версии карточки из заголовка If-Match, пустой список если заголовка нет или в нем *.
+// По RFC 9110 список через запятую совпадает, если совпал хоть один сильный ETag. Слабые и чужие ETag
+// с нашими никогда не совпадут, их пропускаем, а если не осталось ни одного, то errNoIfMatch, это 412.
+func ifMatch(r *http.Request) ([]int64, error) {
+	h := strings.TrimSpace(r.Header.Get("If-Match"))
+	if h == "" || h == "*" {
+		return nil, nil
+	}
+	var versions []int64
+	seen := map[int64]bool{}
+	for _, tag := range strings.Split(h, ",") {
+		tag = strings.TrimSpace(tag)
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			continue
+		}
+		v, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+		if err != nil || v < 1 || seen[v] {
+			continue
+		}
+		seen[v] = true
+		versions = append(versions, v)
+	}
+	if len(versions) == 0 {
+		return nil, errNoIfMatch
+	}
+	if len(versions) > maxIfMatch {
+		return nil, errTooManyIfMatch
+	}
+	return versions, nil
+}
+
+// eachVersion вызывает изменение f с версиями из If-Match по очереди, пока версия не совпадет с версией карточки.
+// Каждая попытка это compare-and-swap в хранилище, поэтому применится не больше одной.
+// Без If-Match f вызывается один раз с 0, то есть без проверки версии.
+func eachVersion(versions []int64, f func(version int64) error) error {
+	if len(versions) == 0 {
+		return f(0)
+	}
+	var err error
+	for _, v := range versions {
+		if err = f(v); !errors.Is(err, user.ErrVersionMismatch) {
+			return err
+		}
+	}
+	return err
+}
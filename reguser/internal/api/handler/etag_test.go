@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/audetv/hex-ecample/reguser/internal/db/mem/usermemstore"
+)
+
+func TestIfMatch(t *testing.T) {
+	cases := []struct {
+		header   string
+		versions string
+		err      error
+	}{
+		{"", "", nil},
+		{"*", "", nil},
+		{`"7"`, "7", nil},
+		{` "7" `, "7", nil},
+		{`"7", "8"`, "7,8", nil},
+		{`"7","8", "7"`, "7,8", nil},
+		{`W/"6", "7", "abc"`, "7", nil},
+		{`W/"7"`, "", errNoIfMatch},
+		{`"0"`, "", errNoIfMatch},
+		{`"abc"`, "", errNoIfMatch},
+		{`7`, "", errNoIfMatch},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("PUT", "/", nil)
+		r.Header.Set("If-Match", c.header)
+		v, err := ifMatch(r)
+		got := make([]string, 0, len(v))
+		for _, n := range v {
+			got = append(got, strconv.FormatInt(n, 10))
+		}
+		if strings.Join(got, ",") != c.versions || !errors.Is(err, c.err) {
+			t.Errorf("%q: versions %v, err %v", c.header, v, err)
+		}
+	}
+
+	r := httptest.NewRequest("PUT", "/", nil)
+	r.Header.Set("If-Match", manyETags())
+	if _, err := ifMatch(r); !errors.Is(err, errTooManyIfMatch) {
+		t.Errorf("too many ETags: %v", err)
+	}
+}
+
+// manyETags список из maxIfMatch+1 разных ETag
+func manyETags() string {
+	many := make([]string, 0, maxIfMatch+1)
+	for i := 1; i <= maxIfMatch+1; i++ {
+		many = append(many, etag(int64(i)))
+	}
+	return strings.Join(many, ", ")
+}
+
+func TestRouter_ETag(t *testing.T) {
+	rt := newTestRouter(t, user.NewUsers(usermemstore.NewUsers()))
+	do := func(method, path, ifMatch, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.SetBasicAuth("admin", "admin")
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		rt.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("POST", "/api/v1/users", "", `{"name":"ivan"}`)
+	u := User{}
+	if err := json.NewDecoder(w.Body).Decode(&u); err != nil || w.Header().Get("ETag") != `"1"` {
+		t.Fatalf("create: ETag %q, %v", w.Header().Get("ETag"), err)
+	}
+	item := "/api/v1/users/" + u.ID.String()
+
+	steps := []struct {
+		method, path, ifMatch, body string
+		code                        int
+		etag                        string
+	}{
+		{"GET", item, "", "", http.StatusOK, `"1"`},
+		{"HEAD", item, "", "", http.StatusOK, `"1"`},
+		{"PATCH", item, `"1"`, `{"data":"moscow"}`, http.StatusOK, `"2"`},
+		// второй клиент прочитал версию 1 и не видел правку первого
+		{"PUT", item, `"1"`, `{"name":"ivan","data":"kazan"}`, http.StatusPreconditionFailed, ""},
+		{"PATCH", item, `"1"`, `{"data":"kazan"}`, http.StatusPreconditionFailed, ""},
+		{"PUT", item + "/permissions", `"1"`, `{"permissions":["read"]}`, http.StatusPreconditionFailed, ""},
+		{"DELETE", item, `"1"`, "", http.StatusPreconditionFailed, ""},
+		{"DELETE", item, `W/"2"`, "", http.StatusPreconditionFailed, ""},
+		{"PATCH", item, `W/"2", "abc"`, `{"data":"kazan"}`, http.StatusPreconditionFailed, ""},
+		{"PATCH", item, `"3", "4"`, `{"data":"kazan"}`, http.StatusPreconditionFailed, ""},
+		{"GET", item, "", "", http.StatusOK, `"2"`},
+		// список совпадает, если совпал любой сильный ETag, слабые не совпадают никогда
+		{"PUT", item + "/permissions", `W/"2", "1", "2"`, `{"permissions":["read"]}`, http.StatusOK, `"3"`},
+		// без If-Match изменения применяются как раньше
+		{"PUT", item, "", `{"name":"ivan","data":"kazan"}`, http.StatusOK, `"4"`},
+		{"PUT", item, "*", `{"name":"ivan","data":"kazan"}`, http.StatusOK, `"5"`},
+		{"PATCH", item, `"4", "5"`, `{"data":"moscow"}`, http.StatusOK, `"6"`},
+		{"PUT", item, `"3", "6"`, `{"name":"ivan","data":"kazan"}`, http.StatusOK, `"7"`},
+		{"DELETE", item, `"6", W/"7"`, "", http.StatusPreconditionFailed, ""},
+		{"DELETE", item, `"1", "7"`, "", http.StatusOK, ""},
+		{"PUT", item, "*", `{"name":"ivan"}`, http.StatusNotFound, ""},
+	}
+	for _, s := range steps {
+		w := do(s.method, s.path, s.ifMatch, s.body)
+		if w.Code != s.code || w.Header().Get("ETag") != s.etag {
+			t.Errorf("%s %s If-Match %s: status %d, ETag %q, want %d %q", s.method, s.path, s.ifMatch, w.Code, w.Header().Get("ETag"), s.code, s.etag)
+			continue
+		}
+		if w.Code != http.StatusPreconditionFailed {
+			continue
+		}
+		p := Problem{}
+		if err := json.NewDecoder(w.Body).Decode(&p); err != nil || p.Code != user.CodeVersionMismatch {
+			t.Errorf("%s %s: problem %+v", s.method, s.path, p)
+		}
+	}
+
+	if w := do("DELETE", item, manyETags(), ""); w.Code != http.StatusBadRequest {
+		t.Errorf("too many ETags: status %d", w.Code)
+	}
+
+	w = do("GET", item[:len(item)-1], "", "")
+	if w.Header().Get("ETag") != "" {
+		t.Errorf("ETag on error response: %q", w.Header().Get("ETag"))
+	}
+}
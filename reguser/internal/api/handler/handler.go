@@ -1,12 +1,14 @@
 package handler
 
 import (
-	"database/sql"
 	"encoding/json"
 	"errors"
-	"fmt"
+	"io"
 	"net/http"
+	"sync"
 
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/operator"
 	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
 	"github.com/google/uuid"
 )
@@ -16,21 +18,101 @@ import (
 
 type Router struct {
 	*http.ServeMux
-	us *user.Users
+	us    *user.Users
+	ops   *operator.Operators
+	auths []Authenticator
+	// origins сайты, кроме своего, с которых можно открыть /watch, см. AllowOrigins
+	origins []string
+
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
 }
 
-func NewRouter(us *user.Users) *Router {
+// NewRouter ops учетные записи операторов, auths цепочка аутентификации в порядке проверки,
+// если она не задана, используется только basic auth по операторам.
+func NewRouter(us *user.Users, ops *operator.Operators, auths ...Authenticator) *Router {
+	if len(auths) == 0 {
+		auths = []Authenticator{NewBasicAuthenticator(ops)}
+	}
 	r := &Router{
 		ServeMux: http.NewServeMux(),
 		us:       us,
+		ops:      ops,
+		auths:    auths,
+		shutdown: make(chan struct{}),
+	}
+	// Ресурсы пользователей, метод выбирает действие, см. route.go
+	users := methods{http.MethodGet: r.ListUsers, http.MethodPost: r.CreateUser}
+	item := methods{
+		http.MethodGet:    r.ReadUser,
+		http.MethodPut:    r.UpdateUser,
+		http.MethodPatch:  r.UpdateUser,
+		http.MethodDelete: r.DeleteUser,
 	}
-	r.HandleFunc("/create", r.AuthMiddleware(http.HandlerFunc(r.CreateUser)).ServeHTTP)
-	r.HandleFunc("/read", r.AuthMiddleware(http.HandlerFunc(r.ReadUser)).ServeHTTP)
-	r.HandleFunc("/delete", r.AuthMiddleware(http.HandlerFunc(r.DeleteUser)).ServeHTTP)
-	r.HandleFunc("/search", r.AuthMiddleware(http.HandlerFunc(r.SearchUser)).ServeHTTP)
+	perms := methods{http.MethodPut: r.SetPermissions}
+	search := methods{http.MethodGet: r.SearchUser}
+	watch := methods{http.MethodGet: r.Watch}
+
+	r.Handle(APIPrefix+"/users", r.AuthMiddleware(users))
+	r.Handle(APIPrefix+"/users/", r.AuthMiddleware(subtree{
+		prefix: APIPrefix + "/users/",
+		routes: map[string]http.Handler{"": item, "permissions": perms},
+	}))
+	r.Handle(APIPrefix+"/search", r.AuthMiddleware(search))
+	r.Handle(APIPrefix+"/watch", r.AuthMiddleware(watch))
+
+	// Старые пути с id в параметре uid работают как раньше: права битовой маской, ошибки текстом, см. legacy.go.
+	// Они помечены устаревшими, аутентификация внутри legacy, чтобы и 401 был в старом формате.
+	r.Handle("/create", legacy(APIPrefix+"/users", r.AuthMiddleware(methods{http.MethodPost: r.CreateUser})))
+	r.Handle("/read", legacy(APIPrefix+"/users/{id}", r.AuthMiddleware(methods{http.MethodGet: r.ReadUser})))
+	r.Handle("/update", legacy(APIPrefix+"/users/{id}", r.AuthMiddleware(methods{
+		http.MethodPut:   r.UpdateUser,
+		http.MethodPatch: r.UpdateUser,
+	})))
+	r.Handle("/permissions", legacy(APIPrefix+"/users/{id}/permissions", r.AuthMiddleware(perms)))
+	r.Handle("/delete", legacy(APIPrefix+"/users/{id}", r.AuthMiddleware(methods{http.MethodDelete: r.DeleteUser})))
+	r.Handle("/users", legacy(APIPrefix+"/users", r.AuthMiddleware(methods{http.MethodGet: r.ListUsers})))
+	r.Handle("/search", legacy(APIPrefix+"/search", r.AuthMiddleware(search)))
+	r.Handle("/watch", legacy(APIPrefix+"/watch", r.AuthMiddleware(watch)))
+
+	// Операторы так же: список и заведение, а изменения по логину в пути /api/v1/operators/{login}/...
+	// На старых путях логин в теле запроса, а изменения только POST.
+	r.Handle(APIPrefix+"/operators", r.AuthMiddleware(methods{
+		http.MethodGet:  r.ListOperators,
+		http.MethodPost: r.CreateOperator,
+	}))
+	r.Handle(APIPrefix+"/operators/", r.AuthMiddleware(subtree{
+		prefix: APIPrefix + "/operators/",
+		routes: map[string]http.Handler{
+			"password":    methods{http.MethodPut: r.SetOperatorPassword},
+			"permissions": methods{http.MethodPut: r.SetOperatorPermissions},
+			"disabled":    methods{http.MethodPut: r.SetOperatorDisabled},
+		},
+	}))
+
+	r.Handle("/operators", legacy(APIPrefix+"/operators", r.AuthMiddleware(methods{http.MethodGet: r.ListOperators})))
+	r.Handle("/operators/create", legacy(APIPrefix+"/operators", r.AuthMiddleware(methods{http.MethodPost: r.CreateOperator})))
+	r.Handle("/operators/password", legacy(APIPrefix+"/operators/{id}/password",
+		r.AuthMiddleware(methods{http.MethodPost: r.SetOperatorPassword})))
+	r.Handle("/operators/permissions", legacy(APIPrefix+"/operators/{id}/permissions",
+		r.AuthMiddleware(methods{http.MethodPost: r.SetOperatorPermissions})))
+	r.Handle("/operators/disable", legacy(APIPrefix+"/operators/{id}/disabled",
+		r.AuthMiddleware(methods{http.MethodPost: r.SetOperatorDisabled})))
 	return r
 }
 
+// AllowOrigins разрешает открывать /watch из браузера со страниц этих сайтов, например https://admin.example.com.
+// Со своего сайта, где Origin совпадает с Host, и не из браузера, без Origin, можно всегда.
+func (rt *Router) AllowOrigins(origins ...string) {
+	rt.origins = origins
+}
+
+// Shutdown закрывает подписки /watch с кодом 1001. Их соединения захвачены у http сервера,
+// поэтому http.Server.Shutdown их не ждет и не закрывает, вызывать вместе с ним.
+func (rt *Router) Shutdown() {
+	rt.shutdownOnce.Do(func() { close(rt.shutdown) })
+}
+
 // User - реализует отдельную структуру, которая не зависит от бизнес логики.
 // Используем ее для получения данных юзера от клиента или отправки данных клиенту
 // Парсим, декодируем.
@@ -38,7 +120,22 @@ type User struct {
 	ID          uuid.UUID `json:"id"`
 	Name        string    `json:"name"`
 	Data        string    `json:"data"`
-	Permissions int       `json:"permissions"`
+	Permissions []string  `json:"permissions"`
+}
+
+// FoundUser результат поиска, поля пользователя и релевантность на одном уровне
+type FoundUser struct {
+	User
+	Score float64 `json:"score"`
+}
+
+// SearchError последний элемент массива /search, если поиск оборвался на середине выдачи.
+// Статус 200 к этому моменту уже отправлен, поэтому клиент узнает об ошибке только по этому объекту,
+// а найденные до него пользователи это неполный результат.
+type SearchError struct {
+	Error     string `json:"error"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
 }
 
 // AuthMiddleware принимает next http.Handler и возвращает http.Handler
@@ -48,43 +145,52 @@ func (rt *Router) AuthMiddleware(next http.Handler) http.Handler {
 		func(w http.ResponseWriter, r *http.Request) {
 			// Проверяем авторизацию, если нет то 401 и выходим, а если все хорошо, то пробрасываем
 			// writer и reader дальше в next обработчик. Такими замыканиями можно выстроить целую цепочку из middlware,
-			// которые что-то делаю, до того как основные хэндлеры получат writer и reader
-			if u, p, ok := r.BasicAuth(); !ok || !(u == "admin" && p == "admin") {
-				http.Error(w, "unauthorized", http.StatusUnauthorized)
+			// которые что-то делаю, до того как основные хэндлеры получат writer и reader.
+			// Аутентификаторы пробуем по очереди, первый нашедший в запросе свои учетные данные и решает.
+			// Проверенного вызывающего кладем в контекст запроса.
+			for _, a := range rt.auths {
+				p, err := a.Authenticate(r)
+				if errors.Is(err, ErrNoCredentials) {
+					continue
+				}
+				if errors.Is(err, ErrUnauthorized) {
+					break
+				}
+				if err != nil {
+					logError(r, err)
+					writeProblem(w, r, http.StatusInternalServerError, user.CodeInternal, "error when authenticating", nil)
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), p)))
 				return
 			}
-			next.ServeHTTP(w, r)
+			for _, a := range rt.auths {
+				w.Header().Add("WWW-Authenticate", a.Scheme())
+			}
+			writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "", nil)
 		},
 	)
 }
 
+// CreateUser POST /api/v1/users
 func (rt *Router) CreateUser(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
 	// Body нужно закрывать, если начали из него читать, по умолчанию в handler в r http.Request приходят только заголовки
 	// На бади можно не смотреть и работать и читать только заголовки, а оставшееся тело будет проигнорировано
 	// и не будет даже загружено в память, если начинаем работать с телом, то реквест превращается в такой объект,
 	// который аллоцирует память, т.е уже начинается накопление в памяти и по завершению этого хзндлера,
 	// го должен явно знать, что мы закончили с ним работать, т.е его надо явно закрыть.
-	dec := json.NewDecoder(r.Body)
+	// Тело читаем не больше MaxBodySize и строго: неизвестные поля это ошибка клиента, а не молча потерянные данные.
 	defer r.Body.Close()
-	u := User{}
-	if err := dec.Decode(&u); err != nil {
-		http.Error(w, "bad request", http.StatusBadRequest)
+	bu, err := decodeUser(r)
+	if err != nil {
+		badUser(w, r, err)
 		return
 	}
-
-	bu := user.User{
-		Name: u.Name,
-		Data: u.Data,
-	}
 	// У каждого запроса приходящего есть контекст внутри и его мы можем
 	// использовать и пробрасывать дальше в нужные нам методы, этот контекст канцелится если мы остановим сервер.
 	nbu, err := rt.us.Create(r.Context(), bu)
 	if err != nil {
-		http.Error(w, "error when creating user", http.StatusInternalServerError)
+		userError(w, r, err, "error when creating user")
 		return
 	}
 	// Если создание пользователя произошло корректно, появляется заполненный айди у юзера,
@@ -93,112 +199,203 @@ func (rt *Router) CreateUser(w http.ResponseWriter, r *http.Request) {
 	// поток прервался, сетевое соединение, но тогда нам не о чем и некому сообщать возвращать эту ошибку,
 	// разве что залогировать. Но при успешном создании нужно вернуть код 201 Created,
 	// по умолчанию Encode возвращает код 200 OK, для этого надо указать код ответа.
+	w.Header().Set("ETag", etag(nbu.Version))
 	w.WriteHeader(http.StatusCreated)
-	_ = json.NewEncoder(w).Encode(User{
-		ID:          nbu.ID,
-		Name:        nbu.Name,
-		Data:        nbu.Data,
-		Permissions: nbu.Permissions,
-	})
+	_ = json.NewEncoder(w).Encode(userJSON(r, *nbu))
 }
 
 // ReadUser надо повторить проверку авторизации, сделаем middleware
-// read?uid=...
+// GET /api/v1/users/{id}, устаревший read?uid=... Версия карточки приходит в ETag,
+// его можно передать в If-Match при изменении, чтобы не затереть чужую правку.
 func (rt *Router) ReadUser(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	uid, err := userID(r)
+	if err != nil {
+		badID(w, r, err)
 		return
 	}
-
-	suid := r.URL.Query().Get("uid")
-	if suid == "" {
-		http.Error(w, "bad request", http.StatusBadRequest)
+	nbu, err := rt.us.Read(r.Context(), uid)
+	if err != nil {
+		userError(w, r, err, "error when reading user")
 		return
 	}
-	uid, err := uuid.Parse(suid)
+	w.Header().Set("ETag", etag(nbu.Version))
+	_ = json.NewEncoder(w).Encode(userJSON(r, *nbu))
+}
+
+// UpdateUser PUT или PATCH /api/v1/users/{id}, устаревший update?uid=...
+// PUT полностью заменяет карточку, PATCH принимает JSON merge patch (RFC 7396) и меняет только переданные поля.
+// С If-Match изменение применяется, только если карточка все еще в этой версии или в одной из перечисленных, иначе 412.
+func (rt *Router) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	uid, err := userID(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		badID(w, r, err)
 		return
 	}
-	if (uid == uuid.UUID{}) {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	versions, err := ifMatch(r)
+	if err != nil {
+		badIfMatch(w, r, err)
 		return
 	}
-	nbu, err := rt.us.Read(r.Context(), uid)
-	// проверяем если db вернул ошибку sql.ErrNoRows, мы пробросили ее через %w, значит она будет распознана
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			http.Error(w, "not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "error when reading user", http.StatusInternalServerError)
+
+	defer r.Body.Close()
+	var nbu *user.User
+	if r.Method == http.MethodPatch {
+		var p user.UserPatch
+		if p, err = decodeMergePatch(body(r)); err != nil {
+			badBody(w, r, err)
+			return
+		}
+		err = eachVersion(versions, func(version int64) (err error) {
+			p.Version = version
+			nbu, err = rt.us.Patch(r.Context(), uid, p)
+			return err
+		})
+	} else {
+		// права из тела не берем, их меняет только SetPermissions
+		var u user.User
+		if u, err = decodeUser(r); err != nil {
+			badUser(w, r, err)
+			return
 		}
+		err = eachVersion(versions, func(version int64) (err error) {
+			nbu, err = rt.us.Update(r.Context(), user.User{
+				ID:      uid,
+				Name:    u.Name,
+				Data:    u.Data,
+				Version: version,
+			})
+			return err
+		})
+	}
+	if err != nil {
+		userError(w, r, err, "error when updating user")
 		return
 	}
-	_ = json.NewEncoder(w).Encode(User{
-		ID:          nbu.ID,
-		Name:        nbu.Name,
-		Data:        nbu.Data,
-		Permissions: nbu.Permissions,
-	})
+	w.Header().Set("ETag", etag(nbu.Version))
+	_ = json.NewEncoder(w).Encode(userJSON(r, *nbu))
 }
 
-func (rt *Router) DeleteUser(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		return
+// decodeMergePatch разбирает тело JSON merge patch. Отсутствующее поле не меняется,
+// null по семантике merge patch удаляет поле, у нас это означает пустое значение. Поля кроме name и data это ошибка.
+func decodeMergePatch(r io.Reader) (user.UserPatch, error) {
+	p := user.UserPatch{}
+	m := map[string]json.RawMessage{}
+	if err := decodeJSON(r, &m); err != nil {
+		return p, err
+	}
+	if err := checkFields(m, "name", "data"); err != nil {
+		return p, err
+	}
+	field := func(key string) (*string, error) {
+		raw, ok := m[key]
+		if !ok {
+			return nil, nil
+		}
+		var v *string
+		if err := json.Unmarshal(raw, &v); err != nil {
+			var te *json.UnmarshalTypeError
+			if errors.As(err, &te) {
+				te.Field = key
+			}
+			return nil, err
+		}
+		if v == nil {
+			v = new(string)
+		}
+		return v, nil
 	}
+	var err error
+	if p.Name, err = field("name"); err != nil {
+		return p, err
+	}
+	if p.Data, err = field("data"); err != nil {
+		return p, err
+	}
+	return p, nil
+}
 
-	suid := r.URL.Query().Get("uid")
-	if suid == "" {
-		http.Error(w, "bad request", http.StatusBadRequest)
+// SetPermissions PUT /api/v1/users/{id}/permissions {"permissions":["read","search"]} назначение прав пользователю,
+// устаревший permissions?uid=... с маской прав {"permissions":17}
+func (rt *Router) SetPermissions(w http.ResponseWriter, r *http.Request) {
+	uid, err := userID(r)
+	if err != nil {
+		badID(w, r, err)
 		return
 	}
-	uid, err := uuid.Parse(suid)
+	versions, err := ifMatch(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		badIfMatch(w, r, err)
 		return
 	}
-	if (uid == uuid.UUID{}) {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	defer r.Body.Close()
+	u, err := decodeUser(r)
+	if err != nil {
+		badUser(w, r, err)
 		return
 	}
 
-	nbu, err := rt.us.Delete(r.Context(), uid)
-	// проверяем если db вернул ошибку sql.ErrNoRows, мы пробросили ее через %w, значит она будет распознана
+	var nbu *user.User
+	err = eachVersion(versions, func(version int64) (err error) {
+		nbu, err = rt.us.SetPermissions(r.Context(), uid, u.Permissions, version)
+		return err
+	})
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			http.Error(w, "not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "error when reading user", http.StatusInternalServerError)
-		}
+		userError(w, r, err, "error when setting permissions")
 		return
 	}
-	_ = json.NewEncoder(w).Encode(User{
-		ID:          nbu.ID,
-		Name:        nbu.Name,
-		Data:        nbu.Data,
-		Permissions: nbu.Permissions,
-	})
+	w.Header().Set("ETag", etag(nbu.Version))
+	_ = json.NewEncoder(w).Encode(userJSON(r, *nbu))
 }
 
-// SearchUser /search?q=...
-func (rt *Router) SearchUser(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+// DeleteUser DELETE /api/v1/users/{id}, устаревший delete?uid=..., If-Match как в UpdateUser
+func (rt *Router) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	uid, err := userID(r)
+	if err != nil {
+		badID(w, r, err)
+		return
+	}
+	versions, err := ifMatch(r)
+	if err != nil {
+		badIfMatch(w, r, err)
+		return
+	}
+
+	var nbu *user.User
+	err = eachVersion(versions, func(version int64) (err error) {
+		nbu, err = rt.us.Delete(r.Context(), uid, version)
+		return err
+	})
+	if err != nil {
+		userError(w, r, err, "error when deleting user")
 		return
 	}
+	_ = json.NewEncoder(w).Encode(userJSON(r, *nbu))
+}
 
+// SearchUser GET /api/v1/search?q=...&mode=... запрос на языке поиска из user/query.go, например name:ivan* AND -permissions:0,
+// mode exact (по умолчанию, с учетом регистра), fold, prefix или fuzzy, см. user/rank.go.
+// В prefix и fuzzy найденные идут по убыванию score, не больше user.MaxRanked лучших, в остальных score 1.
+// Формат по Accept: JSON массив (по умолчанию), NDJSON или SSE, см. stream.go. Если хранилище сломалось
+// посреди выдачи, она заканчивается объектом SearchError.
+func (rt *Router) SearchUser(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query().Get("q")
 	if q == "" {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		badRequest(w, r, user.CodeValidation, "missing search query",
+			user.FieldError{Field: "q", Code: "required", Message: "q must not be empty"})
+		return
+	}
+	media := negotiate(r.Header.Get("Accept"))
+	if media == "" {
+		writeProblem(w, r, http.StatusNotAcceptable, CodeNotAcceptable,
+			"use "+MediaJSON+", "+MediaNDJSON+" or "+MediaSSE, nil)
 		return
 	}
 	// передаем контекст и строку запроса q и возвращается канал ch, в котором мы будем стримить юзеров
-	ch, err := rt.us.SearchUsers(r.Context(), q)
-	// Ошибка может произойти если она в самом сторе произошла.
-	// Там она возникает, только если мы в закрытом контексте находимся, по большому счету ее можно и проскипать.
+	ch, err := rt.us.SearchUsers(r.Context(), q, r.URL.Query().Get("mode"))
+	// Ошибка разбора запроса это ошибка клиента, текст подскажет где именно, userError отдаст ее как 400.
+	// Остальные ошибки от стора, там она возникает, если мы в закрытом контексте находимся.
 	if err != nil {
-		http.Error(w, "error when searching", http.StatusInternalServerError)
+		userError(w, r, err, "error when searching")
 		return
 	}
 	// Все выполняется в горутинах, соответственно здесь у нас тоже отдельная горутина.
@@ -211,35 +408,35 @@ func (rt *Router) SearchUser(w http.ResponseWriter, r *http.Request) {
 	// если мы пытаться будем читать из этого канала. Но у нас есть два момента: есть cancel контексты,
 	// у нас есть какие-то ошибки возникающие при отправке. Делаем в бесконечном цикле через select
 
-	enc := json.NewEncoder(w)
-	first := true
-	fmt.Fprintf(w, "[")
-	defer fmt.Fprintln(w, "]")
-
+	e := newSearchEncoder(w, media)
+	e.begin()
 	for {
 		select {
 		case <-r.Context().Done():
 			return
 		case u, ok := <-ch:
 			// Если у нас закрылся канал, а нам его закрыла бизнес логика,
-			// а бизнес логика его закрыла если его закрыла база данных, то тогда return
+			// а бизнес логика его закрыла если его закрыла база данных, то тогда выдача полная
 			if !ok {
+				e.finish(nil)
+				return
+			}
+			// Ошибка приходит последним элементом, после нее канал закрывается,
+			// текст внутренней ошибки наружу не отдаем, а пишем в лог, как и в userError.
+			if u.Err != nil {
+				status, code := errorStatus(u.Err)
+				if status >= http.StatusInternalServerError {
+					logError(r, u.Err)
+				}
+				e.finish(&SearchError{Error: "error when searching", Code: code, RequestID: RequestID(r.Context())})
 				return
 			}
-			if first {
-				first = false
+			// на старом пути /search карточки без score, как было до ранжирования
+			if isLegacy(r) {
+				e.user(legacyUser(u.User))
 			} else {
-				fmt.Fprintf(w, ",")
+				e.user(FoundUser{User: apiUser(u.User), Score: u.Score})
 			}
-			_ = enc.Encode(
-				User{
-					ID:          u.ID,
-					Name:        u.Name,
-					Data:        u.Data,
-					Permissions: u.Permissions,
-				},
-			)
-			w.(http.Flusher).Flush()
 		}
 	}
 }
@@ -1,18 +1,40 @@
 package handler
 
 import (
-	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
-	"github.com/audetv/hex-ecample/reguser/internal/db/mem/usermemstore"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/operator"
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/audetv/hex-ecample/reguser/internal/db/mem/operatormemstore"
+	"github.com/audetv/hex-ecample/reguser/internal/db/mem/usermemstore"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// newTestRouter роутер с одним оператором admin:admin со всеми правами
+func newTestRouter(t *testing.T, us *user.Users) *Router {
+	t.Helper()
+	ops := operator.NewOperators(operatormemstore.NewOperators(), bcrypt.MinCost)
+	if _, err := ops.Create(auth.WithSystem(context.Background()), "admin", "admin", auth.PermAll); err != nil {
+		t.Fatal(err)
+	}
+	return NewRouter(us, ops)
+}
+
 func TestRouter_CreateUser(t *testing.T) {
 	ust := usermemstore.NewUsers()
 	us := user.NewUsers(ust)
-	rt := NewRouter(us)
+	rt := newTestRouter(t, us)
 	h := rt.AuthMiddleware(http.HandlerFunc(rt.CreateUser)).ServeHTTP
 
 	w := &httptest.ResponseRecorder{}
@@ -26,3 +48,296 @@ func TestRouter_CreateUser(t *testing.T) {
 		t.Errorf("status created")
 	}
 }
+
+func TestRouter_CreateUserValidation(t *testing.T) {
+	rt := newTestRouter(t, user.NewUsers(usermemstore.NewUsers()))
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("POST", "/api/v1/users", `{"name":"  Алексей  ","data":" moscow "}`)
+	u := User{}
+	if err := json.NewDecoder(w.Body).Decode(&u); err != nil || w.Code != http.StatusCreated || u.Name != "Алексей" || u.Data != "moscow" {
+		t.Fatalf("trimmed create: status %d, user %+v", w.Code, u)
+	}
+	item := "/api/v1/users/" + u.ID.String()
+
+	cases := []struct {
+		name, method, path, body string
+		status                   int
+		code                     string
+		fields                   []string
+	}{
+		{"all violations", "POST", "/api/v1/users", `{"name":" ","data":"a\u0000b"}`, http.StatusBadRequest, user.CodeValidation, []string{"name", "data"}},
+		{"name chars", "POST", "/api/v1/users", `{"name":"<script>"}`, http.StatusBadRequest, user.CodeValidation, []string{"name"}},
+		{"unknown field", "POST", "/api/v1/users", `{"name":"Alex","date":"user124"}`, http.StatusBadRequest, CodeInvalidBody, []string{"date"}},
+		{"trailing data", "POST", "/api/v1/users", `{"name":"a"}{"name":"b"}`, http.StatusBadRequest, CodeInvalidBody, nil},
+		{"too large", "POST", "/api/v1/users", `{"name":"a","data":"` + strings.Repeat("x", MaxBodySize) + `"}`, http.StatusRequestEntityTooLarge, CodeBodyTooLarge, nil},
+		{"put", "PUT", item, `{"name":"` + strings.Repeat("я", user.MaxNameLen+1) + `"}`, http.StatusBadRequest, user.CodeValidation, []string{"name"}},
+		{"patch unknown", "PATCH", item, `{"nmae":"x","dta":"y","data":"z"}`, http.StatusBadRequest, CodeInvalidBody, []string{"dta", "nmae"}},
+		{"patch type", "PATCH", item, `{"name":1}`, http.StatusBadRequest, CodeInvalidBody, []string{"name"}},
+		{"patch", "PATCH", item, `{"name":""}`, http.StatusBadRequest, user.CodeValidation, []string{"name"}},
+	}
+	for _, c := range cases {
+		w := do(c.method, c.path, c.body)
+		p := Problem{}
+		if err := json.NewDecoder(w.Body).Decode(&p); err != nil || w.Code != c.status || p.Code != c.code {
+			t.Errorf("%s: status %d, problem %+v", c.name, w.Code, p)
+			continue
+		}
+		var fields []string
+		for _, f := range p.Errors {
+			fields = append(fields, f.Field)
+		}
+		if strings.Join(fields, ",") != strings.Join(c.fields, ",") {
+			t.Errorf("%s: fields %v, want %v", c.name, fields, c.fields)
+		}
+	}
+}
+
+func TestRouter_CreateUserConflict(t *testing.T) {
+	ust := usermemstore.NewUsers()
+	if err := ust.SetNamePolicy(context.Background(), user.NamesFold); err != nil {
+		t.Fatal(err)
+	}
+	rt := newTestRouter(t, user.NewUsers(ust))
+	codes := []int{http.StatusCreated, http.StatusConflict}
+	for i, name := range []string{"Ivan Petrov", " ivan  PETROV"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/api/v1/users", strings.NewReader(`{"name":"`+name+`"}`))
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+		if w.Code != codes[i] {
+			t.Fatalf("%q: status %d, want %d", name, w.Code, codes[i])
+		}
+		if w.Code != http.StatusConflict {
+			continue
+		}
+		p := Problem{}
+		if err := json.NewDecoder(w.Body).Decode(&p); err != nil || p.Code != user.CodeNameTaken || len(p.Errors) != 1 || p.Errors[0].Field != "name" {
+			t.Errorf("conflict problem %+v", p)
+		}
+	}
+}
+
+func TestRouter_UpdateUser(t *testing.T) {
+	ust := usermemstore.NewUsers()
+	us := user.NewUsers(ust)
+	rt := newTestRouter(t, us)
+
+	u, err := us.Create(auth.WithSystem(context.Background()), user.User{Name: "user", Data: "data"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("PATCH", "/update?uid="+u.ID.String(), strings.NewReader(`{"data":null}`))
+	r.SetBasicAuth("admin", "admin")
+	rt.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("patch status %d", w.Code)
+	}
+	got, _ := us.Read(auth.WithSystem(context.Background()), u.ID)
+	if got.Name != "user" || got.Data != "" {
+		t.Errorf("patch result %+v", got)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("PUT", "/update?uid="+u.ID.String(), strings.NewReader(`{"name":"other","data":"new"}`))
+	r.SetBasicAuth("admin", "admin")
+	rt.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("put status %d", w.Code)
+	}
+	got, _ = us.Read(auth.WithSystem(context.Background()), u.ID)
+	if got.Name != "other" || got.Data != "new" {
+		t.Errorf("put result %+v", got)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("PUT", "/update?uid="+uuid.New().String(), strings.NewReader(`{"name":"other"}`))
+	r.SetBasicAuth("admin", "admin")
+	rt.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("put unknown status %d", w.Code)
+	}
+}
+
+func TestRouter_Permissions(t *testing.T) {
+	us := user.NewUsers(usermemstore.NewUsers())
+	rt := newTestRouter(t, us)
+	if _, err := rt.ops.Create(auth.WithSystem(context.Background()), "reader", "reader", auth.PermRead|auth.PermSearch); err != nil {
+		t.Fatal(err)
+	}
+	u, err := us.Create(auth.WithSystem(context.Background()), user.User{Name: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(login, method, path, body string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.SetBasicAuth(login, login)
+		rt.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := do("reader", "GET", "/api/v1/users/"+u.ID.String(), ""); code != http.StatusOK {
+		t.Errorf("read: %d", code)
+	}
+	if code := do("reader", "DELETE", "/api/v1/users/"+u.ID.String(), ""); code != http.StatusForbidden {
+		t.Errorf("delete without permission: %d", code)
+	}
+	if code := do("reader", "POST", "/api/v1/users", `{"name":"other"}`); code != http.StatusForbidden {
+		t.Errorf("create without permission: %d", code)
+	}
+	if code := do("reader", "PUT", "/api/v1/users/"+u.ID.String()+"/permissions", `{"permissions":["admin"]}`); code != http.StatusForbidden {
+		t.Errorf("set permissions without admin: %d", code)
+	}
+	if code := do("reader", "GET", "/operators", ""); code != http.StatusForbidden {
+		t.Errorf("list operators without admin: %d", code)
+	}
+
+	if code := do("admin", "PUT", "/api/v1/users/"+u.ID.String()+"/permissions", `{"permissions":["read","delete"]}`); code != http.StatusOK {
+		t.Fatalf("set permissions: %d", code)
+	}
+	got, err := us.Read(auth.WithSystem(context.Background()), u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Permissions != auth.PermRead|auth.PermDelete {
+		t.Errorf("stored permissions %b", got.Permissions)
+	}
+	if code := do("admin", "PUT", "/api/v1/users/"+u.ID.String()+"/permissions", `{"permissions":["fly"]}`); code != http.StatusBadRequest {
+		t.Errorf("unknown permission: %d", code)
+	}
+	if code := do("admin", "DELETE", "/api/v1/users/"+u.ID.String(), ""); code != http.StatusOK {
+		t.Errorf("delete by admin: %d", code)
+	}
+}
+
+func TestRouter_SearchUser(t *testing.T) {
+	ust := usermemstore.NewUsers()
+	for _, u := range []user.User{
+		{ID: uuid.New(), Name: "ivan", Data: "moscow"},
+		{ID: uuid.New(), Name: "ivanov", Data: "kazan", Permissions: auth.PermRead},
+	} {
+		if _, err := ust.Create(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rt := newTestRouter(t, user.NewUsers(ust))
+
+	get := func(q string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/api/v1/search?q="+url.QueryEscape(q), nil)
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get(`name:ivan* AND data:"moscow" -permissions:read`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	var found []User
+	if err := json.NewDecoder(w.Body).Decode(&found); err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Name != "ivan" {
+		t.Errorf("found %v", found)
+	}
+	if w := get("name:(ivan"); w.Code != http.StatusBadRequest {
+		t.Errorf("bad query: status %d", w.Code)
+	}
+
+	r := httptest.NewRequest("GET", "/api/v1/search?q=IVANO&mode=fuzzy", nil)
+	r.SetBasicAuth("admin", "admin")
+	w = httptest.NewRecorder()
+	rt.ServeHTTP(w, r)
+	var ranked []FoundUser
+	if err := json.NewDecoder(w.Body).Decode(&ranked); err != nil {
+		t.Fatal(err)
+	}
+	if len(ranked) != 2 || ranked[0].Name != "ivanov" || ranked[0].Score <= ranked[1].Score {
+		t.Errorf("ranked %+v", ranked)
+	}
+	r = httptest.NewRequest("GET", "/api/v1/search?q=ivan&mode=regex", nil)
+	r.SetBasicAuth("admin", "admin")
+	w = httptest.NewRecorder()
+	rt.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bad mode: status %d", w.Code)
+	}
+}
+
+// brokenStore имитирует сбой хранилища: не читает карточки, а поиск отдает одного пользователя и обрывает выдачу ошибкой
+type brokenStore struct {
+	*usermemstore.Users
+}
+
+func (brokenStore) Read(ctx context.Context, uid uuid.UUID) (*user.User, error) {
+	return nil, errors.New("disk on fire")
+}
+
+func (brokenStore) SearchUsers(ctx context.Context, q user.Query) (chan user.Found, error) {
+	ch := make(chan user.Found, 2)
+	ch <- user.Found{User: user.User{ID: uuid.New(), Name: "ivan"}}
+	ch <- user.Found{Err: errors.New("disk on fire")}
+	close(ch)
+	return ch, nil
+}
+
+// TestRouter_SearchUserStreamError выдача через бизнес логику: пользователь, отданный стором до сбоя, доходит,
+// а массив закрывается объектом ошибки. С ранжированием до ошибки не отдается никто.
+func TestRouter_SearchUserStreamError(t *testing.T) {
+	rt := newTestRouter(t, user.NewUsers(brokenStore{usermemstore.NewUsers()}))
+	for mode, users := range map[string]int{user.ModeExact: 1, user.ModeFuzzy: 0} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/search?q=ivan&mode="+mode, nil)
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+
+		var elems []map[string]interface{}
+		if err := json.NewDecoder(w.Body).Decode(&elems); err != nil {
+			t.Fatalf("%s: body is not a closed array: %v", mode, err)
+		}
+		if len(elems) != users+1 || elems[len(elems)-1]["error"] != "error when searching" {
+			t.Errorf("%s: want %d users and error trailer: %v", mode, users, elems)
+			continue
+		}
+		for _, e := range elems[:users] {
+			if e["name"] != "ivan" {
+				t.Errorf("%s: user before the error: %v", mode, e)
+			}
+		}
+	}
+}
+
+// TestRouter_InternalErrorLog причина внутренней ошибки клиенту не отдается, а пишется в лог с request_id из ответа
+func TestRouter_InternalErrorLog(t *testing.T) {
+	buf := &bytes.Buffer{}
+	errorLog = log.New(buf, "", 0)
+	t.Cleanup(func() { errorLog = log.Default() })
+	rt := newTestRouter(t, user.NewUsers(brokenStore{usermemstore.NewUsers()}))
+
+	for _, path := range []string{"/api/v1/users/" + uuid.New().String(), "/api/v1/search?q=ivan"} {
+		buf.Reset()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+
+		if strings.Contains(w.Body.String(), "disk on fire") {
+			t.Errorf("%s: cause leaked to client: %s", path, w.Body)
+		}
+		want := "request_id=" + w.Header().Get(HeaderRequestID) + " GET " + r.URL.Path + ": "
+		if line := buf.String(); !strings.HasPrefix(line, want) || !strings.Contains(line, "disk on fire") {
+			t.Errorf("%s: log %q, want prefix %q", path, line, want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/google/uuid"
+)
+
+// Старые пути без /api/v1 говорят в старом формате: права пользователя в теле запроса и ответа это битовая маска
+// из auth, а не список имен, ошибки это text/plain, а не problem+json (см. writeProblem).
+// Клиенты, написанные под них, продолжают работать, пока не перейдут на /api/v1.
+
+// LegacyUser карточка на старых путях, права битовой маской
+type LegacyUser struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	Data        string    `json:"data"`
+	Permissions int       `json:"permissions"`
+}
+
+// LegacyUserList страница списка на старом пути /users
+type LegacyUserList struct {
+	Users []LegacyUser `json:"users"`
+	Next  string       `json:"next,omitempty"`
+}
+
+// LegacyWatchEvent сообщение на старом пути /watch
+type LegacyWatchEvent struct {
+	Type string     `json:"type"`
+	User LegacyUser `json:"user"`
+}
+
+type legacyKey struct{}
+
+// isLegacy запрос пришел на старый путь, см. legacy
+func isLegacy(r *http.Request) bool {
+	return r.Context().Value(legacyKey{}) != nil
+}
+
+func withLegacy(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), legacyKey{}, true))
+}
+
+// apiUser карточка в формате /api/v1
+func apiUser(u user.User) User {
+	return User{ID: u.ID, Name: u.Name, Data: u.Data, Permissions: auth.PermissionNames(u.Permissions)}
+}
+
+func legacyUser(u user.User) LegacyUser {
+	return LegacyUser{ID: u.ID, Name: u.Name, Data: u.Data, Permissions: u.Permissions}
+}
+
+// userJSON карточка в формате пути запроса
+func userJSON(r *http.Request, u user.User) interface{} {
+	if isLegacy(r) {
+		return legacyUser(u)
+	}
+	return apiUser(u)
+}
+
+// permissionsError права в теле запроса не разобрались, отвечаем на нее badPermissions, а не badBody
+type permissionsError struct {
+	err error
+}
+
+func (e *permissionsError) Error() string { return e.err.Error() }
+
+// decodeUser карточка из тела запроса в формате пути запроса, права уже переведены в маску
+func decodeUser(r *http.Request) (user.User, error) {
+	if isLegacy(r) {
+		u := LegacyUser{}
+		if err := decodeJSON(body(r), &u); err != nil {
+			return user.User{}, err
+		}
+		if extra := u.Permissions &^ auth.PermAll; extra != 0 || u.Permissions < 0 {
+			return user.User{}, &permissionsError{fmt.Errorf("unknown permission bits %d", extra)}
+		}
+		return user.User{Name: u.Name, Data: u.Data, Permissions: u.Permissions}, nil
+	}
+	u := User{}
+	if err := decodeJSON(body(r), &u); err != nil {
+		return user.User{}, err
+	}
+	perms, err := auth.ParsePermissions(u.Permissions)
+	if err != nil {
+		return user.User{}, &permissionsError{err}
+	}
+	return user.User{Name: u.Name, Data: u.Data, Permissions: perms}, nil
+}
+
+// badUser ответ на ошибку decodeUser
+func badUser(w http.ResponseWriter, r *http.Request, err error) {
+	var pe *permissionsError
+	if errors.As(err, &pe) {
+		badPermissions(w, r, pe.err)
+		return
+	}
+	badBody(w, r, err)
+}
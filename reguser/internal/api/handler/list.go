@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+)
+
+// UserList страница списка, Next курсор для следующего запроса, пустой на последней странице
+type UserList struct {
+	Users []User `json:"users"`
+	Next  string `json:"next,omitempty"`
+}
+
+// ListUsers GET /api/v1/users?sort=name&limit=50&cursor=...
+// sort: id, name, -id, -name, минус обратный порядок. Курсор берется из next предыдущего ответа,
+// он же в заголовке Link с rel="next".
+func (rt *Router) ListUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit := 0
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			badRequest(w, r, user.CodeValidation, "invalid limit",
+				user.FieldError{Field: "limit", Code: "range", Message: "limit must be a positive integer"})
+			return
+		}
+		limit = n
+	}
+
+	p, err := rt.us.ListUsers(r.Context(), q.Get("sort"), q.Get("cursor"), limit)
+	if err != nil {
+		userError(w, r, err, "error when listing users")
+		return
+	}
+
+	var res interface{}
+	if isLegacy(r) {
+		l := LegacyUserList{Users: make([]LegacyUser, 0, len(p.Users)), Next: p.Next}
+		for _, u := range p.Users {
+			l.Users = append(l.Users, legacyUser(u))
+		}
+		res = l
+	} else {
+		l := UserList{Users: make([]User, 0, len(p.Users)), Next: p.Next}
+		for _, u := range p.Users {
+			l.Users = append(l.Users, apiUser(u))
+		}
+		res = l
+	}
+	if p.Next != "" {
+		next := url.Values{}
+		for k, v := range q {
+			next[k] = v
+		}
+		next.Set("cursor", p.Next)
+		w.Header().Add("Link", `<`+r.URL.Path+`?`+next.Encode()+`>; rel="next"`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res)
+}
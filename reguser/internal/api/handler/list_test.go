@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/audetv/hex-ecample/reguser/internal/db/mem/usermemstore"
+	"github.com/google/uuid"
+)
+
+func TestRouter_ListUsers(t *testing.T) {
+	ust := usermemstore.NewUsers()
+	for _, n := range []string{"dave", "alice", "carol", "bob", "eve"} {
+		if _, err := ust.Create(context.Background(), user.User{ID: uuid.New(), Name: n}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rt := newTestRouter(t, user.NewUsers(ust))
+
+	get := func(path string) (*httptest.ResponseRecorder, UserList) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+		l := UserList{}
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&l); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w, l
+	}
+
+	var got []string
+	path := APIPrefix + "/users?sort=-name&limit=2"
+	for pages := 0; path != ""; pages++ {
+		if pages > 3 {
+			t.Fatal("too many pages")
+		}
+		w, l := get(path)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", path, w.Code)
+		}
+		for _, u := range l.Users {
+			got = append(got, u.Name)
+		}
+		path = ""
+		if l.Next != "" {
+			path = APIPrefix + "/users?sort=-name&limit=2&cursor=" + url.QueryEscape(l.Next)
+			if link := w.Header().Get("Link"); !strings.Contains(link, url.QueryEscape(l.Next)) {
+				t.Errorf("link %q", link)
+			}
+		}
+	}
+	if s := strings.Join(got, ","); s != "eve,dave,carol,bob,alice" {
+		t.Errorf("got %s", s)
+	}
+
+	_, l := get(APIPrefix + "/users?sort=name&limit=2")
+	for _, path := range []string{
+		APIPrefix + "/users?sort=id&cursor=" + url.QueryEscape(l.Next),
+		APIPrefix + "/users?cursor=garbage",
+		APIPrefix + "/users?sort=data",
+		APIPrefix + "/users?limit=-1",
+	} {
+		if w, _ := get(path); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d", path, w.Code)
+		}
+	}
+}
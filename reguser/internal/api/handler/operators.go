@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/operator"
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+)
+
+// Operator учетная запись оператора для клиента, пароль только принимаем и никогда не отдаем
+type Operator struct {
+	Login       string    `json:"login"`
+	Password    string    `json:"password,omitempty"`
+	Permissions []string  `json:"permissions"`
+	Disabled    bool      `json:"disabled"`
+	UpdatedAt   time.Time `json:"updated_at,omitempty"`
+}
+
+// ListOperators GET /api/v1/operators, устаревший /operators
+func (rt *Router) ListOperators(w http.ResponseWriter, r *http.Request) {
+	list, err := rt.ops.List(r.Context())
+	if err != nil {
+		operatorError(w, r, err)
+		return
+	}
+	res := make([]Operator, 0, len(list))
+	for _, o := range list {
+		res = append(res, Operator{
+			Login:       o.Login,
+			Permissions: auth.PermissionNames(o.Permissions),
+			Disabled:    o.Disabled,
+			UpdatedAt:   o.UpdatedAt,
+		})
+	}
+	w.Header().Set("Content-Type", MediaJSON)
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+// decodeOperator общий разбор тела для изменения оператора. Логин берется из пути /api/v1/operators/{login}/...,
+// а на старых путях и при заведении из тела.
+func decodeOperator(w http.ResponseWriter, r *http.Request) (Operator, bool) {
+	o := Operator{}
+	defer r.Body.Close()
+	if err := decodeJSON(body(r), &o); err != nil {
+		badBody(w, r, err)
+		return o, false
+	}
+	if login, ok := pathID(r); ok {
+		o.Login = login
+	}
+	if o.Login == "" {
+		badRequest(w, r, user.CodeValidation, "missing login",
+			user.FieldError{Field: "login", Code: "required", Message: "login must not be empty"})
+		return o, false
+	}
+	return o, true
+}
+
+// operatorError переводит ошибки репозитория операторов в problem+json
+func operatorError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeProblem(w, r, http.StatusNotFound, CodeOperatorNotFound, "operator not found", nil)
+	case errors.Is(err, auth.ErrForbidden):
+		writeProblem(w, r, http.StatusForbidden, user.CodeForbidden, "forbidden", nil)
+	case errors.Is(err, operator.ErrExists):
+		writeProblem(w, r, http.StatusConflict, CodeOperatorExists, "operator already exists", nil)
+	case errors.Is(err, operator.ErrEmptyPassword):
+		badRequest(w, r, user.CodeValidation, "missing password",
+			user.FieldError{Field: "password", Code: "required", Message: "password must not be empty"})
+	default:
+		logError(r, err)
+		writeProblem(w, r, http.StatusInternalServerError, user.CodeInternal, "error when changing operator", nil)
+	}
+}
+
+// CreateOperator POST /api/v1/operators {"login":"...","password":"...","permissions":["read"]}, устаревший /operators/create
+func (rt *Router) CreateOperator(w http.ResponseWriter, r *http.Request) {
+	o, ok := decodeOperator(w, r)
+	if !ok {
+		return
+	}
+	perms, err := auth.ParsePermissions(o.Permissions)
+	if err != nil {
+		badPermissions(w, r, err)
+		return
+	}
+	no, err := rt.ops.Create(r.Context(), o.Login, o.Password, perms)
+	if err != nil {
+		operatorError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", MediaJSON)
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(Operator{
+		Login:       no.Login,
+		Permissions: auth.PermissionNames(no.Permissions),
+		Disabled:    no.Disabled,
+		UpdatedAt:   no.UpdatedAt,
+	})
+}
+
+// SetOperatorPassword PUT /api/v1/operators/{login}/password {"password":"..."} ротация пароля,
+// устаревший POST /operators/password {"login":"...","password":"..."}
+func (rt *Router) SetOperatorPassword(w http.ResponseWriter, r *http.Request) {
+	o, ok := decodeOperator(w, r)
+	if !ok {
+		return
+	}
+	if err := rt.ops.SetPassword(r.Context(), o.Login, o.Password); err != nil {
+		operatorError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetOperatorDisabled PUT /api/v1/operators/{login}/disabled {"disabled":true} отключение и включение оператора,
+// устаревший POST /operators/disable {"login":"...","disabled":true}
+func (rt *Router) SetOperatorDisabled(w http.ResponseWriter, r *http.Request) {
+	o, ok := decodeOperator(w, r)
+	if !ok {
+		return
+	}
+	if err := rt.ops.SetDisabled(r.Context(), o.Login, o.Disabled); err != nil {
+		operatorError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetOperatorPermissions PUT /api/v1/operators/{login}/permissions {"permissions":["read","search"]},
+// устаревший POST /operators/permissions {"login":"...","permissions":["read","search"]}
+func (rt *Router) SetOperatorPermissions(w http.ResponseWriter, r *http.Request) {
+	o, ok := decodeOperator(w, r)
+	if !ok {
+		return
+	}
+	perms, err := auth.ParsePermissions(o.Permissions)
+	if err != nil {
+		badPermissions(w, r, err)
+		return
+	}
+	if err := rt.ops.SetPermissions(r.Context(), o.Login, perms); err != nil {
+		operatorError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
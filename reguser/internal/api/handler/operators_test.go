@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/audetv/hex-ecample/reguser/internal/db/mem/usermemstore"
+)
+
+func TestRouter_Operators(t *testing.T) {
+	rt := newTestRouter(t, user.NewUsers(usermemstore.NewUsers()))
+
+	do := func(login, password, method, path, body string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.SetBasicAuth(login, password)
+		rt.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := do("admin", "wrong", "GET", "/search?q=a", ""); code != http.StatusUnauthorized {
+		t.Errorf("wrong password: %d", code)
+	}
+	if code := do("nobody", "admin", "GET", "/search?q=a", ""); code != http.StatusUnauthorized {
+		t.Errorf("unknown operator: %d", code)
+	}
+	if code := do("admin", "admin", "POST", "/api/v1/operators", `{"login":"ivan","password":"secret","permissions":["search"]}`); code != http.StatusCreated {
+		t.Fatalf("create operator: %d", code)
+	}
+	if code := do("admin", "admin", "POST", "/api/v1/operators", `{"login":"ivan","password":"other"}`); code != http.StatusConflict {
+		t.Errorf("create existing operator: %d", code)
+	}
+	if code := do("ivan", "secret", "GET", "/search?q=a", ""); code != http.StatusOK {
+		t.Errorf("new operator: %d", code)
+	}
+
+	if code := do("admin", "admin", "PUT", "/api/v1/operators/ivan/password", `{"password":"rotated"}`); code != http.StatusNoContent {
+		t.Fatalf("rotate password: %d", code)
+	}
+	if code := do("ivan", "secret", "GET", "/search?q=a", ""); code != http.StatusUnauthorized {
+		t.Errorf("old password after rotation: %d", code)
+	}
+	if code := do("ivan", "rotated", "GET", "/search?q=a", ""); code != http.StatusOK {
+		t.Errorf("new password after rotation: %d", code)
+	}
+
+	if code := do("admin", "admin", "PUT", "/api/v1/operators/ivan/disabled", `{"disabled":true}`); code != http.StatusNoContent {
+		t.Fatalf("disable operator: %d", code)
+	}
+	if code := do("ivan", "rotated", "GET", "/search?q=a", ""); code != http.StatusUnauthorized {
+		t.Errorf("disabled operator: %d", code)
+	}
+	if code := do("admin", "admin", "PUT", "/api/v1/operators/nobody/disabled", `{"disabled":true}`); code != http.StatusNotFound {
+		t.Errorf("disable unknown operator: %d", code)
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/api/v1/operators", nil)
+	r.SetBasicAuth("admin", "admin")
+	rt.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != MediaJSON {
+		t.Errorf("list operators: %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	list := []Operator{}
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil || len(list) != 2 || !list[1].Disabled {
+		t.Errorf("list operators: %+v, %v", list, err)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("POST", "/api/v1/operators/ivan/password", strings.NewReader(`{"password":"x"}`))
+	r.SetBasicAuth("admin", "admin")
+	rt.ServeHTTP(w, r)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "OPTIONS, PUT" {
+		t.Errorf("method not allowed: %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+	if code := do("admin", "admin", "PUT", "/api/v1/operators/ivan/unknown", `{}`); code != http.StatusNotFound {
+		t.Errorf("unknown operator resource: %d", code)
+	}
+}
+
+// Старые пути с логином в теле работают как раньше, но помечены устаревшими
+func TestRouter_OperatorsLegacy(t *testing.T) {
+	rt := newTestRouter(t, user.NewUsers(usermemstore.NewUsers()))
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("POST", "/operators/create", `{"login":"ivan","password":"secret"}`)
+	if w.Code != http.StatusCreated || w.Header().Get("Deprecation") != "true" ||
+		w.Header().Get("Link") != `</api/v1/operators>; rel="successor-version"` {
+		t.Fatalf("create: %d, Deprecation %q, Link %q", w.Code, w.Header().Get("Deprecation"), w.Header().Get("Link"))
+	}
+	for _, c := range []struct{ path, body string }{
+		{"/operators/password", `{"login":"ivan","password":"rotated"}`},
+		{"/operators/permissions", `{"login":"ivan","permissions":["read"]}`},
+		{"/operators/disable", `{"login":"ivan","disabled":true}`},
+	} {
+		if w := do("POST", c.path, c.body); w.Code != http.StatusNoContent || w.Header().Get("Deprecation") != "true" {
+			t.Errorf("%s: %d, Deprecation %q", c.path, w.Code, w.Header().Get("Deprecation"))
+		}
+		if w := do("PUT", c.path, c.body); w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "OPTIONS, POST" {
+			t.Errorf("%s: PUT %d, Allow %q", c.path, w.Code, w.Header().Get("Allow"))
+		}
+	}
+	list := []Operator{}
+	if err := json.NewDecoder(do("GET", "/operators", "").Body).Decode(&list); err != nil || len(list) != 2 ||
+		!list[1].Disabled || strings.Join(list[1].Permissions, ",") != "read" {
+		t.Errorf("list: %+v, %v", list, err)
+	}
+	// ошибки на старых путях текстом
+	if w := do("POST", "/operators/disable", `{"login":"nobody","disabled":true}`); w.Code != http.StatusNotFound ||
+		!strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("unknown operator: %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+}
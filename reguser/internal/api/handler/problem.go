@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/google/uuid"
+)
+
+// MediaProblem тип тела ошибки по RFC 7807
+const MediaProblem = "application/problem+json"
+
+// Коды ошибок уровня HTTP, коды бизнес логики в user.Code*
+const (
+	CodeUnauthorized     = "unauthorized"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeNotAcceptable    = "not_acceptable"
+	CodeInvalidBody      = "invalid_body"
+	CodeBodyTooLarge     = "body_too_large"
+	CodeInvalidID        = "invalid_id"
+	CodeOperatorNotFound = "operator_not_found"
+	CodeOperatorExists   = "operator_exists"
+)
+
+// HeaderRequestID заголовок с id запроса, клиентский id принимается, если он разумный, иначе генерируется
+const HeaderRequestID = "X-Request-ID"
+
+// Problem тело ответа с ошибкой (RFC 7807). Type всегда about:blank, поэтому Title это текст статуса,
+// а различать ошибки надо по Code. Errors нарушения по полям, RequestID тот же, что в заголовке X-Request-ID.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError нарушение в одном поле запроса
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type requestIDKey struct{}
+
+// RequestID id запроса из контекста, пустая строка вне Router
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID клиентский id берем как есть только короткий и из безопасных символов, чтобы он не ломал логи
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// ServeHTTP дает каждому запросу id в контексте и заголовке ответа и отвечает problem+json на неизвестный путь,
+// остальное делает ServeMux.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get(HeaderRequestID)
+	if !validRequestID(id) {
+		id = uuid.New().String()
+	}
+	w.Header().Set(HeaderRequestID, id)
+	r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+	if _, pattern := rt.ServeMux.Handler(r); pattern == "" {
+		writeProblem(w, r, http.StatusNotFound, CodeNotFound, "", nil)
+		return
+	}
+	rt.ServeMux.ServeHTTP(w, r)
+}
+
+// writeProblem пишет ошибку в формате problem+json, а на старых путях, как они всегда отвечали, текстом
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string, fields []user.FieldError) {
+	if isLegacy(r) {
+		writeLegacyError(w, status, detail)
+		return
+	}
+	p := Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: RequestID(r.Context()),
+	}
+	for _, f := range fields {
+		p.Errors = append(p.Errors, FieldError{Field: f.Field, Code: f.Code, Message: f.Message})
+	}
+	h := w.Header()
+	h.Set("Content-Type", MediaProblem)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// writeLegacyError ошибка старого пути: text/plain через http.Error. Текст это detail для ошибок запроса
+// и внутренних ошибок, для остальных статусов, как и раньше, только сам статус: "not found", "forbidden".
+func writeLegacyError(w http.ResponseWriter, status int, detail string) {
+	if detail == "" || status != http.StatusBadRequest && status < http.StatusInternalServerError {
+		detail = strings.ToLower(http.StatusText(status))
+	}
+	http.Error(w, detail, status)
+}
+
+// errorStatus код ответа и стабильный код ошибки бизнес логики
+func errorStatus(err error) (int, string) {
+	code := user.CodeInternal
+	var e *user.Error
+	if errors.As(err, &e) {
+		code = e.Code
+	}
+	switch {
+	case errors.Is(err, user.ErrNotFound):
+		return http.StatusNotFound, code
+	case errors.Is(err, user.ErrConflict):
+		return http.StatusConflict, code
+	case errors.Is(err, user.ErrStale):
+		return http.StatusPreconditionFailed, code
+	case errors.Is(err, user.ErrInvalid):
+		return http.StatusBadRequest, code
+	case errors.Is(err, user.ErrForbidden), errors.Is(err, auth.ErrForbidden):
+		return http.StatusForbidden, user.CodeForbidden
+	case errors.Is(err, user.ErrUnavailable):
+		return http.StatusServiceUnavailable, code
+	}
+	return http.StatusInternalServerError, user.CodeInternal
+}
+
+// errorLog куда пишутся причины внутренних ошибок, тесты подменяют его своим
+var errorLog = log.Default()
+
+// logError пишет причину ошибки, которую клиент видит только как код, вместе с request_id из ответа,
+// методом и путем, чтобы ошибку из жалобы клиента можно было найти в логе
+func logError(r *http.Request, err error) {
+	errorLog.Printf("request_id=%s %s %s: %v", RequestID(r.Context()), r.Method, r.URL.Path, err)
+}
+
+// userError переводит ошибки бизнес логики в problem+json, msg текст для внутренней ошибки:
+// ее причину клиенту не показываем, а пишем в лог с request_id.
+func userError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	status, code := errorStatus(err)
+	if status >= http.StatusInternalServerError {
+		logError(r, err)
+	}
+	detail := msg
+	var fields []user.FieldError
+	var e *user.Error
+	if errors.As(err, &e) {
+		fields = e.Fields
+		if e.Err != nil && (status == http.StatusBadRequest || status == http.StatusConflict || status == http.StatusPreconditionFailed) {
+			detail = e.Err.Error()
+		}
+	}
+	switch status {
+	case http.StatusNotFound:
+		detail = "user not found"
+	case http.StatusForbidden:
+		detail = "forbidden"
+	}
+	writeProblem(w, r, status, code, detail, fields)
+}
+
+// badRequest ошибка в запросе, которую нашел сам обработчик: тело, id, параметры
+func badRequest(w http.ResponseWriter, r *http.Request, code, detail string, fields ...user.FieldError) {
+	writeProblem(w, r, http.StatusBadRequest, code, detail, fields)
+}
+
+// badBody ошибка разбора JSON тела, для неизвестных полей и поля неверного типа указываем сами поля
+func badBody(w http.ResponseWriter, r *http.Request, err error) {
+	var te *json.UnmarshalTypeError
+	var ue *unknownFieldsError
+	switch {
+	case errors.Is(err, errBodyTooLarge):
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, CodeBodyTooLarge,
+			"request body must be at most "+strconv.Itoa(MaxBodySize)+" bytes", nil)
+	case errors.As(err, &ue):
+		fields := make([]user.FieldError, 0, len(ue.Fields))
+		for _, f := range ue.Fields {
+			fields = append(fields, user.FieldError{Field: f, Code: "unknown", Message: "unknown field"})
+		}
+		badRequest(w, r, CodeInvalidBody, "unknown fields", fields...)
+	case errors.As(err, &te) && te.Field != "":
+		badRequest(w, r, CodeInvalidBody, "invalid field type",
+			user.FieldError{Field: te.Field, Code: "type", Message: "unexpected " + te.Value})
+	case errors.Is(err, errTrailingData):
+		badRequest(w, r, CodeInvalidBody, err.Error())
+	default:
+		badRequest(w, r, CodeInvalidBody, "request body must be a JSON object")
+	}
+}
+
+// badID неверный id пользователя в пути или в параметре uid
+func badID(w http.ResponseWriter, r *http.Request, err error) {
+	badRequest(w, r, CodeInvalidID, "invalid user id",
+		user.FieldError{Field: "id", Code: "format", Message: err.Error()})
+}
+
+// badIfMatch If-Match, который не может совпасть с ETag карточки, по RFC 9110 это 412, а не 400.
+// Слишком длинный список ETag это ошибка запроса.
+func badIfMatch(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errTooManyIfMatch) {
+		badRequest(w, r, user.CodeValidation, err.Error(),
+			user.FieldError{Field: "If-Match", Code: "too_long", Message: err.Error()})
+		return
+	}
+	writeProblem(w, r, http.StatusPreconditionFailed, user.CodeVersionMismatch, err.Error(), nil)
+}
+
+// badPermissions неизвестное имя права в поле permissions
+func badPermissions(w http.ResponseWriter, r *http.Request, err error) {
+	badRequest(w, r, user.CodeValidation, "invalid permissions",
+		user.FieldError{Field: "permissions", Code: "unknown_permission", Message: err.Error()})
+}
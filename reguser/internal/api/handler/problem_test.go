@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/audetv/hex-ecample/reguser/internal/db/mem/usermemstore"
+)
+
+func TestRouter_Problem(t *testing.T) {
+	rt := newTestRouter(t, user.NewUsers(usermemstore.NewUsers()))
+	cases := []struct {
+		name, method, path, body string
+		status                   int
+		code, field              string
+	}{
+		{"unknown path", "GET", "/nowhere", "", http.StatusNotFound, CodeNotFound, ""},
+		{"method", "DELETE", "/api/v1/users", "", http.StatusMethodNotAllowed, CodeMethodNotAllowed, ""},
+		{"bad id", "GET", "/api/v1/users/not-a-uuid", "", http.StatusBadRequest, CodeInvalidID, "id"},
+		{"missing user", "GET", "/api/v1/users/95b9791e-aff3-4432-9624-12a12534e9df", "", http.StatusNotFound, user.CodeUserNotFound, ""},
+		{"bad json", "POST", "/api/v1/users", `{"name":`, http.StatusBadRequest, CodeInvalidBody, ""},
+		{"field type", "POST", "/api/v1/users", `{"name":1}`, http.StatusBadRequest, CodeInvalidBody, "name"},
+		{"permission", "POST", "/api/v1/users", `{"name":"ivan","permissions":["fly"]}`, http.StatusBadRequest, user.CodeValidation, "permissions"},
+		{"no query", "GET", "/api/v1/search", "", http.StatusBadRequest, user.CodeValidation, "q"},
+		{"query syntax", "GET", "/api/v1/search?q=name:", "", http.StatusBadRequest, user.CodeInvalidQuery, "query"},
+		{"sort", "GET", "/api/v1/users?sort=age", "", http.StatusBadRequest, user.CodeInvalidSort, "sort"},
+		{"limit", "GET", "/api/v1/users?limit=0", "", http.StatusBadRequest, user.CodeValidation, "limit"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+
+		p := Problem{}
+		if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if w.Code != c.status || p.Status != c.status || p.Code != c.code {
+			t.Errorf("%s: status %d, problem %+v", c.name, w.Code, p)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != MediaProblem {
+			t.Errorf("%s: content type %q", c.name, ct)
+		}
+		if p.RequestID == "" || p.RequestID != w.Header().Get(HeaderRequestID) {
+			t.Errorf("%s: request id %q, header %q", c.name, p.RequestID, w.Header().Get(HeaderRequestID))
+		}
+		if c.field != "" && (len(p.Errors) != 1 || p.Errors[0].Field != c.field) {
+			t.Errorf("%s: errors %+v, want field %s", c.name, p.Errors, c.field)
+		}
+	}
+}
+
+func TestRouter_RequestID(t *testing.T) {
+	rt := newTestRouter(t, user.NewUsers(usermemstore.NewUsers()))
+	cases := []struct {
+		sent string
+		keep bool
+	}{
+		{"req-42.a_b", true},
+		{"", false},
+		{"bad id\r\nX-Evil: 1", false},
+		{strings.Repeat("a", 129), false},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/api/v1/users/not-a-uuid", nil)
+		r.SetBasicAuth("admin", "admin")
+		r.Header.Set(HeaderRequestID, c.sent)
+		rt.ServeHTTP(w, r)
+
+		got := w.Header().Get(HeaderRequestID)
+		if got == "" || (got == c.sent) != c.keep {
+			t.Errorf("sent %q: got %q", c.sent, got)
+		}
+		p := Problem{}
+		if err := json.NewDecoder(w.Body).Decode(&p); err != nil || p.RequestID != got {
+			t.Errorf("sent %q: body request id %q, header %q", c.sent, p.RequestID, got)
+		}
+	}
+}
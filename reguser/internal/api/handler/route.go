@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// APIPrefix версия API в путях, старые пути без версии оставлены как устаревшие псевдонимы
+const APIPrefix = "/api/v1"
+
+// methods обработчики одного ресурса по HTTP методам. На метод, которого нет, отвечает 405 с заголовком Allow,
+// HEAD обслуживает обработчик GET, на OPTIONS отвечает 204 с тем же Allow.
+type methods map[string]http.HandlerFunc
+
+func (m methods) allow() string {
+	res := make([]string, 0, len(m)+2)
+	for method := range m {
+		res = append(res, method)
+	}
+	if _, ok := m[http.MethodGet]; ok {
+		res = append(res, http.MethodHead)
+	}
+	res = append(res, http.MethodOptions)
+	sort.Strings(res)
+	return strings.Join(res, ", ")
+}
+
+func (m methods) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, ok := m[r.Method]
+	if !ok && r.Method == http.MethodHead {
+		h, ok = m[http.MethodGet]
+	}
+	if ok {
+		h(w, r)
+		return
+	}
+	w.Header().Set("Allow", m.allow())
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "allowed: "+m.allow(), nil)
+}
+
+type pathIDKey struct{}
+
+// subtree ресурсы внутри prefix/{id}: первый сегмент пути после prefix это id,
+// остаток выбирает ресурс из routes, "" сам элемент. Id передается обработчику через контекст, см. pathID.
+type subtree struct {
+	prefix string
+	routes map[string]http.Handler
+}
+
+func (s subtree) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, sub := strings.TrimPrefix(r.URL.Path, s.prefix), ""
+	if i := strings.IndexByte(id, '/'); i >= 0 {
+		id, sub = id[:i], id[i+1:]
+	}
+	h, ok := s.routes[sub]
+	if id == "" || !ok {
+		writeProblem(w, r, http.StatusNotFound, CodeNotFound, "", nil)
+		return
+	}
+	h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), pathIDKey{}, id)))
+}
+
+// pathID id ресурса из пути, ok=false если обработчик вызван не через subtree
+func pathID(r *http.Request) (string, bool) {
+	id, ok := r.Context().Value(pathIDKey{}).(string)
+	return id, ok
+}
+
+var errZeroID = errors.New("uid must not be zero")
+
+// userID id пользователя из пути /api/v1/users/{id} или из параметра uid у старых путей
+func userID(r *http.Request) (uuid.UUID, error) {
+	s, ok := pathID(r)
+	if !ok {
+		s = r.URL.Query().Get("uid")
+	}
+	uid, err := uuid.Parse(s)
+	if err != nil {
+		return uid, err
+	}
+	if (uid == uuid.UUID{}) {
+		return uid, errZeroID
+	}
+	return uid, nil
+}
+
+// legacy старый путь без версии: тот же обработчик в старом формате, см. legacy.go, но с заголовком Deprecation
+// и ссылкой на путь, который его заменил. {id} в successor подставляется из параметра uid запроса.
+func legacy(successor string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		link := successor
+		if uid := r.URL.Query().Get("uid"); uid != "" {
+			link = strings.Replace(link, "{id}", url.PathEscape(uid), 1)
+		}
+		w.Header().Set("Deprecation", "true")
+		if !strings.Contains(link, "{id}") {
+			w.Header().Add("Link", `<`+link+`>; rel="successor-version"`)
+		}
+		h.ServeHTTP(w, withLegacy(r))
+	})
+}
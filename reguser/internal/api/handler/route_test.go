@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/audetv/hex-ecample/reguser/internal/db/mem/usermemstore"
+	"github.com/google/uuid"
+)
+
+func TestRouter_Resources(t *testing.T) {
+	rt := newTestRouter(t, user.NewUsers(usermemstore.NewUsers()))
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("POST", "/api/v1/users", `{"name":"ivan","data":"moscow"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status %d", w.Code)
+	}
+	u := User{}
+	if err := json.NewDecoder(w.Body).Decode(&u); err != nil {
+		t.Fatal(err)
+	}
+	item := "/api/v1/users/" + u.ID.String()
+
+	steps := []struct {
+		method, path, body string
+		code               int
+	}{
+		{"GET", item, "", http.StatusOK},
+		{"HEAD", item, "", http.StatusOK},
+		{"PATCH", item, `{"data":"kazan"}`, http.StatusOK},
+		{"PUT", item + "/permissions", `{"permissions":["read"]}`, http.StatusOK},
+		{"GET", "/api/v1/users", "", http.StatusOK},
+		{"GET", "/api/v1/search?q=ivan", "", http.StatusOK},
+		{"GET", "/api/v1/users/not-a-uuid", "", http.StatusBadRequest},
+		{"GET", "/api/v1/users/00000000-0000-0000-0000-000000000000", "", http.StatusBadRequest},
+		{"GET", item + "/unknown", "", http.StatusNotFound},
+		{"GET", "/api/v1/users/", "", http.StatusNotFound},
+		{"DELETE", item, "", http.StatusOK},
+		{"GET", item, "", http.StatusNotFound},
+	}
+	for _, s := range steps {
+		if w := do(s.method, s.path, s.body); w.Code != s.code {
+			t.Errorf("%s %s: status %d, want %d", s.method, s.path, w.Code, s.code)
+		}
+	}
+}
+
+func TestRouter_MethodNotAllowed(t *testing.T) {
+	rt := newTestRouter(t, user.NewUsers(usermemstore.NewUsers()))
+	cases := []struct {
+		method, path, allow string
+	}{
+		{"DELETE", "/api/v1/users", "GET, HEAD, OPTIONS, POST"},
+		{"POST", "/api/v1/users/95b9791e-aff3-4432-9624-12a12534e9df", "DELETE, GET, HEAD, OPTIONS, PATCH, PUT"},
+		{"GET", "/api/v1/users/95b9791e-aff3-4432-9624-12a12534e9df/permissions", "OPTIONS, PUT"},
+		{"POST", "/api/v1/search", "GET, HEAD, OPTIONS"},
+		{"GET", "/create", "OPTIONS, POST"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(c.method, c.path, nil)
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+		if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != c.allow {
+			t.Errorf("%s %s: status %d, Allow %q, want %q", c.method, c.path, w.Code, w.Header().Get("Allow"), c.allow)
+		}
+	}
+}
+
+func TestRouter_Legacy(t *testing.T) {
+	rt := newTestRouter(t, user.NewUsers(usermemstore.NewUsers()))
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.SetBasicAuth("admin", "admin")
+		rt.ServeHTTP(w, r)
+		return w
+	}
+	// права на старых путях битовой маской, как до их имен
+	w := do("POST", "/create", `{"name":"ivan","permissions":17}`)
+	if w.Code != http.StatusCreated || w.Header().Get("Deprecation") != "true" {
+		t.Fatalf("create: status %d, Deprecation %q", w.Code, w.Header().Get("Deprecation"))
+	}
+	u := LegacyUser{}
+	if err := json.NewDecoder(w.Body).Decode(&u); err != nil {
+		t.Fatal(err)
+	}
+	if u.Permissions != auth.PermRead|auth.PermSearch {
+		t.Errorf("create: permissions %d", u.Permissions)
+	}
+	if w := do("PUT", "/permissions?uid="+u.ID.String(), `{"permissions":3}`); !strings.Contains(w.Body.String(), `"permissions":3`) {
+		t.Errorf("permissions: status %d, body %s", w.Code, w.Body)
+	}
+	if w := do("PUT", "/permissions?uid="+u.ID.String(), `{"permissions":1024}`); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown permission bits: status %d", w.Code)
+	}
+	if w := do("PUT", "/update?uid="+u.ID.String(), `{"name":"ivan","data":"moscow","permissions":3}`); w.Code != http.StatusOK {
+		t.Errorf("update with mask: status %d, body %s", w.Code, w.Body)
+	}
+	var found []LegacyUser
+	if err := json.NewDecoder(do("GET", "/search?q=ivan", "").Body).Decode(&found); err != nil || len(found) != 1 || found[0].Permissions != 3 {
+		t.Errorf("search: %+v, %v", found, err)
+	}
+	if w := do("GET", "/search?q=ivan", ""); strings.Contains(w.Body.String(), "score") {
+		t.Errorf("search: score on legacy path: %s", w.Body)
+	}
+	l := LegacyUserList{}
+	if err := json.NewDecoder(do("GET", "/users", "").Body).Decode(&l); err != nil || len(l.Users) != 1 || l.Users[0].Permissions != 3 {
+		t.Errorf("users: %+v, %v", l, err)
+	}
+	if w := do("GET", APIPrefix+"/users/"+u.ID.String(), ""); !strings.Contains(w.Body.String(), `"permissions":["read","create"]`) {
+		t.Errorf("new path: %s", w.Body)
+	}
+	w = do("GET", "/read?uid="+u.ID.String(), "")
+	if link := w.Header().Get("Link"); link != `</api/v1/users/`+u.ID.String()+`>; rel="successor-version"` {
+		t.Errorf("read link %q", link)
+	}
+	if w := do("GET", "/api/v1/users/"+u.ID.String(), ""); w.Header().Get("Deprecation") != "" {
+		t.Error("new path is marked deprecated")
+	}
+	// без uid ссылки на замену нет, но запрос все равно устаревший
+	if w := do("GET", "/read", ""); w.Code != http.StatusBadRequest || w.Header().Get("Deprecation") != "true" || w.Header().Get("Link") != "" {
+		t.Errorf("read without uid: status %d, headers %v", w.Code, w.Header())
+	}
+
+	// ошибки на старых путях текстом, как раньше
+	errs := []struct {
+		method, path, login string
+		status              int
+		text                string
+	}{
+		{"GET", "/read?uid=" + uuid.New().String(), "admin", http.StatusNotFound, "not found"},
+		{"POST", "/read", "admin", http.StatusMethodNotAllowed, "method not allowed"},
+		{"GET", "/search", "admin", http.StatusBadRequest, "missing search query"},
+		{"GET", "/read?uid=" + u.ID.String(), "", http.StatusUnauthorized, "unauthorized"},
+	}
+	for _, c := range errs {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(c.method, c.path, nil)
+		if c.login != "" {
+			r.SetBasicAuth(c.login, c.login)
+		}
+		rt.ServeHTTP(w, r)
+		if w.Code != c.status || w.Body.String() != c.text+"\n" || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+			t.Errorf("%s %s: status %d, %q, %q", c.method, c.path, w.Code, w.Header().Get("Content-Type"), w.Body)
+		}
+	}
+}
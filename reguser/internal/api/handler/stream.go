@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Форматы потоковой выдачи /search, выбираются по заголовку Accept
+const (
+	MediaJSON   = "application/json"
+	MediaNDJSON = "application/x-ndjson"
+	MediaSSE    = "text/event-stream"
+)
+
+// searchEncoder пишет выдачу поиска в одном из форматов, пользователь это FoundUser или LegacyUser.
+// begin вызывается до первого пользователя,
+// finish один раз в конце: nil если выдача полная, иначе ошибка, оборвавшая поиск.
+type searchEncoder interface {
+	begin()
+	user(u interface{})
+	finish(e *SearchError)
+}
+
+// negotiate выбирает формат по Accept с учетом q, при равном q побеждает тот, что раньше в заголовке.
+// Пустой Accept и */* это JSON массив, как было всегда. Пустая строка, если ни один формат не подходит.
+func negotiate(accept string) string {
+	if strings.TrimSpace(accept) == "" {
+		return MediaJSON
+	}
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+		var media string
+		switch mt {
+		case MediaJSON, MediaNDJSON, MediaSSE:
+			media = mt
+		case "*/*", "application/*":
+			media = MediaJSON
+		default:
+			continue
+		}
+		if q > bestQ {
+			best, bestQ = media, q
+		}
+	}
+	return best
+}
+
+// newSearchEncoder ставит заголовки ответа под формат media и возвращает его кодировщик
+func newSearchEncoder(w http.ResponseWriter, media string) searchEncoder {
+	h := w.Header()
+	h.Set("Content-Type", media)
+	h.Add("Vary", "Accept")
+	fl, _ := w.(http.Flusher)
+	switch media {
+	case MediaNDJSON:
+		return &ndjsonEncoder{w: w, fl: fl, enc: json.NewEncoder(w)}
+	case MediaSSE:
+		h.Set("Cache-Control", "no-cache")
+		return &sseEncoder{w: w, fl: fl}
+	}
+	return &arrayEncoder{w: w, fl: fl, enc: json.NewEncoder(w)}
+}
+
+func flush(fl http.Flusher) {
+	if fl != nil {
+		fl.Flush()
+	}
+}
+
+// arrayEncoder JSON массив пользователей, оборванный поиск закрывает массив объектом SearchError
+type arrayEncoder struct {
+	w     io.Writer
+	fl    http.Flusher
+	enc   *json.Encoder
+	count int
+}
+
+func (a *arrayEncoder) begin() { fmt.Fprint(a.w, "[") }
+
+func (a *arrayEncoder) next(v interface{}) {
+	if a.count > 0 {
+		fmt.Fprint(a.w, ",")
+	}
+	a.count++
+	_ = a.enc.Encode(v)
+	flush(a.fl)
+}
+
+func (a *arrayEncoder) user(u interface{}) { a.next(u) }
+
+func (a *arrayEncoder) finish(e *SearchError) {
+	if e != nil {
+		a.next(e)
+	}
+	fmt.Fprintln(a.w, "]")
+}
+
+// ndjsonEncoder пользователь на строку, ошибка последней строкой с SearchError.
+// Клиент может разбирать выдачу построчно, не дожидаясь конца.
+type ndjsonEncoder struct {
+	w   io.Writer
+	fl  http.Flusher
+	enc *json.Encoder
+}
+
+func (n *ndjsonEncoder) begin() {}
+
+func (n *ndjsonEncoder) user(u interface{}) {
+	_ = n.enc.Encode(u)
+	flush(n.fl)
+}
+
+func (n *ndjsonEncoder) finish(e *SearchError) {
+	if e != nil {
+		_ = n.enc.Encode(e)
+		flush(n.fl)
+	}
+}
+
+// sseEncoder Server-Sent Events: каждый пользователь событием user с порядковым id,
+// в конце событие end с числом найденных или error с SearchError.
+type sseEncoder struct {
+	w  io.Writer
+	fl http.Flusher
+	id int
+}
+
+// SearchEnd данные события end в SSE выдаче
+type SearchEnd struct {
+	Count int `json:"count"`
+}
+
+func (s *sseEncoder) begin() {}
+
+func (s *sseEncoder) event(name string, v interface{}) {
+	data, _ := json.Marshal(v)
+	s.id++
+	fmt.Fprintf(s.w, "id: %d\nevent: %s\ndata: %s\n\n", s.id, name, data)
+	flush(s.fl)
+}
+
+func (s *sseEncoder) user(u interface{}) { s.event("user", u) }
+
+func (s *sseEncoder) finish(e *SearchError) {
+	if e != nil {
+		s.event("error", e)
+		return
+	}
+	s.event("end", SearchEnd{Count: s.id})
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/audetv/hex-ecample/reguser/internal/db/mem/usermemstore"
+	"github.com/google/uuid"
+)
+
+func TestNegotiate(t *testing.T) {
+	cases := map[string]string{
+		"":                     MediaJSON,
+		"*/*":                  MediaJSON,
+		"application/json":     MediaJSON,
+		"application/x-ndjson": MediaNDJSON,
+		"text/event-stream":    MediaSSE,
+		"text/html, application/xhtml+xml, */*;q=0.8": MediaJSON,
+		"application/json;q=0.5, text/event-stream":   MediaSSE,
+		"application/x-ndjson, application/json":      MediaNDJSON,
+		"application/x-ndjson;q=0, */*;q=0.1":         MediaJSON,
+		"text/html":                                   "",
+		"application/x-ndjson;q=0":                    "",
+	}
+	for accept, want := range cases {
+		if got := negotiate(accept); got != want {
+			t.Errorf("negotiate(%q) = %q, want %q", accept, got, want)
+		}
+	}
+}
+
+func searchAs(t *testing.T, rt *Router, accept string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/api/v1/search?q=ivan", nil)
+	r.SetBasicAuth("admin", "admin")
+	r.Header.Set("Accept", accept)
+	rt.ServeHTTP(w, r)
+	return w
+}
+
+func TestRouter_SearchUserFormats(t *testing.T) {
+	ust := usermemstore.NewUsers()
+	for _, name := range []string{"ivan", "ivanov", "petrov"} {
+		if _, err := ust.Create(context.Background(), user.User{ID: uuid.New(), Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rt := newTestRouter(t, user.NewUsers(ust))
+
+	w := searchAs(t, rt, MediaNDJSON)
+	if ct := w.Header().Get("Content-Type"); ct != MediaNDJSON {
+		t.Errorf("ndjson content type %q", ct)
+	}
+	var names []string
+	sc := bufio.NewScanner(w.Body)
+	for sc.Scan() {
+		var u FoundUser
+		if err := json.Unmarshal(sc.Bytes(), &u); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		names = append(names, u.Name)
+	}
+	if strings.Join(names, ",") != "ivan,ivanov" {
+		t.Errorf("ndjson users %v", names)
+	}
+
+	w = searchAs(t, rt, MediaSSE)
+	want := []string{"id: 1", "event: user", "id: 2", "event: user", "id: 3", "event: end", `data: {"count":2}`}
+	if got := sseFields(w.Body.String()); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("sse events:\n%s", w.Body.String())
+	}
+
+	if w := searchAs(t, rt, "text/html"); w.Code != http.StatusNotAcceptable {
+		t.Errorf("text/html: status %d", w.Code)
+	}
+}
+
+func TestRouter_SearchUserFormatsStreamError(t *testing.T) {
+	rt := newTestRouter(t, user.NewUsers(brokenStore{usermemstore.NewUsers()}))
+
+	w := searchAs(t, rt, MediaNDJSON)
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	last := SearchError{}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil ||
+		last.Error != "error when searching" || last.Code != user.CodeInternal || last.RequestID != w.Header().Get(HeaderRequestID) {
+		t.Errorf("ndjson last line %q", lines[len(lines)-1])
+	}
+
+	w = searchAs(t, rt, MediaSSE)
+	got := sseFields(w.Body.String())
+	if len(got) < 3 || got[len(got)-2] != "event: error" || strings.Contains(w.Body.String(), "event: end") {
+		t.Errorf("sse events:\n%s", w.Body.String())
+	}
+}
+
+// sseFields строки id и event всех событий и data последнего, пустые строки между событиями пропускаются
+func sseFields(body string) []string {
+	var res []string
+	data := ""
+	for _, l := range strings.Split(body, "\n") {
+		switch {
+		case strings.HasPrefix(l, "id: "), strings.HasPrefix(l, "event: "):
+			res = append(res, l)
+		case strings.HasPrefix(l, "data: "):
+			data = l
+		}
+	}
+	return append(res, data)
+}
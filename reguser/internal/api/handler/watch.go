@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/audetv/hex-ecample/reguser/internal/libs/websocket"
+)
+
+const (
+	// watchWriteTimeout сколько ждать отправки одного события клиенту
+	watchWriteTimeout = 10 * time.Second
+	// watchPingInterval как часто пинговать клиента, когда событий нет, чтобы заметить оборванное соединение
+	watchPingInterval = 30 * time.Second
+)
+
+// WatchEvent сообщение /watch: type created, updated или deleted и карточка пользователя
+type WatchEvent struct {
+	Type string `json:"type"`
+	User User   `json:"user"`
+}
+
+// Watch GET /api/v1/watch?q=...&mode=... WebSocket подписка на изменения пользователей, q и mode как в /search,
+// без q приходят все изменения. Каждое изменение это текстовое сообщение с WatchEvent.
+// Если клиент не успевает читать, соединение закрывается с кодом 1013, клиенту стоит переподключиться
+// и перечитать список через /search или /users.
+func (rt *Router) Watch(w http.ResponseWriter, r *http.Request) {
+	// контекст отменяется, когда обработчик выходит, вместе с ним снимается подписка
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	q := r.URL.Query()
+	ch, err := rt.us.Watch(ctx, q.Get("q"), q.Get("mode"))
+	if err != nil {
+		userError(w, r, err, "error when watching")
+		return
+	}
+	// на ошибку рукопожатия и чужой Origin Upgrade уже ответил сам
+	c, err := websocket.Upgrader{Origins: rt.origins}.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	// у захваченного соединения остаются таймауты http сервера, для долгой подписки они не нужны
+	_ = c.SetReadDeadline(time.Time{})
+
+	// Клиент ничего не присылает, но читать все равно надо: так мы отвечаем на ping
+	// и узнаем, что клиент закрыл соединение.
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(watchPingInterval)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			_ = c.Close(websocket.CloseNormal, "")
+			return
+		case <-rt.shutdown:
+			_ = c.Close(websocket.CloseGoingAway, "server is shutting down")
+			return
+		case <-ping.C:
+			_ = c.SetWriteDeadline(time.Now().Add(watchWriteTimeout))
+			err = c.Ping()
+		case ev, ok := <-ch:
+			if !ok {
+				// канал закрывается и при отмене контекста, тогда это не отставание
+				if ctx.Err() == nil {
+					_ = c.Close(websocket.CloseTryAgainLater, "too slow, events dropped")
+				}
+				return
+			}
+			var msg interface{} = WatchEvent{Type: string(ev.Type), User: apiUser(ev.User)}
+			if isLegacy(r) {
+				msg = LegacyWatchEvent{Type: string(ev.Type), User: legacyUser(ev.User)}
+			}
+			b, _ := json.Marshal(msg)
+			_ = c.SetWriteDeadline(time.Now().Add(watchWriteTimeout))
+			err = c.WriteMessage(websocket.TextMessage, b)
+		}
+		if err != nil {
+			_ = c.Close(websocket.CloseGoingAway, "")
+			return
+		}
+	}
+}
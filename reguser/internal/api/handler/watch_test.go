@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/audetv/hex-ecample/reguser/internal/db/mem/usermemstore"
+	"github.com/audetv/hex-ecample/reguser/internal/libs/websocket"
+	"github.com/google/uuid"
+)
+
+func TestRouter_Watch(t *testing.T) {
+	us := user.NewUsers(usermemstore.NewUsers())
+	rt := newTestRouter(t, us)
+	ts := httptest.NewServer(rt)
+	defer ts.Close()
+
+	admin := http.Header{"Authorization": {"Basic " + base64.StdEncoding.EncodeToString([]byte("admin:admin"))}}
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + APIPrefix + "/watch?q=ivan"
+
+	if _, err := websocket.Dial(wsURL, nil); !errors.Is(err, websocket.ErrBadHandshake) {
+		t.Errorf("watch without credentials: %v", err)
+	}
+	c, err := websocket.Dial(wsURL, admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = c.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// подписка оформляется до ответа на рукопожатие, поэтому изменения после Dial уже придут
+	ctx := adminContext()
+	u, err := us.Create(ctx, user.User{ID: uuid.New(), Name: "ivan"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Create(ctx, user.User{ID: uuid.New(), Name: "petrov"}); err != nil {
+		t.Fatal(err)
+	}
+	name := "ivanov"
+	if _, err := us.Patch(ctx, u.ID, user.UserPatch{Name: &name}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Delete(ctx, u.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for i := 0; i < 3; i++ {
+		_, msg, err := c.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ev WatchEvent
+		if err := json.Unmarshal(msg, &ev); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, ev.Type+" "+ev.User.Name)
+	}
+	if want := "created ivan,updated ivanov,deleted ivanov"; strings.Join(got, ",") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+
+	rt.Shutdown()
+	var ce *websocket.CloseError
+	if _, _, err := c.ReadMessage(); !errors.As(err, &ce) || ce.Code != websocket.CloseGoingAway {
+		t.Errorf("after shutdown: %v", err)
+	}
+}
+
+// TestRouter_WatchOrigin чужой сайт не может открыть подписку с учетными данными, которые подставил браузер
+func TestRouter_WatchOrigin(t *testing.T) {
+	rt := newTestRouter(t, user.NewUsers(usermemstore.NewUsers()))
+	rt.AllowOrigins("https://admin.example.com")
+	ts := httptest.NewServer(rt)
+	defer ts.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/v1/watch"
+	cases := map[string]bool{
+		"":                          true,
+		ts.URL:                      true,
+		"https://admin.example.com": true,
+		"https://evil.example.com":  false,
+	}
+	for origin, ok := range cases {
+		h := http.Header{"Authorization": {"Basic " + base64.StdEncoding.EncodeToString([]byte("admin:admin"))}}
+		if origin != "" {
+			h.Set("Origin", origin)
+		}
+		c, err := websocket.Dial(wsURL, h)
+		if (err == nil) != ok {
+			t.Errorf("origin %q: %v", origin, err)
+		}
+		if err == nil {
+			_ = c.Close(websocket.CloseNormal, "")
+		}
+	}
+}
+
+func adminContext() context.Context {
+	return auth.WithPrincipal(context.Background(), &auth.Principal{Subject: "admin", Permissions: auth.PermAll})
+}
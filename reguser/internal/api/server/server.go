@@ -2,7 +2,9 @@ package server
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"time"
 
@@ -13,44 +15,110 @@ import (
 // Входящий адаптер обращается в бизнес логику us user.Users
 // Должен открыть листенер для http протокола, мы должны в него встроить http сервер
 type Server struct {
-	srv http.Server
-	us  *user.Users
+	srv             http.Server
+	us              *user.Users
+	shutdownTimeout time.Duration
+	certs           *certReloader
+	stop            chan struct{}
+	ln              net.Listener
+}
+
+// Timeouts таймауты http сервера и остановки, нулевые значения заменяются значениями по умолчанию
+type Timeouts struct {
+	Read       time.Duration
+	Write      time.Duration
+	ReadHeader time.Duration
+	Shutdown   time.Duration
+}
+
+func orDefault(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
 }
 
 // NewServer Адрес и порт передавать через параметр
-func NewServer(addr string, h http.Handler) *Server {
-	s := &Server{}
+func NewServer(addr string, h http.Handler, t Timeouts) *Server {
+	s := &Server{
+		shutdownTimeout: orDefault(t.Shutdown, 2*time.Second),
+		stop:            make(chan struct{}),
+	}
 
 	s.srv = http.Server{
 		Addr:              addr,
 		Handler:           h,
-		ReadTimeout:       30 * time.Second,
-		WriteTimeout:      30 * time.Second,
-		ReadHeaderTimeout: 30 * time.Second,
+		ReadTimeout:       orDefault(t.Read, 30*time.Second),
+		WriteTimeout:      orDefault(t.Write, 30*time.Second),
+		ReadHeaderTimeout: orDefault(t.ReadHeader, 30*time.Second),
 	}
 	return s
 }
 
-// Делаем два метода, надо стартовать и остановить сервер,
-// Есть два варианта: ListenAndServe() и ListenAndServeTLS() - для https надо указать сертификационные файлы,
-// имена, которые в операционной системе резолвятся на файлы с сертификатами.
-// В примере используем http. Но если просто вызвать, то листен остановится, значит делаем это в горутине.
-// Так же можно обработать ошибку и вывести например ее в логи, в общемто больше с ней нечего делать.
+// EnableTLS переводит сервер на https, вызывается до Start. Сертификаты загружаются сразу,
+// чтобы ошибка в файлах была видна при запуске, а не на первом соединении.
+func (s *Server) EnableTLS(cfg TLSConfig) error {
+	cr, err := newCertReloader(cfg)
+	if err != nil {
+		return err
+	}
+	s.certs = cr
+	s.srv.TLSConfig = cr.tlsConfig()
+	return nil
+}
 
-func (s *Server) Start(us *user.Users) {
+// Делаем два метода, надо стартовать и остановить сервер.
+// Порт слушаем сразу в Start, чтобы ошибка вроде занятого порта вернулась вызывающему, а не потерялась в логах.
+// Serve блокируется, поэтому его запускаем в горутине, а его ошибку отдаем через канал:
+// в канал попадает не больше одной ошибки, после остановки сервера канал закрывается.
+func (s *Server) Start(us *user.Users) (<-chan error, error) {
 	s.us = us
+	addr := s.srv.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s.ln = ln
+	if s.certs != nil {
+		go s.certs.watch(s.stop)
+	}
+
+	errc := make(chan error, 1)
 	go func() {
-		err := s.srv.ListenAndServe()
-		if err != nil {
-			log.Printf("serve error %v:", err)
+		defer close(errc)
+		var err error
+		if s.certs != nil {
+			// сертификаты уже в TLSConfig, поэтому имена файлов не нужны
+			err = s.srv.ServeTLS(ln, "", "")
+		} else {
+			err = s.srv.Serve(ln)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errc <- fmt.Errorf("serve %s: %w", ln.Addr(), err)
 		}
 	}()
+	return errc, nil
+}
+
+// Addr адрес, который слушает сервер после Start, нужен когда в настройках порт 0
+func (s *Server) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// RegisterOnShutdown f вызывается в начале Stop, нужен обработчикам с захваченными соединениями,
+// например WebSocket, их http сервер сам не закрывает
+func (s *Server) RegisterOnShutdown(f func()) {
+	s.srv.RegisterOnShutdown(f)
 }
 
 // Stop метод для остановки сервера, для этого у http сервера есть Shutdown(), который принимает контекст.
-// Этот контекст сделаем с таймаутом
-func (s *Server) Stop() {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	_ = s.srv.Shutdown(ctx)
-	cancel()
+// Ждем не дольше таймаута остановки из настроек или дедлайна ctx, если он раньше.
+func (s *Server) Stop(ctx context.Context) error {
+	close(s.stop)
+	ctx, cancel := context.WithTimeout(ctx, s.shutdownTimeout)
+	defer cancel()
+	return s.srv.Shutdown(ctx)
 }
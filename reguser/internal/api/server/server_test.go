@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServer_Start(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	s := NewServer("127.0.0.1:0", h, Timeouts{})
+	errc, err := s.Start(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get("http://" + s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// порт занят, ошибка возвращается сразу из Start
+	busy := NewServer(s.Addr().String(), h, Timeouts{})
+	if _, err := busy.Start(nil); err == nil {
+		t.Error("started on a busy port")
+	}
+
+	if err := s.Stop(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if err, ok := <-errc; ok {
+		t.Errorf("error after stop: %v", err)
+	}
+}
+
+func TestServer_ServeError(t *testing.T) {
+	s := NewServer("127.0.0.1:0", http.NotFoundHandler(), Timeouts{})
+	errc, err := s.Start(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// листенер закрыт не через Stop, Serve падает и ошибка приходит в канал
+	s.ln.(*net.TCPListener).Close()
+	if err := <-errc; err == nil {
+		t.Error("no serve error")
+	}
+	_ = s.Stop(context.Background())
+}
+
+func TestServer_StartTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server.key")
+	ca := newTestCert(t, "test ca", 1, nil)
+	newTestCert(t, "localhost", 2, ca).write(t, certFile, keyFile, time.Now())
+
+	s := NewServer("127.0.0.1:0", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), Timeouts{})
+	if err := s.EnableTLS(TLSConfig{CertFile: certFile, KeyFile: keyFile}); err != nil {
+		t.Fatal(err)
+	}
+	// ServeTLS без имен файлов до go1.21 читает их, если во внешнем конфиге нет ни Certificates, ни GetCertificate
+	if s.srv.TLSConfig.GetCertificate == nil {
+		t.Error("outer tls config without GetCertificate")
+	}
+	errc, err := s.Start(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	resp, err := client.Get("https://" + s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.TLS.PeerCertificates[0].SerialNumber.Int64() != 2 {
+		t.Errorf("serial %v", resp.TLS.PeerCertificates[0].SerialNumber)
+	}
+
+	if err := s.Stop(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if err, ok := <-errc; ok {
+		t.Errorf("error after stop: %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSConfig настройки https. ClientCAFile включает проверку клиентских сертификатов,
+// ClientAuth определяет обязателен ли сертификат: tls.VerifyClientCertIfGiven или tls.RequireAndVerifyClientCert.
+// Файлы перечитываются раз в ReloadInterval, если изменились, так сертификат можно заменить без перезапуска.
+type TLSConfig struct {
+	CertFile       string
+	KeyFile        string
+	ClientCAFile   string
+	ClientAuth     tls.ClientAuthType
+	ReloadInterval time.Duration
+}
+
+// certReloader держит текущий сертификат и пул CA и подменяет их, когда файлы на диске меняются.
+// Каждое новое соединение получает конфиг с текущими значениями через GetConfigForClient.
+// GetCertificate во внешнем конфиге нужен http.Server.ServeTLS: до go1.21 он считает, что сертификат есть,
+// только если заданы Certificates или GetCertificate, иначе пытается читать файлы с пустыми именами.
+type certReloader struct {
+	cfg TLSConfig
+
+	mu    sync.RWMutex
+	cert  *tls.Certificate
+	pool  *x509.CertPool
+	mtime map[string]time.Time
+}
+
+func newCertReloader(cfg TLSConfig) (*certReloader, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("tls: cert and key files are required")
+	}
+	if cfg.ReloadInterval <= 0 {
+		cfg.ReloadInterval = 10 * time.Second
+	}
+	cr := &certReloader{cfg: cfg}
+	if err := cr.load(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+func (cr *certReloader) files() []string {
+	fs := []string{cr.cfg.CertFile, cr.cfg.KeyFile}
+	if cr.cfg.ClientCAFile != "" {
+		fs = append(fs, cr.cfg.ClientCAFile)
+	}
+	return fs
+}
+
+// stat время изменения файлов
+func (cr *certReloader) stat() (map[string]time.Time, error) {
+	m := map[string]time.Time{}
+	for _, f := range cr.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		m[f] = fi.ModTime()
+	}
+	return m, nil
+}
+
+// load читает сертификат, ключ и CA. Если что-то не читается, прежние значения остаются в силе.
+func (cr *certReloader) load() error {
+	mtime, err := cr.stat()
+	if err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(cr.cfg.CertFile, cr.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("tls: load key pair: %w", err)
+	}
+	var pool *x509.CertPool
+	if cr.cfg.ClientCAFile != "" {
+		b, err := os.ReadFile(cr.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("tls: no certificates in %s", cr.cfg.ClientCAFile)
+		}
+	}
+
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.pool = pool
+	cr.mtime = mtime
+	cr.mu.Unlock()
+	return nil
+}
+
+// changed изменился ли хоть один файл с прошлой загрузки
+func (cr *certReloader) changed() bool {
+	mtime, err := cr.stat()
+	if err != nil {
+		return false
+	}
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	for f, t := range mtime {
+		if !t.Equal(cr.mtime[f]) {
+			return true
+		}
+	}
+	return false
+}
+
+// watch проверяет файлы раз в ReloadInterval, пока не закроют stop
+func (cr *certReloader) watch(stop <-chan struct{}) {
+	t := time.NewTicker(cr.cfg.ReloadInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			if !cr.changed() {
+				continue
+			}
+			if err := cr.load(); err != nil {
+				log.Printf("tls reload error: %v", err)
+				continue
+			}
+			log.Printf("tls certificates reloaded")
+		}
+	}
+}
+
+// certificate текущий сертификат
+func (cr *certReloader) certificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+func (cr *certReloader) tlsConfig() *tls.Config {
+	clientAuth := tls.NoClientCert
+	if cr.cfg.ClientCAFile != "" {
+		clientAuth = cr.cfg.ClientAuth
+		if clientAuth == tls.NoClientCert {
+			clientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cr.certificate,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cr.mu.RLock()
+			defer cr.mu.RUnlock()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cr.cert},
+				ClientAuth:   clientAuth,
+				ClientCAs:    cr.pool,
+			}, nil
+		},
+	}
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCert(t *testing.T, cn string, serial int64, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: c, key: key}
+}
+
+func (c *testCert) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})
+}
+
+func (c *testCert) keyPEM(t *testing.T) []byte {
+	b, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b})
+}
+
+func (c *testCert) write(t *testing.T, certFile, keyFile string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(certFile, c.certPEM(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, c.keyPEM(t), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// на некоторых ФС разрешение mtime грубое, выставляем явно
+	for _, f := range []string{certFile, keyFile} {
+		if err := os.Chtimes(f, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (c *testCert) tlsCert(t *testing.T) tls.Certificate {
+	tc, err := tls.X509KeyPair(c.certPEM(), c.keyPEM(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tc
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server.key")
+	caFile := filepath.Join(dir, "ca.pem")
+
+	ca := newTestCert(t, "test ca", 1, nil)
+	if err := os.WriteFile(caFile, ca.certPEM(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	newTestCert(t, "localhost", 2, ca).write(t, certFile, keyFile, time.Now().Add(-time.Minute))
+
+	cr, err := newCertReloader(TLSConfig{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: caFile,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var subject string
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subject = r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}))
+	ts.TLS = cr.tlsConfig()
+	// отказы в рукопожатии ниже ожидаемые
+	ts.Config.ErrorLog = log.New(io.Discard, "", 0)
+	ts.StartTLS()
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	client := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: certs,
+		}}}
+	}
+	clientCert := newTestCert(t, "operator", 3, ca).tlsCert(t)
+
+	get := func(c *http.Client) (*http.Response, error) {
+		resp, err := c.Get(ts.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return resp, err
+	}
+
+	resp, err := get(client(clientCert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "operator" {
+		t.Errorf("subject %q", subject)
+	}
+	if resp.TLS.PeerCertificates[0].SerialNumber.Int64() != 2 {
+		t.Errorf("serial %v", resp.TLS.PeerCertificates[0].SerialNumber)
+	}
+
+	if _, err := get(client()); err == nil {
+		t.Error("no client certificate accepted")
+	}
+	stranger := newTestCert(t, "operator", 4, newTestCert(t, "other ca", 5, nil)).tlsCert(t)
+	if _, err := get(client(stranger)); err == nil {
+		t.Error("certificate from unknown CA accepted")
+	}
+
+	if cr.changed() {
+		t.Error("changed without changes")
+	}
+	newTestCert(t, "localhost", 6, ca).write(t, certFile, keyFile, time.Now())
+	if !cr.changed() {
+		t.Fatal("change not detected")
+	}
+	if err := cr.load(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = get(client(clientCert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.TLS.PeerCertificates[0].SerialNumber.Int64() != 6 {
+		t.Errorf("serial after reload %v", resp.TLS.PeerCertificates[0].SerialNumber)
+	}
+
+	// битый файл не ломает работающий сервер, остается прежний сертификат
+	if err := os.WriteFile(keyFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := cr.load(); err == nil {
+		t.Error("broken key loaded")
+	}
+	if _, err := get(client(clientCert)); err != nil {
+		t.Error(err)
+	}
+}
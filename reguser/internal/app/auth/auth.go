@@ -0,0 +1,45 @@
+// Package auth кто выполняет запрос. Входящий адаптер проверяет учетные данные и кладет Principal в контекст,
+// а бизнес логика и обработчики достают его оттуда, не зная каким способом вызывающий аутентифицирован.
+package auth
+
+import "context"
+
+const (
+	MethodBasic  = "basic"
+	MethodBearer = "bearer"
+	// MethodClientCert клиентский сертификат mTLS
+	MethodClientCert = "client_cert"
+	// MethodSystem внутренний вызов самого приложения, см. System
+	MethodSystem = "system"
+)
+
+// Principal аутентифицированный вызывающий. Claims заполнены только для токенов,
+// Permissions маска прав из permissions.go.
+type Principal struct {
+	Subject     string
+	Method      string
+	Claims      map[string]interface{}
+	Permissions int
+}
+
+// System вызывающий для внутренних вызовов самого приложения: запуск, проверка учетных данных, фоновые задачи.
+// У него все права, поэтому класть его в контекст можно только там, где вызов не исходит от клиента.
+var System = &Principal{Subject: "system", Method: MethodSystem, Permissions: PermAll}
+
+type principalKey struct{}
+
+// WithPrincipal возвращает контекст с вызывающим
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// WithSystem возвращает контекст с вызывающим System
+func WithSystem(ctx context.Context) context.Context {
+	return WithPrincipal(ctx, System)
+}
+
+// PrincipalFrom достает вызывающего из контекста, ok=false если запрос не аутентифицирован
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
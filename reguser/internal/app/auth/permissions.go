@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Права доступа - биты маски, такую маску хранит user.User.Permissions и получает Principal.
+// PermAdmin дает все права и еще право назначать права и управлять операторами.
+const (
+	PermRead = 1 << iota
+	PermCreate
+	PermUpdate
+	PermDelete
+	PermSearch
+	PermAdmin
+)
+
+// PermAll все права
+const PermAll = PermRead | PermCreate | PermUpdate | PermDelete | PermSearch | PermAdmin
+
+// ErrForbidden у вызывающего нет нужного права
+var ErrForbidden = errors.New("forbidden")
+
+var permNames = []struct {
+	perm int
+	name string
+}{
+	{PermRead, "read"},
+	{PermCreate, "create"},
+	{PermUpdate, "update"},
+	{PermDelete, "delete"},
+	{PermSearch, "search"},
+	{PermAdmin, "admin"},
+}
+
+// ParsePermissions собирает маску из имен прав
+func ParsePermissions(names []string) (int, error) {
+	perms := 0
+next:
+	for _, n := range names {
+		for _, p := range permNames {
+			if p.name == n {
+				perms |= p.perm
+				continue next
+			}
+		}
+		return 0, fmt.Errorf("unknown permission %q", n)
+	}
+	return perms, nil
+}
+
+// PermissionNames имена прав из маски, неизвестные биты отбрасываются
+func PermissionNames(perms int) []string {
+	names := []string{}
+	for _, p := range permNames {
+		if perms&p.perm != 0 {
+			names = append(names, p.name)
+		}
+	}
+	return names
+}
+
+// Require проверяет, что у вызывающего из контекста есть все права perm.
+// Контекст без Principal запрещен: забытая аутентификация не должна открывать доступ,
+// внутренние вызовы самого приложения явно идут от System.
+func Require(ctx context.Context, perm int) error {
+	p, ok := PrincipalFrom(ctx)
+	if !ok {
+		return ErrForbidden
+	}
+	if p.Permissions&PermAdmin != 0 || p.Permissions&perm == perm {
+		return nil
+	}
+	return ErrForbidden
+}
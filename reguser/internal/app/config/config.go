@@ -0,0 +1,393 @@
+// Package config настройки cmd/reguser. Значения собираются по возрастанию приоритета:
+// значения по умолчанию, YAML файл, переменные окружения, флаги командной строки.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Типы хранилища пользователей
+const (
+	StoreMemory   = "memory"
+	StoreFile     = "file"
+	StoreSQLite   = "sqlite"
+	StorePostgres = "postgres"
+)
+
+// Режимы fsync файлового хранилища
+const (
+	SyncAlways   = "always"
+	SyncInterval = "interval"
+	SyncNever    = "never"
+)
+
+// Уникальность имен пользователей: any не проверяется, exact точное совпадение,
+// fold без учета регистра и лишних пробелов. Соблюдают все хранилища, sql через уникальный индекс.
+const (
+	UniqueNamesAny   = "any"
+	UniqueNamesExact = "exact"
+	UniqueNamesFold  = "fold"
+)
+
+// Проверка клиентских сертификатов, если задан server.tls.client_ca_file
+const (
+	ClientAuthOptional = "optional"
+	ClientAuthRequire  = "require"
+)
+
+// Config все настройки сервиса
+type Config struct {
+	Server ServerConfig `yaml:"server"`
+	Store  StoreConfig  `yaml:"store"`
+	Auth   AuthConfig   `yaml:"auth"`
+
+	// File путь к файлу конфигурации, если он был
+	File string `yaml:"-"`
+	// PrintConfig вывести итоговую конфигурацию и выйти
+	PrintConfig bool `yaml:"-"`
+}
+
+type ServerConfig struct {
+	Addr              string    `yaml:"addr"`
+	ReadTimeout       Duration  `yaml:"read_timeout"`
+	WriteTimeout      Duration  `yaml:"write_timeout"`
+	ReadHeaderTimeout Duration  `yaml:"read_header_timeout"`
+	ShutdownTimeout   Duration  `yaml:"shutdown_timeout"`
+	TLS               TLSConfig `yaml:"tls"`
+	// WatchOrigins с каких еще сайтов, кроме своего, браузер может открыть /watch, например https://admin.example.com
+	WatchOrigins []string `yaml:"watch_origins"`
+}
+
+// TLSConfig https включается, если заданы сертификат и ключ. ClientCAFile включает mTLS,
+// ClientAuth optional - сертификат проверяется если его прислали, require - без него соединение не примут.
+type TLSConfig struct {
+	CertFile       string   `yaml:"cert_file"`
+	KeyFile        string   `yaml:"key_file"`
+	ClientCAFile   string   `yaml:"client_ca_file"`
+	ClientAuth     string   `yaml:"client_auth"`
+	ReloadInterval Duration `yaml:"reload_interval"`
+}
+
+type StoreConfig struct {
+	Type string `yaml:"type"`
+	// Path файл для file и sqlite
+	Path string `yaml:"path"`
+	// DSN строка подключения для postgres
+	DSN          string   `yaml:"dsn"`
+	Sync         string   `yaml:"sync"`
+	SyncInterval Duration `yaml:"sync_interval"`
+	UniqueNames  string   `yaml:"unique_names"`
+}
+
+type AuthConfig struct {
+	// OperatorsFile файл с операторами, пустой - операторы в памяти
+	OperatorsFile string `yaml:"operators_file"`
+	// AdminLogin и AdminPassword первый оператор со всеми правами, заводится при старте если его нет
+	AdminLogin    string    `yaml:"admin_login"`
+	AdminPassword string    `yaml:"admin_password"`
+	BcryptCost    int       `yaml:"bcrypt_cost"`
+	JWT           JWTConfig `yaml:"jwt"`
+}
+
+// JWTConfig проверка bearer токенов включается, если задан JWKS
+type JWTConfig struct {
+	JWKS     string   `yaml:"jwks"`
+	Issuer   string   `yaml:"issuer"`
+	Audience string   `yaml:"audience"`
+	Leeway   Duration `yaml:"leeway"`
+}
+
+// Duration time.Duration, которая в YAML, переменных и флагах пишется строкой вида 30s
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(n *yaml.Node) error {
+	return d.Set(n.Value)
+}
+
+// Default значения по умолчанию, те что раньше были зашиты в код
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr:              ":8000",
+			ReadTimeout:       Duration{30 * time.Second},
+			WriteTimeout:      Duration{30 * time.Second},
+			ReadHeaderTimeout: Duration{30 * time.Second},
+			ShutdownTimeout:   Duration{2 * time.Second},
+			TLS: TLSConfig{
+				ClientAuth:     ClientAuthOptional,
+				ReloadInterval: Duration{10 * time.Second},
+			},
+		},
+		Store: StoreConfig{
+			Type:         StoreMemory,
+			Sync:         SyncAlways,
+			SyncInterval: Duration{time.Second},
+			UniqueNames:  UniqueNamesFold,
+		},
+	}
+}
+
+type stringValue struct{ p *string }
+
+func (v stringValue) Set(s string) error { *v.p = s; return nil }
+func (v stringValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return *v.p
+}
+
+// listValue список через запятую, пустые элементы отбрасываются
+type listValue struct{ p *[]string }
+
+func (v listValue) Set(s string) error {
+	*v.p = nil
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			*v.p = append(*v.p, e)
+		}
+	}
+	return nil
+}
+func (v listValue) String() string {
+	if v.p == nil {
+		return ""
+	}
+	return strings.Join(*v.p, ",")
+}
+
+type intValue struct{ p *int }
+
+func (v intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	*v.p = n
+	return nil
+}
+func (v intValue) String() string {
+	if v.p == nil {
+		return "0"
+	}
+	return strconv.Itoa(*v.p)
+}
+
+// field одна настройка: имя флага, переменная окружения и где она лежит в Config
+type field struct {
+	flag  string
+	env   string
+	usage string
+	value func(c *Config) flag.Value
+}
+
+var fields = []field{
+	{"addr", "REGUSER_ADDR", "listen address", func(c *Config) flag.Value { return stringValue{&c.Server.Addr} }},
+	{"read-timeout", "REGUSER_READ_TIMEOUT", "http read timeout", func(c *Config) flag.Value { return &c.Server.ReadTimeout }},
+	{"write-timeout", "REGUSER_WRITE_TIMEOUT", "http write timeout", func(c *Config) flag.Value { return &c.Server.WriteTimeout }},
+	{"read-header-timeout", "REGUSER_READ_HEADER_TIMEOUT", "http read header timeout", func(c *Config) flag.Value { return &c.Server.ReadHeaderTimeout }},
+	{"shutdown-timeout", "REGUSER_SHUTDOWN_TIMEOUT", "graceful shutdown timeout", func(c *Config) flag.Value { return &c.Server.ShutdownTimeout }},
+	{"tls-cert", "REGUSER_TLS_CERT", "TLS certificate file, enables https", func(c *Config) flag.Value { return stringValue{&c.Server.TLS.CertFile} }},
+	{"tls-key", "REGUSER_TLS_KEY", "TLS private key file", func(c *Config) flag.Value { return stringValue{&c.Server.TLS.KeyFile} }},
+	{"tls-client-ca", "REGUSER_TLS_CLIENT_CA", "CA bundle for client certificates, enables mTLS", func(c *Config) flag.Value { return stringValue{&c.Server.TLS.ClientCAFile} }},
+	{"tls-client-auth", "REGUSER_TLS_CLIENT_AUTH", "client certificate policy: optional or require", func(c *Config) flag.Value { return stringValue{&c.Server.TLS.ClientAuth} }},
+	{"tls-reload-interval", "REGUSER_TLS_RELOAD_INTERVAL", "how often to check certificate files for changes", func(c *Config) flag.Value { return &c.Server.TLS.ReloadInterval }},
+	{"watch-origins", "REGUSER_WATCH_ORIGINS", "comma separated origins allowed to open /watch besides the server itself", func(c *Config) flag.Value { return listValue{&c.Server.WatchOrigins} }},
+	{"store", "REGUSER_STORE", "user store: memory, file, sqlite or postgres", func(c *Config) flag.Value { return stringValue{&c.Store.Type} }},
+	{"store-path", "REGUSER_STORE_PATH", "file for file and sqlite stores", func(c *Config) flag.Value { return stringValue{&c.Store.Path} }},
+	{"store-dsn", "REGUSER_STORE_DSN", "postgres connection string", func(c *Config) flag.Value { return stringValue{&c.Store.DSN} }},
+	{"store-sync", "REGUSER_STORE_SYNC", "file store fsync mode: always, interval or never", func(c *Config) flag.Value { return stringValue{&c.Store.Sync} }},
+	{"store-sync-interval", "REGUSER_STORE_SYNC_INTERVAL", "file store fsync interval", func(c *Config) flag.Value { return &c.Store.SyncInterval }},
+	{"store-unique-names", "REGUSER_STORE_UNIQUE_NAMES", "user name uniqueness: any, exact or fold", func(c *Config) flag.Value { return stringValue{&c.Store.UniqueNames} }},
+	{"operators-file", "REGUSER_OPERATORS_FILE", "operators file, operators are kept in memory if empty", func(c *Config) flag.Value { return stringValue{&c.Auth.OperatorsFile} }},
+	{"admin-login", "REGUSER_ADMIN_LOGIN", "bootstrap operator login", func(c *Config) flag.Value { return stringValue{&c.Auth.AdminLogin} }},
+	{"admin-password", "REGUSER_ADMIN_PASSWORD", "bootstrap operator password", func(c *Config) flag.Value { return stringValue{&c.Auth.AdminPassword} }},
+	{"bcrypt-cost", "REGUSER_BCRYPT_COST", "bcrypt cost for operator passwords, 0 for default", func(c *Config) flag.Value { return intValue{&c.Auth.BcryptCost} }},
+	{"jwt-jwks", "REGUSER_JWT_JWKS", "JWKS file, enables bearer tokens", func(c *Config) flag.Value { return stringValue{&c.Auth.JWT.JWKS} }},
+	{"jwt-issuer", "REGUSER_JWT_ISSUER", "expected token issuer", func(c *Config) flag.Value { return stringValue{&c.Auth.JWT.Issuer} }},
+	{"jwt-audience", "REGUSER_JWT_AUDIENCE", "expected token audience", func(c *Config) flag.Value { return stringValue{&c.Auth.JWT.Audience} }},
+	{"jwt-leeway", "REGUSER_JWT_LEEWAY", "allowed clock skew for tokens", func(c *Config) flag.Value { return &c.Auth.JWT.Leeway }},
+}
+
+// Load собирает конфигурацию из args (без имени программы) и окружения getenv.
+// Файл конфигурации задается флагом -config или переменной REGUSER_CONFIG.
+func Load(args []string, getenv func(string) string, output io.Writer) (*Config, error) {
+	// Флаги разбираем в отдельную копию, а применяем в самом конце только явно заданные,
+	// иначе значения флагов по умолчанию перетерли бы файл и окружение.
+	fcfg := Default()
+	fs := flag.NewFlagSet("reguser", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&fcfg.File, "config", "", "YAML config file (env REGUSER_CONFIG)")
+	fs.BoolVar(&fcfg.PrintConfig, "print-config", false, "print the resulting config and exit")
+	for _, f := range fields {
+		fs.Var(f.value(&fcfg), f.flag, fmt.Sprintf("%s (env %s)", f.usage, f.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	cfg := Default()
+	cfg.File = fcfg.File
+	cfg.PrintConfig = fcfg.PrintConfig
+	if cfg.File == "" {
+		cfg.File = getenv("REGUSER_CONFIG")
+	}
+	if cfg.File != "" {
+		if err := cfg.loadFile(cfg.File); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range fields {
+		v := getenv(f.env)
+		if v == "" {
+			continue
+		}
+		if err := f.value(&cfg).Set(v); err != nil {
+			return nil, fmt.Errorf("env %s: %w", f.env, err)
+		}
+	}
+
+	var ferr error
+	fs.Visit(func(fl *flag.Flag) {
+		for _, f := range fields {
+			if f.flag == fl.Name && ferr == nil {
+				ferr = f.value(&cfg).Set(fl.Value.String())
+			}
+		}
+	})
+	if ferr != nil {
+		return nil, ferr
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// loadFile накладывает YAML файл поверх текущих значений, неизвестные ключи - ошибка
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate проверяет конфигурацию и возвращает все найденные ошибки разом
+func (c *Config) Validate() error {
+	errs := []string{}
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr is empty")
+	check(c.Server.ReadTimeout.Duration > 0, "server.read_timeout must be positive")
+	check(c.Server.WriteTimeout.Duration > 0, "server.write_timeout must be positive")
+	check(c.Server.ReadHeaderTimeout.Duration > 0, "server.read_header_timeout must be positive")
+	check(c.Server.ShutdownTimeout.Duration > 0, "server.shutdown_timeout must be positive")
+
+	tls := c.Server.TLS
+	check((tls.CertFile == "") == (tls.KeyFile == ""), "server.tls.cert_file and server.tls.key_file must be set together")
+	check(tls.ClientCAFile == "" || tls.CertFile != "", "server.tls.client_ca_file requires server.tls.cert_file")
+	check(tls.ClientAuth == ClientAuthOptional || tls.ClientAuth == ClientAuthRequire, "unknown server.tls.client_auth %q", tls.ClientAuth)
+	check(tls.ReloadInterval.Duration > 0, "server.tls.reload_interval must be positive")
+	for _, o := range c.Server.WatchOrigins {
+		u, err := url.Parse(o)
+		check(err == nil && u.Scheme != "" && u.Host != "" && u.Path == "", "server.watch_origins: %q is not an origin like https://example.com", o)
+	}
+
+	switch c.Store.Type {
+	case StoreMemory:
+	case StoreFile, StoreSQLite:
+		check(c.Store.Path != "", "store.path is required for %s store", c.Store.Type)
+	case StorePostgres:
+		check(c.Store.DSN != "", "store.dsn is required for postgres store")
+	default:
+		check(false, "unknown store.type %q", c.Store.Type)
+	}
+	switch c.Store.Sync {
+	case SyncAlways, SyncNever:
+	case SyncInterval:
+		check(c.Store.SyncInterval.Duration > 0, "store.sync_interval must be positive")
+	default:
+		check(false, "unknown store.sync %q", c.Store.Sync)
+	}
+	switch c.Store.UniqueNames {
+	case UniqueNamesAny, UniqueNamesExact, UniqueNamesFold:
+	default:
+		check(false, "unknown store.unique_names %q", c.Store.UniqueNames)
+	}
+
+	check((c.Auth.AdminLogin == "") == (c.Auth.AdminPassword == ""), "auth.admin_login and auth.admin_password must be set together")
+	check(c.Auth.BcryptCost == 0 || (c.Auth.BcryptCost >= 4 && c.Auth.BcryptCost <= 31), "auth.bcrypt_cost must be between 4 and 31")
+	check(c.Auth.JWT.Leeway.Duration >= 0, "auth.jwt.leeway must not be negative")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+var dsnPassword = regexp.MustCompile(`(password=)(\S+)`)
+
+// Redacted копия конфигурации без секретов, для вывода
+func (c Config) Redacted() Config {
+	if c.Auth.AdminPassword != "" {
+		c.Auth.AdminPassword = "xxxxx"
+	}
+	if u, err := url.Parse(c.Store.DSN); err == nil && u.User != nil {
+		c.Store.DSN = u.Redacted()
+	} else {
+		c.Store.DSN = dsnPassword.ReplaceAllString(c.Store.DSN, "${1}xxxxx")
+	}
+	return c
+}
+
+// Print выводит конфигурацию без секретов в YAML
+func (c Config) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.Redacted()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
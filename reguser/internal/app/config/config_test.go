@@ -0,0 +1,106 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func env(m map[string]string) func(string) string {
+	return func(k string) string { return m[k] }
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reguser.yaml")
+	err := os.WriteFile(path, []byte(`
+server:
+  addr: ":9000"
+  read_timeout: 10s
+  write_timeout: 11s
+store:
+  type: sqlite
+  path: file.db
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(
+		[]string{"-config", path, "-write-timeout", "12s"},
+		env(map[string]string{
+			"REGUSER_READ_TIMEOUT":  "20s",
+			"REGUSER_WRITE_TIMEOUT": "21s",
+			"REGUSER_WATCH_ORIGINS": "https://admin.example.com, http://localhost:3000",
+		}),
+		&bytes.Buffer{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// файл перекрывает значения по умолчанию
+	if cfg.Server.Addr != ":9000" || cfg.Store.Type != StoreSQLite {
+		t.Errorf("file values: %+v", cfg)
+	}
+	// окружение перекрывает файл
+	if cfg.Server.ReadTimeout.Duration != 20*time.Second {
+		t.Errorf("read timeout %v", cfg.Server.ReadTimeout)
+	}
+	if o := cfg.Server.WatchOrigins; len(o) != 2 || o[1] != "http://localhost:3000" {
+		t.Errorf("watch origins %q", o)
+	}
+	// флаг перекрывает окружение
+	if cfg.Server.WriteTimeout.Duration != 12*time.Second {
+		t.Errorf("write timeout %v", cfg.Server.WriteTimeout)
+	}
+	// значение по умолчанию остается, если его никто не задал
+	if cfg.Server.ShutdownTimeout.Duration != 2*time.Second {
+		t.Errorf("shutdown timeout %v", cfg.Server.ShutdownTimeout)
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	_, err := Load(
+		[]string{"-store", "file", "-read-timeout", "0s", "-admin-login", "admin", "-tls-client-ca", "ca.pem", "-store-unique-names", "lower", "-watch-origins", "example.com"},
+		env(nil),
+		&bytes.Buffer{},
+	)
+	if err == nil {
+		t.Fatal("no error")
+	}
+	for _, want := range []string{"read_timeout", "store.path", "admin_password", "client_ca_file", "unique_names", "watch_origins"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+
+	if _, err := Load(nil, env(map[string]string{"REGUSER_SHUTDOWN_TIMEOUT": "soon"}), &bytes.Buffer{}); err == nil {
+		t.Error("bad duration in env")
+	}
+
+	path := filepath.Join(t.TempDir(), "reguser.yaml")
+	if err := os.WriteFile(path, []byte("server:\n  adr: x\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(nil, env(map[string]string{"REGUSER_CONFIG": path}), &bytes.Buffer{}); err == nil {
+		t.Error("unknown key in file")
+	}
+}
+
+func TestConfig_Print(t *testing.T) {
+	cfg := Default()
+	cfg.Auth.AdminPassword = "secret"
+	cfg.Store.DSN = "host=db user=u password=secret"
+	b := &bytes.Buffer{}
+	if err := cfg.Print(b); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(b.String(), "secret") {
+		t.Errorf("secret in printed config:\n%s", b)
+	}
+	if !strings.Contains(b.String(), "read_timeout: 30s") {
+		t.Errorf("durations are not printed as strings:\n%s", b)
+	}
+}
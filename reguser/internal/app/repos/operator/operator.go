@@ -0,0 +1,185 @@
+package operator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Operator учетная запись оператора сервиса, того кто ходит в api.
+// Пароль храним только в виде bcrypt хэша, Permissions маска прав auth.Perm*.
+type Operator struct {
+	Login        string
+	PasswordHash []byte
+	Permissions  int
+	Disabled     bool
+	UpdatedAt    time.Time
+}
+
+var (
+	// ErrExists оператор с таким логином уже есть
+	ErrExists = errors.New("operator already exists")
+	// ErrInvalidCredentials неверный логин или пароль, или оператор отключен.
+	// Причину наружу не раскрываем, чтобы нельзя было перебирать логины.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrEmptyPassword пустой логин или пароль
+	ErrEmptyPassword = errors.New("empty login or password")
+)
+
+// OperatorStore интерфейс системы хранения операторов, отдельный порт от хранилища пользователей.
+// Create возвращает ErrExists если логин занят, Read и Update возвращают sql.ErrNoRows если оператора нет.
+// Update читает оператора, меняет его через f и сохраняет атомарно, под одним локом, чтобы два параллельных
+// изменения не затерли друг друга, например смена пароля не включила обратно только что отключенного.
+// Логин f не меняет.
+type OperatorStore interface {
+	Create(ctx context.Context, o Operator) error
+	Read(ctx context.Context, login string) (*Operator, error)
+	Update(ctx context.Context, login string, f func(o *Operator)) error
+	List(ctx context.Context) ([]Operator, error)
+}
+
+// Operators репозиторий операторов: заведение, смена пароля, отключение и проверка пароля.
+// Управлять операторами может только администратор, Authenticate доступен всем.
+type Operators struct {
+	ostore OperatorStore
+	cost   int
+	// dummy хэш для проверки пароля несуществующего оператора, чтобы время ответа не выдавало есть ли такой логин
+	dummy []byte
+}
+
+// NewOperators cost стоимость bcrypt, 0 означает bcrypt.DefaultCost
+func NewOperators(ostore OperatorStore, cost int) *Operators {
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	dummy, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), cost)
+	return &Operators{
+		ostore: ostore,
+		cost:   cost,
+		dummy:  dummy,
+	}
+}
+
+func (ops *Operators) hash(password string) ([]byte, error) {
+	if password == "" {
+		return nil, ErrEmptyPassword
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), ops.cost)
+}
+
+// Create заводит нового включенного оператора с правами perms
+func (ops *Operators) Create(ctx context.Context, login, password string, perms int) (*Operator, error) {
+	if err := auth.Require(ctx, auth.PermAdmin); err != nil {
+		return nil, fmt.Errorf("create operator error: %w", err)
+	}
+	if login == "" {
+		return nil, ErrEmptyPassword
+	}
+	h, err := ops.hash(password)
+	if err != nil {
+		return nil, fmt.Errorf("create operator error: %w", err)
+	}
+	o := Operator{
+		Login:        login,
+		PasswordHash: h,
+		Permissions:  perms,
+		UpdatedAt:    time.Now().UTC(),
+	}
+	if err := ops.ostore.Create(ctx, o); err != nil {
+		return nil, fmt.Errorf("create operator error: %w", err)
+	}
+	return &o, nil
+}
+
+// SetPassword меняет пароль оператору (ротация)
+func (ops *Operators) SetPassword(ctx context.Context, login, password string) error {
+	h, err := ops.hash(password)
+	if err != nil {
+		return fmt.Errorf("set password error: %w", err)
+	}
+	return ops.change(ctx, login, func(o *Operator) {
+		o.PasswordHash = h
+	})
+}
+
+// SetPermissions назначает оператору права
+func (ops *Operators) SetPermissions(ctx context.Context, login string, perms int) error {
+	return ops.change(ctx, login, func(o *Operator) {
+		o.Permissions = perms
+	})
+}
+
+// SetDisabled отключает или снова включает оператора, отключенный не проходит Authenticate
+func (ops *Operators) SetDisabled(ctx context.Context, login string, disabled bool) error {
+	return ops.change(ctx, login, func(o *Operator) {
+		o.Disabled = disabled
+	})
+}
+
+func (ops *Operators) change(ctx context.Context, login string, f func(o *Operator)) error {
+	if err := auth.Require(ctx, auth.PermAdmin); err != nil {
+		return fmt.Errorf("change operator error: %w", err)
+	}
+	err := ops.ostore.Update(ctx, login, func(o *Operator) {
+		f(o)
+		o.UpdatedAt = time.Now().UTC()
+	})
+	if err != nil {
+		return fmt.Errorf("update operator error: %w", err)
+	}
+	return nil
+}
+
+// List операторы без хэшей паролей
+func (ops *Operators) List(ctx context.Context) ([]Operator, error) {
+	if err := auth.Require(ctx, auth.PermAdmin); err != nil {
+		return nil, fmt.Errorf("list operators error: %w", err)
+	}
+	list, err := ops.ostore.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list operators error: %w", err)
+	}
+	for i := range list {
+		list[i].PasswordHash = nil
+	}
+	return list, nil
+}
+
+// Authenticate проверяет логин и пароль. bcrypt сравнивает хэши за постоянное время,
+// а для неизвестного логина сравниваем с фиктивным хэшем, так что время ответа одинаково для любых неверных данных.
+func (ops *Operators) Authenticate(ctx context.Context, login, password string) (*Operator, error) {
+	o, err := ops.ostore.Read(ctx, login)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("read operator error: %w", err)
+	}
+	h := ops.dummy
+	if o != nil {
+		h = o.PasswordHash
+	}
+	perr := bcrypt.CompareHashAndPassword(h, []byte(password))
+	if o == nil || perr != nil || o.Disabled {
+		return nil, ErrInvalidCredentials
+	}
+	return o, nil
+}
+
+// AuthenticateCert находит оператора по имени из проверенного клиентского сертификата.
+// Пароль не нужен, цепочку сертификата уже проверил TLS, но оператор должен существовать и быть включен.
+func (ops *Operators) AuthenticateCert(ctx context.Context, login string) (*Operator, error) {
+	o, err := ops.ostore.Read(ctx, login)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read operator error: %w", err)
+	}
+	if o.Disabled {
+		return nil, ErrInvalidCredentials
+	}
+	return o, nil
+}
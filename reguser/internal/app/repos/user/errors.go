@@ -0,0 +1,114 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
+)
+
+// Виды ошибок бизнес логики, проверяются через errors.Is(err, user.ErrNotFound).
+// Входящие адаптеры выбирают ответ по виду и не разбирают ошибки хранилищ сами.
+var (
+	ErrNotFound    = errors.New("not found")
+	ErrConflict    = errors.New("conflict")
+	ErrInvalid     = errors.New("invalid")
+	ErrForbidden   = errors.New("forbidden")
+	ErrUnavailable = errors.New("unavailable")
+	ErrStale       = errors.New("stale")
+)
+
+// Стабильные коды ошибок для клиентов. Тексты ошибок могут меняться, коды нет.
+const (
+	CodeUserNotFound     = "user_not_found"
+	CodeConflict         = "conflict"
+	CodeNameTaken        = "name_taken"
+	CodeVersionMismatch  = "version_mismatch"
+	CodeForbidden        = "forbidden"
+	CodeInvalidQuery     = "invalid_query"
+	CodeInvalidSort      = "invalid_sort"
+	CodeInvalidCursor    = "invalid_cursor"
+	CodeValidation       = "validation_failed"
+	CodeStoreUnavailable = "store_unavailable"
+	CodeInternal         = "internal"
+)
+
+// FieldError нарушение в одном поле запроса, Code стабильный, например required или unknown_permission
+type FieldError struct {
+	Field   string
+	Code    string
+	Message string
+}
+
+// Error ошибка бизнес логики: вид Kind (одна из Err* выше, nil для внутренней ошибки), стабильный Code,
+// нарушения по полям и исходная причина Err. errors.Is находит и вид, и причину, например sql.ErrNoRows.
+type Error struct {
+	Kind   error
+	Code   string
+	Op     string
+	Fields []FieldError
+	Err    error
+}
+
+func (e *Error) Error() string {
+	msg := e.Code
+	if e.Err != nil {
+		msg = e.Err.Error()
+	}
+	if e.Op == "" {
+		return msg
+	}
+	return e.Op + " error: " + msg
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+func (e *Error) Is(target error) bool { return e.Kind != nil && target == e.Kind }
+
+// Invalid ошибка проверки входных данных с нарушениями по полям
+func Invalid(code string, err error, fields ...FieldError) *Error {
+	return &Error{Kind: ErrInvalid, Code: code, Err: err, Fields: fields}
+}
+
+// wrap переводит ошибку хранилища, прав или разбора запроса в Error, op попадает в текст ошибки.
+// Уже разобранную Error не трогает, только дописывает op, если его не было.
+func wrap(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		if e.Op == "" {
+			ne := *e
+			ne.Op = op
+			return &ne
+		}
+		return err
+	}
+	e = &Error{Op: op, Err: err, Code: CodeInternal}
+	var netErr net.Error
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		e.Kind, e.Code = ErrNotFound, CodeUserNotFound
+	case errors.Is(err, ErrNameTaken):
+		e.Kind, e.Code = ErrConflict, CodeNameTaken
+		e.Fields = []FieldError{{Field: "name", Code: "taken", Message: err.Error()}}
+	case errors.Is(err, ErrVersionMismatch):
+		e.Kind, e.Code = ErrStale, CodeVersionMismatch
+	case errors.Is(err, auth.ErrForbidden):
+		e.Kind, e.Code = ErrForbidden, CodeForbidden
+	case errors.Is(err, ErrInvalidQuery):
+		e.Kind, e.Code = ErrInvalid, CodeInvalidQuery
+	case errors.Is(err, ErrInvalidSort):
+		e.Kind, e.Code = ErrInvalid, CodeInvalidSort
+	case errors.Is(err, ErrInvalidCursor):
+		e.Kind, e.Code = ErrInvalid, CodeInvalidCursor
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone), errors.As(err, &netErr):
+		e.Kind, e.Code = ErrUnavailable, CodeStoreUnavailable
+	}
+	return e
+}
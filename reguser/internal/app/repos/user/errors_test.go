@@ -0,0 +1,46 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
+)
+
+func TestWrap(t *testing.T) {
+	cases := []struct {
+		err  error
+		kind error
+		code string
+	}{
+		{sql.ErrNoRows, ErrNotFound, CodeUserNotFound},
+		{fmt.Errorf("tx: %w", auth.ErrForbidden), ErrForbidden, CodeForbidden},
+		{fmt.Errorf("%w: unexpected end", ErrInvalidQuery), ErrInvalid, CodeInvalidQuery},
+		{context.DeadlineExceeded, ErrUnavailable, CodeStoreUnavailable},
+		{errors.New("disk full"), nil, CodeInternal},
+	}
+	for _, c := range cases {
+		err := wrap("read user", c.err)
+		e := &Error{}
+		if !errors.As(err, &e) || e.Code != c.code || e.Kind != c.kind {
+			t.Errorf("%v: got %#v", c.err, err)
+			continue
+		}
+		if !errors.Is(err, c.err) || (c.kind != nil && !errors.Is(err, c.kind)) {
+			t.Errorf("%v: cause or kind lost", c.err)
+		}
+		if err.Error() != "read user error: "+c.err.Error() {
+			t.Errorf("%v: text %q", c.err, err.Error())
+		}
+	}
+
+	// уже разобранная ошибка сохраняет вид и поля, op дописывается один раз
+	err := wrap("list users", wrap("parse", Invalid(CodeInvalidSort, ErrInvalidSort, FieldError{Field: "sort"})))
+	e := &Error{}
+	if !errors.As(err, &e) || e.Op != "parse" || len(e.Fields) != 1 || !errors.Is(err, ErrInvalid) {
+		t.Errorf("rewrapped %#v", err)
+	}
+}
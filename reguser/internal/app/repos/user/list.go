@@ -0,0 +1,155 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
+	"github.com/google/uuid"
+)
+
+// Сортировка списка пользователей
+const (
+	SortByID   = "id"
+	SortByName = "name"
+)
+
+// Размер страницы списка по умолчанию и наибольший
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 1000
+)
+
+var (
+	// ErrInvalidSort неизвестное поле сортировки
+	ErrInvalidSort = errors.New("invalid sort")
+	// ErrInvalidCursor курсор испорчен или выдан для другой сортировки
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// ListKey позиция в списке, ключ последнего отданного пользователя.
+// При сортировке по имени ID различает одинаковые имена, поэтому позиция однозначна
+// и не сдвигается, когда между страницами добавляют или удаляют пользователей.
+type ListKey struct {
+	Name string
+	ID   uuid.UUID
+}
+
+// KeyOf позиция пользователя в списке
+func KeyOf(u User) ListKey {
+	return ListKey{Name: u.Name, ID: u.ID}
+}
+
+// ListQuery запрос страницы к хранилищу: до Limit пользователей в порядке Sort,
+// строго после After, nil After - с начала. Desc обратный порядок.
+// Имена сравниваются побайтно, ID как 16 байт, одинаково во всех хранилищах.
+type ListQuery struct {
+	Sort  string
+	Desc  bool
+	After *ListKey
+	Limit int
+}
+
+// CompareKeys сравнивает позиции в порядке сортировки sort по возрастанию, результат как у bytes.Compare
+func CompareKeys(sort string, a, b ListKey) int {
+	if sort == SortByName {
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+	}
+	return bytes.Compare(a.ID[:], b.ID[:])
+}
+
+// Page страница списка, Next курсор следующей страницы, пустой если это последняя
+type Page struct {
+	Users []User
+	Next  string
+}
+
+// cursor то что зашито в непрозрачный курсор, сортировку храним, чтобы курсор нельзя было
+// применить к списку в другом порядке
+type cursor struct {
+	Sort string    `json:"s"`
+	Name string    `json:"n,omitempty"`
+	ID   uuid.UUID `json:"i"`
+}
+
+func encodeCursor(sort string, k ListKey) string {
+	c := cursor{Sort: sort, ID: k.ID}
+	if strings.TrimPrefix(sort, "-") == SortByName {
+		c.Name = k.Name
+	}
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(sort, s string) (*ListKey, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := cursor{}
+	if err := json.Unmarshal(b, &c); err != nil || c.Sort != sort {
+		return nil, ErrInvalidCursor
+	}
+	return &ListKey{Name: c.Name, ID: c.ID}, nil
+}
+
+// parseSort sort вида name, -name, id, -id, минус обратный порядок, пустая строка это id
+func parseSort(sort string) (ListQuery, error) {
+	q := ListQuery{Sort: strings.TrimPrefix(sort, "-"), Desc: strings.HasPrefix(sort, "-")}
+	switch q.Sort {
+	case "":
+		q.Sort = SortByID
+	case SortByID, SortByName:
+	default:
+		return q, ErrInvalidSort
+	}
+	return q, nil
+}
+
+// ListUsers страница списка всех пользователей. Курсор берется из Next предыдущей страницы
+// и годится только для той же сортировки. limit вне 1..MaxListLimit приводится к границам.
+func (us *Users) ListUsers(ctx context.Context, sort, cur string, limit int) (*Page, error) {
+	if err := auth.Require(ctx, auth.PermSearch); err != nil {
+		return nil, wrap("list users", err)
+	}
+	q, err := parseSort(sort)
+	if err != nil {
+		return nil, wrap("list users", Invalid(CodeInvalidSort, err,
+			FieldError{Field: "sort", Code: "unknown", Message: "sort must be one of id, name, -id, -name"}))
+	}
+	// курсор привязан к сортировке в полном виде, чтобы "" и "id" давали одни и те же курсоры
+	sort = q.Sort
+	if q.Desc {
+		sort = "-" + sort
+	}
+	if cur != "" {
+		if q.After, err = decodeCursor(sort, cur); err != nil {
+			return nil, wrap("list users", Invalid(CodeInvalidCursor, err,
+				FieldError{Field: "cursor", Code: "invalid", Message: "cursor is malformed or belongs to another sort"}))
+		}
+	}
+	switch {
+	case limit <= 0:
+		limit = DefaultListLimit
+	case limit > MaxListLimit:
+		limit = MaxListLimit
+	}
+	// просим на одного больше, чтобы узнать есть ли следующая страница
+	q.Limit = limit + 1
+	users, err := us.ustore.ListUsers(ctx, q)
+	if err != nil {
+		return nil, wrap("list users", err)
+	}
+	p := &Page{Users: users}
+	if len(users) > limit {
+		p.Users = users[:limit]
+		p.Next = encodeCursor(sort, KeyOf(users[limit-1]))
+	}
+	return p, nil
+}
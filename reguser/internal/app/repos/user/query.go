@@ -0,0 +1,422 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
+	"github.com/audetv/hex-ecample/reguser/internal/libs/fuzzy"
+	"github.com/google/uuid"
+)
+
+// Язык запросов поиска:
+//
+//	ivan                       имя содержит ivan, как раньше
+//	name:ivan*                 имя начинается с ivan
+//	data:"moscow office"       фраза в кавычках, можно с пробелами
+//	permissions:0              маска прав равна числу
+//	permissions:admin          есть право admin
+//	id:<uuid>                  конкретный пользователь
+//	a AND b, a b               оба условия, AND можно не писать
+//	a OR b                     хотя бы одно
+//	NOT a, -a                  отрицание
+//	( ... )                    группировка
+//
+// Приоритет: NOT, потом AND, потом OR. Ключевые слова только заглавными, строчные and/or это просто слова.
+// Сравнение текста с учетом регистра, как strings.Contains. Пустой запрос находит всех.
+// Режимы поиска fold, prefix и fuzzy и нормализацию текста см. ApplyMode.
+
+// Поля запроса
+const (
+	FieldID          = "id"
+	FieldName        = "name"
+	FieldData        = "data"
+	FieldPermissions = "permissions"
+)
+
+// Op операция терма
+type Op int
+
+const (
+	// OpContains текстовое поле содержит Value
+	OpContains Op = iota
+	// OpPrefix текстовое поле начинается с Value
+	OpPrefix
+	// OpEquals id равен Value, или маска прав равна Mask
+	OpEquals
+	// OpHas в маске прав есть все биты Mask
+	OpHas
+	// OpWordPrefix какое-то слово текстового поля начинается с Value, сравнение нормализованное
+	OpWordPrefix
+	// OpFuzzy текстовое поле похоже на Value с учетом опечаток, см. fuzzy.Similarity
+	OpFuzzy
+)
+
+// ErrInvalidQuery синтаксическая ошибка или неизвестное поле в запросе
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Query узел дерева запроса. Хранилища, которые умеют, переводят дерево в свои условия,
+// остальные проверяют каждого пользователя через Match.
+type Query interface {
+	Match(u User) bool
+}
+
+// All пустой запрос, подходит любой пользователь
+type All struct{}
+
+// And все условия, Or хотя бы одно
+type (
+	And []Query
+	Or  []Query
+)
+
+// Not отрицание
+type Not struct {
+	Q Query
+}
+
+// Term условие на одно поле. Для permissions значение уже разобрано в Mask,
+// для id Value это uuid в каноническом виде.
+// Fold текст сравнивается после fuzzy.Normalize без учета регистра и диакритики, Value тогда уже нормализовано.
+// OpWordPrefix и OpFuzzy всегда сравнивают нормализованный текст.
+type Term struct {
+	Field string
+	Op    Op
+	Value string
+	Mask  int
+	Fold  bool
+}
+
+// NameContains запрос прежнего поиска по подстроке имени
+func NameContains(s string) Query {
+	if s == "" {
+		return All{}
+	}
+	return Term{Field: FieldName, Op: OpContains, Value: s}
+}
+
+func (All) Match(u User) bool { return true }
+
+func (q And) Match(u User) bool {
+	for _, c := range q {
+		if !c.Match(u) {
+			return false
+		}
+	}
+	return true
+}
+
+func (q Or) Match(u User) bool {
+	for _, c := range q {
+		if c.Match(u) {
+			return true
+		}
+	}
+	return false
+}
+
+func (q Not) Match(u User) bool { return !q.Q.Match(u) }
+
+func (t Term) Match(u User) bool {
+	switch t.Field {
+	case FieldID:
+		return u.ID.String() == t.Value
+	case FieldPermissions:
+		if t.Op == OpHas {
+			return u.Permissions&t.Mask == t.Mask
+		}
+		return u.Permissions == t.Mask
+	}
+	s := t.text(u)
+	switch t.Op {
+	case OpPrefix:
+		return strings.HasPrefix(s, t.Value)
+	case OpWordPrefix:
+		return hasWordPrefix(s, t.Value)
+	case OpFuzzy:
+		return fuzzy.Similarity(t.Value, s) > 0
+	}
+	return strings.Contains(s, t.Value)
+}
+
+// text значение текстового поля в том виде, в котором его сравнивает терм
+func (t Term) text(u User) string {
+	s := u.Name
+	if t.Field == FieldData {
+		s = u.Data
+	}
+	if t.Fold || t.Op == OpWordPrefix || t.Op == OpFuzzy {
+		s = fuzzy.Normalize(s)
+	}
+	return s
+}
+
+// hasWordPrefix v стоит в s с начала какого-то слова
+func hasWordPrefix(s, v string) bool {
+	wordStart := true
+	for i, r := range s {
+		if wordStart && strings.HasPrefix(s[i:], v) {
+			return true
+		}
+		wordStart = !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}
+	return v == ""
+}
+
+// token лексема: скобка, минус, ключевое слово или терм field:value
+type token struct {
+	kind   tokenKind
+	pos    int
+	field  string
+	value  string
+	prefix bool
+}
+
+type tokenKind int
+
+const (
+	tokTerm tokenKind = iota
+	tokLParen
+	tokRParen
+	tokNot
+	tokAnd
+	tokOr
+)
+
+func isWordRune(r rune) bool {
+	return !unicode.IsSpace(r) && r != '(' && r != ')' && r != '"'
+}
+
+// lex разбивает запрос на лексемы. Минус в начале слова это отрицание, внутри слова обычный символ.
+func lex(s string) ([]token, error) {
+	rs := []rune(s)
+	var toks []token
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			toks = append(toks, token{kind: tokLParen, pos: i})
+			i++
+		case r == ')':
+			toks = append(toks, token{kind: tokRParen, pos: i})
+			i++
+		case r == '-' && i+1 < len(rs) && (isWordRune(rs[i+1]) || rs[i+1] == '"' || rs[i+1] == '('):
+			toks = append(toks, token{kind: tokNot, pos: i})
+			i++
+		default:
+			t, n, err := lexTerm(rs[i:], i)
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, t)
+			i += n
+		}
+	}
+	return toks, nil
+}
+
+// lexTerm читает терм: слово, "фразу", field:слово или field:"фразу", звездочка в конце слова это префикс
+func lexTerm(rs []rune, pos int) (token, int, error) {
+	t := token{kind: tokTerm, pos: pos}
+	i := 0
+	for i < len(rs) && isWordRune(rs[i]) && rs[i] != ':' {
+		i++
+	}
+	word := string(rs[:i])
+	if i < len(rs) && rs[i] == ':' && i > 0 {
+		t.field = word
+		i++
+	} else if i > 0 {
+		return finishWord(t, word), i, nil
+	}
+
+	if i < len(rs) && rs[i] == '"' {
+		j := i + 1
+		var b strings.Builder
+		for ; j < len(rs) && rs[j] != '"'; j++ {
+			if rs[j] == '\\' && j+1 < len(rs) {
+				j++
+			}
+			b.WriteRune(rs[j])
+		}
+		if j == len(rs) {
+			return t, 0, fmt.Errorf("%w: unterminated quote at %d", ErrInvalidQuery, pos+i)
+		}
+		t.value = b.String()
+		return t, j + 1, nil
+	}
+	start := i
+	for i < len(rs) && isWordRune(rs[i]) {
+		i++
+	}
+	if start == i {
+		return t, 0, fmt.Errorf("%w: empty value for %s at %d", ErrInvalidQuery, t.field, pos)
+	}
+	return finishWord(t, string(rs[start:i])), i, nil
+}
+
+func finishWord(t token, w string) token {
+	switch {
+	case t.field == "" && w == "AND":
+		t.kind = tokAnd
+	case t.field == "" && w == "OR":
+		t.kind = tokOr
+	case t.field == "" && w == "NOT":
+		t.kind = tokNot
+	case len(w) > 1 && strings.HasSuffix(w, "*"):
+		t.value = strings.TrimSuffix(w, "*")
+		t.prefix = true
+	default:
+		t.value = w
+	}
+	return t
+}
+
+type parser struct {
+	toks []token
+	i    int
+}
+
+func (p *parser) peek() *token {
+	if p.i < len(p.toks) {
+		return &p.toks[p.i]
+	}
+	return nil
+}
+
+// ParseQuery разбирает строку запроса в дерево. Поля и значения проверяются сразу,
+// поэтому хранилищу приходит только корректный запрос.
+func ParseQuery(s string) (Query, error) {
+	toks, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return All{}, nil
+	}
+	p := &parser{toks: toks}
+	q, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t != nil {
+		return nil, fmt.Errorf("%w: unexpected token at %d", ErrInvalidQuery, t.pos)
+	}
+	return q, nil
+}
+
+func (p *parser) or() (Query, error) {
+	q, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	or := Or{q}
+	for t := p.peek(); t != nil && t.kind == tokOr; t = p.peek() {
+		p.i++
+		q, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		or = append(or, q)
+	}
+	if len(or) == 1 {
+		return or[0], nil
+	}
+	return or, nil
+}
+
+func (p *parser) and() (Query, error) {
+	q, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	and := And{q}
+	for t := p.peek(); t != nil && t.kind != tokOr && t.kind != tokRParen; t = p.peek() {
+		if t.kind == tokAnd {
+			p.i++
+		}
+		q, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, q)
+	}
+	if len(and) == 1 {
+		return and[0], nil
+	}
+	return and, nil
+}
+
+func (p *parser) unary() (Query, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, fmt.Errorf("%w: unexpected end", ErrInvalidQuery)
+	}
+	switch t.kind {
+	case tokNot:
+		p.i++
+		q, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{Q: q}, nil
+	case tokLParen:
+		p.i++
+		q, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if c := p.peek(); c == nil || c.kind != tokRParen {
+			return nil, fmt.Errorf("%w: missing ) for ( at %d", ErrInvalidQuery, t.pos)
+		}
+		p.i++
+		return q, nil
+	case tokTerm:
+		p.i++
+		return term(t)
+	}
+	return nil, fmt.Errorf("%w: unexpected token at %d", ErrInvalidQuery, t.pos)
+}
+
+// term проверяет поле и значение и переводит их в условие
+func term(t *token) (Query, error) {
+	field := t.field
+	if field == "" {
+		field = FieldName
+	}
+	bad := func(why string) error {
+		return fmt.Errorf("%w: %s at %d", ErrInvalidQuery, why, t.pos)
+	}
+	switch field {
+	case FieldName, FieldData:
+		op := OpContains
+		if t.prefix {
+			op = OpPrefix
+		}
+		return Term{Field: field, Op: op, Value: t.value}, nil
+	case FieldID:
+		uid, err := uuid.Parse(t.value)
+		if err != nil || t.prefix {
+			return nil, bad("id must be a uuid")
+		}
+		return Term{Field: field, Op: OpEquals, Value: uid.String()}, nil
+	case FieldPermissions:
+		if t.prefix {
+			return nil, bad("prefix is not supported for permissions")
+		}
+		if n, err := strconv.Atoi(t.value); err == nil {
+			return Term{Field: field, Op: OpEquals, Value: t.value, Mask: n}, nil
+		}
+		mask, err := auth.ParsePermissions(strings.Split(t.value, ","))
+		if err != nil {
+			return nil, bad(err.Error())
+		}
+		return Term{Field: field, Op: OpHas, Value: t.value, Mask: mask}, nil
+	}
+	return nil, bad(fmt.Sprintf("unknown field %q", field))
+}
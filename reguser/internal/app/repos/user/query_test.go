@@ -0,0 +1,113 @@
+package user
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
+)
+
+func TestParseQuery(t *testing.T) {
+	name := func(v string) Term { return Term{Field: FieldName, Op: OpContains, Value: v} }
+	cases := []struct {
+		s    string
+		want Query
+	}{
+		{"", All{}},
+		{"  ", All{}},
+		{"ivan", name("ivan")},
+		{"anna-maria", name("anna-maria")},
+		{"name:ivan*", Term{Field: FieldName, Op: OpPrefix, Value: "ivan"}},
+		{`data:"moscow office"`, Term{Field: FieldData, Op: OpContains, Value: "moscow office"}},
+		{`"say \"hi\""`, name(`say "hi"`)},
+		{`"12:30"`, name("12:30")},
+		{"a b", And{name("a"), name("b")}},
+		{"a AND b OR c", Or{And{name("a"), name("b")}, name("c")}},
+		{"a OR b c", Or{name("a"), And{name("b"), name("c")}}},
+		{"a (b OR c)", And{name("a"), Or{name("b"), name("c")}}},
+		{"-a NOT b", And{Not{name("a")}, Not{name("b")}}},
+		{"--a", Not{Not{name("a")}}},
+		{"a and b", And{name("a"), name("and"), name("b")}},
+		{"permissions:0", Term{Field: FieldPermissions, Op: OpEquals, Value: "0", Mask: 0}},
+		{"permissions:read,admin", Term{Field: FieldPermissions, Op: OpHas, Value: "read,admin", Mask: auth.PermRead | auth.PermAdmin}},
+		{"id:9A1C7B8E-2D2F-4C3E-8A8D-0C6F6F1F5A11", Term{Field: FieldID, Op: OpEquals, Value: "9a1c7b8e-2d2f-4c3e-8a8d-0c6f6f1f5a11"}},
+	}
+	for _, tt := range cases {
+		got, err := ParseQuery(tt.s)
+		if err != nil {
+			t.Errorf("%q: %v", tt.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %#v, want %#v", tt.s, got, tt.want)
+		}
+	}
+
+	for _, s := range []string{
+		"(a", "a)", "a AND", "OR a", "NOT", `"open`, "name:", "phone:1",
+		"id:42", "permissions:root", "permissions:1*", "a ()",
+	} {
+		if _, err := ParseQuery(s); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("%q: error %v", s, err)
+		}
+	}
+}
+
+func TestApplyMode(t *testing.T) {
+	q, err := ParseQuery(`Иван name:Pet* data:x permissions:read`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]Query{
+		ModeExact: q,
+		ModeFold: And{
+			Term{Field: FieldName, Op: OpContains, Value: "иван", Fold: true},
+			Term{Field: FieldName, Op: OpPrefix, Value: "pet", Fold: true},
+			Term{Field: FieldData, Op: OpContains, Value: "x", Fold: true},
+			Term{Field: FieldPermissions, Op: OpHas, Value: "read", Mask: auth.PermRead},
+		},
+		ModeFuzzy: And{
+			Term{Field: FieldName, Op: OpFuzzy, Value: "иван", Fold: true},
+			Term{Field: FieldName, Op: OpPrefix, Value: "pet", Fold: true},
+			Term{Field: FieldData, Op: OpFuzzy, Value: "x", Fold: true},
+			Term{Field: FieldPermissions, Op: OpHas, Value: "read", Mask: auth.PermRead},
+		},
+	}
+	for mode, want := range cases {
+		got, err := ApplyMode(q, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %#v", mode, got)
+		}
+	}
+	if _, err := ApplyMode(q, "regex"); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("unknown mode: %v", err)
+	}
+}
+
+func TestRanking(t *testing.T) {
+	q, _ := ParseQuery("ivan")
+	q, _ = ApplyMode(q, ModeFuzzy)
+	rank := func(limit int) []string {
+		r := &ranking{limit: limit}
+		for _, n := range []string{"sidorivan", "Ivanov", "ivna", "IVAN", "Petr Ivan"} {
+			u := User{Name: n}
+			r.add(ScoredUser{User: u, Score: Score(q, u)})
+		}
+		var got []string
+		for _, su := range r.sorted() {
+			got = append(got, su.Name)
+		}
+		return got
+	}
+	want := []string{"IVAN", "Ivanov", "Petr Ivan", "sidorivan", "ivna"}
+	if got := rank(10); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := rank(2); !reflect.DeepEqual(got, want[:2]) {
+		t.Errorf("limit 2: got %v, want %v", got, want[:2])
+	}
+}
@@ -0,0 +1,177 @@
+package user
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+
+	"github.com/audetv/hex-ecample/reguser/internal/libs/fuzzy"
+)
+
+// Режимы сравнения текстовых условий поиска
+const (
+	// ModeExact подстрока с учетом регистра, как strings.Contains, name:ivan* начало поля
+	ModeExact = "exact"
+	// ModeFold как ModeExact, но без учета регистра и диакритики
+	ModeFold = "fold"
+	// ModePrefix начало любого слова без учета регистра и диакритики
+	ModePrefix = "prefix"
+	// ModeFuzzy похожие слова с опечатками
+	ModeFuzzy = "fuzzy"
+)
+
+// MaxRanked сколько лучших результатов отдают режимы с ранжированием. Чтобы упорядочить выдачу
+// по релевантности, отобранных надо держать в памяти, поэтому держим не больше MaxRanked, остальные отбрасываем.
+const MaxRanked = 1000
+
+// Ranked в режиме mode выдача упорядочена по релевантности, в остальных идет в порядке хранилища
+func Ranked(mode string) bool {
+	return mode == ModePrefix || mode == ModeFuzzy
+}
+
+// ScoredUser найденный пользователь и релевантность от 0 до 1.
+// Если Err не nil, поиск оборвался, это последний элемент канала и пользователя в нем нет.
+type ScoredUser struct {
+	User
+	Score float64
+	Err   error
+}
+
+// ApplyMode переписывает текстовые условия запроса под режим mode, пустой режим это ModeExact,
+// в нем запрос не меняется. Значения условий нормализуются здесь один раз, а не на каждом сравнении.
+func ApplyMode(q Query, mode string) (Query, error) {
+	switch mode {
+	case "", ModeExact:
+		return q, nil
+	case ModeFold, ModePrefix, ModeFuzzy:
+	default:
+		return nil, fmt.Errorf("%w: unknown mode %q", ErrInvalidQuery, mode)
+	}
+	return applyMode(q, mode), nil
+}
+
+func applyMode(q Query, mode string) Query {
+	switch q := q.(type) {
+	case And:
+		res := make(And, len(q))
+		for i, c := range q {
+			res[i] = applyMode(c, mode)
+		}
+		return res
+	case Or:
+		res := make(Or, len(q))
+		for i, c := range q {
+			res[i] = applyMode(c, mode)
+		}
+		return res
+	case Not:
+		return Not{Q: applyMode(q.Q, mode)}
+	case Term:
+		if q.Field != FieldName && q.Field != FieldData {
+			return q
+		}
+		q.Value = fuzzy.Normalize(q.Value)
+		q.Fold = true
+		// явный префикс name:ivan* остается префиксом поля в любом режиме
+		if q.Op != OpContains {
+			return q
+		}
+		switch mode {
+		case ModePrefix:
+			q.Op = OpWordPrefix
+		case ModeFuzzy:
+			q.Op = OpFuzzy
+		}
+		return q
+	}
+	return q
+}
+
+// Score релевантность пользователя для запроса от 0 до 1. Считаются только текстовые условия:
+// для AND среднее, для OR лучшее из выполненных, отрицания не считаются.
+// Если текстовых условий нет, все найденные одинаково релевантны.
+func Score(q Query, u User) float64 {
+	if s, ok := score(q, u); ok {
+		return s
+	}
+	return 1
+}
+
+func score(q Query, u User) (float64, bool) {
+	switch q := q.(type) {
+	case Term:
+		if q.Field != FieldName && q.Field != FieldData {
+			return 0, false
+		}
+		return fuzzy.Similarity(q.Value, q.text(u)), true
+	case And:
+		sum, n := 0.0, 0
+		for _, c := range q {
+			if s, ok := score(c, u); ok {
+				sum += s
+				n++
+			}
+		}
+		if n == 0 {
+			return 0, false
+		}
+		return sum / float64(n), true
+	case Or:
+		best, found := 0.0, false
+		for _, c := range q {
+			if !c.Match(u) {
+				continue
+			}
+			if s, ok := score(c, u); ok && (!found || s > best) {
+				best, found = s, true
+			}
+		}
+		return best, found
+	}
+	return 0, false
+}
+
+// better a релевантнее b, при равной релевантности раньше идет меньший по имени и ID,
+// чтобы порядок не зависел от порядка выдачи хранилища
+func better(a, b ScoredUser) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	return CompareKeys(SortByName, KeyOf(a.User), KeyOf(b.User)) < 0
+}
+
+// ranking не больше limit лучших найденных. Это куча, в корне которой худший из отобранных,
+// поэтому новый найденный сравнивается только с ним и вытесняет его, если лучше.
+type ranking struct {
+	limit int
+	users []ScoredUser
+}
+
+func (r *ranking) Len() int           { return len(r.users) }
+func (r *ranking) Less(i, j int) bool { return better(r.users[j], r.users[i]) }
+func (r *ranking) Swap(i, j int)      { r.users[i], r.users[j] = r.users[j], r.users[i] }
+func (r *ranking) Push(x interface{}) { r.users = append(r.users, x.(ScoredUser)) }
+
+func (r *ranking) Pop() interface{} {
+	su := r.users[len(r.users)-1]
+	r.users = r.users[:len(r.users)-1]
+	return su
+}
+
+// add учитывает найденного пользователя
+func (r *ranking) add(su ScoredUser) {
+	if len(r.users) < r.limit {
+		heap.Push(r, su)
+		return
+	}
+	if r.limit > 0 && better(su, r.users[0]) {
+		r.users[0] = su
+		heap.Fix(r, 0)
+	}
+}
+
+// sorted отобранные по убыванию релевантности
+func (r *ranking) sorted() []ScoredUser {
+	sort.Slice(r.users, func(i, j int) bool { return better(r.users[i], r.users[j]) })
+	return r.users
+}
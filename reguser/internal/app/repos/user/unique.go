@@ -0,0 +1,50 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NamePolicy правило уникальности имен пользователей, его соблюдает хранилище атомарно с изменением карточки
+type NamePolicy string
+
+const (
+	// NamesAny имена не обязаны быть уникальными
+	NamesAny NamePolicy = "any"
+	// NamesExact одинаковыми считаются только совпадающие побайтно имена
+	NamesExact NamePolicy = "exact"
+	// NamesFold одинаковыми считаются имена, совпадающие без учета регистра после NFKC нормализации
+	// и схлопывания пробелов: "Ivan  Petrov" и "ivan petrov" это одно имя.
+	NamesFold NamePolicy = "fold"
+)
+
+// ErrNameTaken имя уже занято другим пользователем, хранилища возвращают ее как sql.ErrNoRows для ненайденного
+var ErrNameTaken = errors.New("name already taken")
+
+var nameFolder = cases.Fold()
+
+// ParseNamePolicy политика по имени из конфигурации, пустая строка это NamesAny
+func ParseNamePolicy(s string) (NamePolicy, error) {
+	switch p := NamePolicy(s); p {
+	case "":
+		return NamesAny, nil
+	case NamesAny, NamesExact, NamesFold:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown name policy %q, want %s, %s or %s", s, NamesAny, NamesExact, NamesFold)
+}
+
+// Key ключ имени для индекса уникальности, ok=false если политика имена не ограничивает
+func (p NamePolicy) Key(name string) (string, bool) {
+	switch p {
+	case NamesExact:
+		return name, true
+	case NamesFold:
+		return nameFolder.String(strings.Join(strings.Fields(norm.NFKC.String(name)), " ")), true
+	}
+	return "", false
+}
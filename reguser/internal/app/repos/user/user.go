@@ -2,33 +2,101 @@ package user
 
 import (
 	"context"
-	"fmt"
+	"errors"
 
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
 	"github.com/google/uuid"
 )
 
+// User карточка пользователя, Permissions маска прав auth.Perm*, хранится вместе с карточкой.
+// Version номер версии карточки, хранилище увеличивает его на единицу при каждом изменении.
 type User struct {
 	ID          uuid.UUID
 	Name        string
 	Data        string
 	Permissions int
+	Version     int64
+}
+
+// ErrVersionMismatch карточка изменилась с тех пор, как ее прочитали: версия в хранилище не та, что ожидалась
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// Found элемент потока результатов поиска из хранилища. Если Err не nil, это последний элемент:
+// хранилище не смогло дочитать результат, и пришедшие до него пользователи это неполная выдача.
+type Found struct {
+	User
+	Err error
+}
+
+// UserPatch частичное изменение карточки, nil поле означает что поле не меняется.
+// Version ожидаемая версия карточки, 0 меняет карточку без проверки версии.
+type UserPatch struct {
+	Name    *string
+	Data    *string
+	Version int64
+}
+
+// Apply применяет изменения к карточке пользователя
+func (p UserPatch) Apply(u *User) {
+	if p.Name != nil {
+		u.Name = *p.Name
+	}
+	if p.Data != nil {
+		u.Data = *p.Data
+	}
 }
 
 // UserStore интерфейс системы хранения.
 // Create возвращает указатель на uuid, чтобы не передавать пустой uuid в случае ошибки.
 // Read возвращает указатель на User, чтобы не передавать пустого User в случае ошибки.
 // Delete из системы хранения нам не надо возвращать самого юзера, т.к мы его прочитали в бизнес логике.
+// Create сохраняет u.Version, 0 означает первую версию 1.
+// Update полностью заменяет карточку, Patch меняет только заданные поля, оба возвращают sql.ErrNoRows,
+// если пользователя нет. Patch выполняется атомарно внутри стора и возвращает получившуюся карточку.
+// Оба работают как compare-and-swap: если u.Version или p.Version не 0 и не совпадает с версией в хранилище,
+// ничего не меняют и возвращают ErrVersionMismatch. После изменения версия на единицу больше прежней.
+// SearchUsers получает уже разобранный и проверенный запрос, см. query.go. Ошибка до начала выдачи
+// возвращается сразу, ошибка на середине приходит последним элементом канала в Found.Err.
+// ListUsers возвращает страницу списка по ListQuery, пустой срез если дальше никого нет.
 type UserStore interface {
 	Create(ctx context.Context, u User) (*uuid.UUID, error)
 	Read(ctx context.Context, uid uuid.UUID) (*User, error)
+	Update(ctx context.Context, u User) error
+	Patch(ctx context.Context, uid uuid.UUID, p UserPatch) (*User, error)
 	Delete(ctx context.Context, uid uuid.UUID) error
-	SearchUsers(ctx context.Context, s string) (chan User, error)
+	SearchUsers(ctx context.Context, q Query) (chan Found, error)
+	ListUsers(ctx context.Context, q ListQuery) ([]User, error)
+	Begin(ctx context.Context) (UserTx, error)
+}
+
+// NamePolicyStore хранилище, которое само соблюдает уникальность имен, см. NamePolicy.
+// SetNamePolicy включает политику p для сохраненных и новых карточек, после этого Create, Update и Patch
+// с занятым именем возвращают ErrNameTaken. Если сохраненные имена по p уже совпадают,
+// возвращает ошибку с ErrNameTaken и оставляет прежнюю политику.
+type NamePolicyStore interface {
+	SetNamePolicy(ctx context.Context, p NamePolicy) error
+}
+
+// UserTx транзакция системы хранения для паттерна Unit of Work: все операции внутри нее
+// либо применяются вместе по Commit, либо откатываются по Rollback.
+// Rollback после Commit ничего не делает, поэтому его можно всегда вызывать в defer.
+// Повторный Commit возвращает sql.ErrTxDone.
+type UserTx interface {
+	Create(ctx context.Context, u User) (*uuid.UUID, error)
+	Read(ctx context.Context, uid uuid.UUID) (*User, error)
+	Update(ctx context.Context, u User) error
+	Patch(ctx context.Context, uid uuid.UUID, p UserPatch) (*User, error)
+	Delete(ctx context.Context, uid uuid.UUID) error
+	Commit() error
+	Rollback() error
 }
 
 // Users коллекция объектов User, для того чтобы реализовать паттерн репозиторий,
-// который работает с системой хранения, у него будут некоторые методы
+// который работает с системой хранения, у него будут некоторые методы.
+// Об успешных изменениях Users сам оповещает подписчиков Watch.
 type Users struct {
 	ustore UserStore
+	watch  watchers
 }
 
 // NewUsers функция инициализации, пробрасываем систему хранения в виде UserStore, будем возвращать Users,
@@ -41,70 +109,237 @@ func NewUsers(ustore UserStore) *Users {
 
 // Create чтобы не передавать пустого пользователя, вернем указатель на него.
 // Получать будем полноценную карточку в виде структуры.
+// Создать пользователя сразу с правами может только администратор.
+// Карточка проверяется и приводится к каноническому виду через Validate, как и в Update и Patch.
 func (us *Users) Create(ctx context.Context, u User) (*User, error) {
-	// FIXME: здесь нужно использовать паттерн Unit of Work
-	// бизнес-транзакция, нужно создать транзакцию либо внутри бизнес логики, например заложить сюда мьютекс отдельный
-	// и везде его использовать в бизнес логике, либо создать транзакцию на уровне базы данных и внутри нее выполнять операции
+	perm := auth.PermCreate
+	if u.Permissions != 0 {
+		perm |= auth.PermAdmin
+	}
+	if err := auth.Require(ctx, perm); err != nil {
+		return nil, wrap("create user", err)
+	}
+	if err := Validate(&u); err != nil {
+		return nil, wrap("create user", err)
+	}
 	u.ID = uuid.New()
-	id, err := us.ustore.Create(ctx, u)
+	u.Version = 1
+	err := us.inTx(ctx, func(tx UserTx) error {
+		id, err := tx.Create(ctx, u)
+		if err != nil {
+			return err
+		}
+		u.ID = *id
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("create user error: %w", err)
+		return nil, wrap("create user", err)
 	}
-	u.ID = *id
+	us.watch.publish(EventCreated, u)
 	return &u, nil
 }
 
+// inTx выполняет бизнес-транзакцию (Unit of Work): если f вернула ошибку, все изменения откатываются,
+// иначе фиксируются одним Commit.
+func (us *Users) inTx(ctx context.Context, f func(tx UserTx) error) error {
+	tx, err := us.ustore.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := f(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Read одиночное чтение, транзакция ему не нужна
 func (us *Users) Read(ctx context.Context, uid uuid.UUID) (*User, error) {
-	// FIXME: здесь нужно использовать паттерн Unit of Work
-	// бизнес-транзакция
+	if err := auth.Require(ctx, auth.PermRead); err != nil {
+		return nil, wrap("read user", err)
+	}
 	u, err := us.ustore.Read(ctx, uid)
 	if err != nil {
-		return nil, fmt.Errorf("read user error: %w", err)
+		return nil, wrap("read user", err)
 	}
 	return u, nil
 }
 
-func (us *Users) Delete(ctx context.Context, uid uuid.UUID) (*User, error) {
-	// FIXME: здесь нужно использовать паттерн Unit of Work
-	// бизнес-транзакция
-	u, err := us.ustore.Read(ctx, uid)
+// Update полностью заменяет имя и данные пользователя с u.ID, права остаются прежними,
+// их меняет только SetPermissions. Если u.Version не 0, карточка меняется только в этой версии,
+// иначе ErrVersionMismatch. Возвращает карточку с новой версией.
+func (us *Users) Update(ctx context.Context, u User) (*User, error) {
+	if err := auth.Require(ctx, auth.PermUpdate); err != nil {
+		return nil, wrap("update user", err)
+	}
+	if err := Validate(&u); err != nil {
+		return nil, wrap("update user", err)
+	}
+	err := us.inTx(ctx, func(tx UserTx) error {
+		old, err := tx.Read(ctx, u.ID)
+		if err != nil {
+			return err
+		}
+		// без ожидаемой версии меняем ту, что прочитали, проверка в хранилище тогда всегда проходит
+		if u.Version == 0 {
+			u.Version = old.Version
+		}
+		u.Permissions = old.Permissions
+		if err := tx.Update(ctx, u); err != nil {
+			return err
+		}
+		u.Version++
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("search user err: %w", err)
+		return nil, wrap("update user", err)
 	}
+	us.watch.publish(EventUpdated, u)
+	return &u, nil
+}
 
-	// Чтобы вызвать delete, мы можем просто вызвать ошибку полученную из UserStore
-	return u, us.ustore.Delete(ctx, uid)
+// Patch меняет только заданные в p поля и возвращает обновленную карточку, p.Version как u.Version в Update
+func (us *Users) Patch(ctx context.Context, uid uuid.UUID, p UserPatch) (*User, error) {
+	if err := auth.Require(ctx, auth.PermUpdate); err != nil {
+		return nil, wrap("patch user", err)
+	}
+	if err := ValidatePatch(&p); err != nil {
+		return nil, wrap("patch user", err)
+	}
+	var u *User
+	err := us.inTx(ctx, func(tx UserTx) (err error) {
+		u, err = tx.Patch(ctx, uid, p)
+		return err
+	})
+	if err != nil {
+		return nil, wrap("patch user", err)
+	}
+	us.watch.publish(EventUpdated, *u)
+	return u, nil
+}
+
+// Delete читает и удаляет пользователя в одной транзакции, чтобы между чтением и удалением
+// никто не успел изменить или удалить карточку.
+// version ожидаемая версия карточки, 0 удаляет без проверки.
+func (us *Users) Delete(ctx context.Context, uid uuid.UUID, version int64) (*User, error) {
+	if err := auth.Require(ctx, auth.PermDelete); err != nil {
+		return nil, wrap("delete user", err)
+	}
+	var u *User
+	err := us.inTx(ctx, func(tx UserTx) error {
+		var err error
+		u, err = tx.Read(ctx, uid)
+		if err != nil {
+			return err
+		}
+		// Транзакция держит карточку до Commit во всех хранилищах, поэтому проверка версии и удаление атомарны
+		if version != 0 && u.Version != version {
+			return ErrVersionMismatch
+		}
+		// Чтобы вызвать delete, мы можем просто вызвать ошибку полученную из UserStore
+		return tx.Delete(ctx, uid)
+	})
+	if err != nil {
+		return nil, wrap("delete user", err)
+	}
+	us.watch.publish(EventDeleted, *u)
+	return u, nil
 }
 
-// SearchUsers устанавливаем для примера permissions для юзера, на уровне бизнес логики,
-// система хранения ничего об этом не знает. Берем пользователя из входящего канала,
-// устанавливаем permissions и передаем в исходящий канал
-// вычитываем пользователей в бесконечном цикле
-func (us *Users) SearchUsers(ctx context.Context, s string) (chan User, error) {
-	// FIXME: здесь нужно использовать паттерн Unit of Work
-	// бизнес-транзакция
-	chin, err := us.ustore.SearchUsers(ctx, s)
+// SetPermissions назначает пользователю права, доступно только администратору.
+// version ожидаемая версия карточки, 0 без проверки.
+func (us *Users) SetPermissions(ctx context.Context, uid uuid.UUID, perms int, version int64) (*User, error) {
+	if err := auth.Require(ctx, auth.PermAdmin); err != nil {
+		return nil, wrap("set permissions", err)
+	}
+	var u *User
+	err := us.inTx(ctx, func(tx UserTx) error {
+		var err error
+		u, err = tx.Read(ctx, uid)
+		if err != nil {
+			return err
+		}
+		if version != 0 && u.Version != version {
+			return ErrVersionMismatch
+		}
+		u.Permissions = perms
+		if err := tx.Update(ctx, *u); err != nil {
+			return err
+		}
+		u.Version++
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		return nil, wrap("set permissions", err)
+	}
+	us.watch.publish(EventUpdated, *u)
+	return u, nil
+}
+
+// parseSearch разбирает запрос и режим, ошибка указывает, какой из двух параметров неверен
+func parseSearch(s, mode string) (Query, error) {
+	q, err := ParseQuery(s)
+	if err != nil {
+		return nil, Invalid(CodeInvalidQuery, err, FieldError{Field: "query", Code: "syntax", Message: err.Error()})
+	}
+	if q, err = ApplyMode(q, mode); err != nil {
+		return nil, Invalid(CodeInvalidQuery, err, FieldError{Field: "mode", Code: "unknown", Message: err.Error()})
+	}
+	return q, nil
+}
+
+// SearchUsers разбирает запрос s (синтаксис в query.go) и применяет режим сравнения mode (rank.go),
+// ошибки разбора и неизвестный режим оборачивают ErrInvalidQuery.
+// В режимах без ранжирования найденные уходят в исходящий канал сразу, как их отдал стор, с релевантностью 1.
+// В режимах с ранжированием (Ranked) выдача упорядочена по релевантности, поэтому сначала вычитываем из стора
+// всех найденных, держа в памяти не больше MaxRanked лучших, а потом отдаем их по порядку.
+// Ошибка стора уходит в канал сразу, как пришла, последним элементом в ScoredUser.Err.
+// При ранжировании до нее никто не отдается, ранжировать неполный результат нельзя.
+func (us *Users) SearchUsers(ctx context.Context, s, mode string) (chan ScoredUser, error) {
+	if err := auth.Require(ctx, auth.PermSearch); err != nil {
+		return nil, wrap("search users", err)
+	}
+	q, err := parseSearch(s, mode)
+	if err != nil {
+		return nil, wrap("search users", err)
+	}
+	chin, err := us.ustore.SearchUsers(ctx, q)
+	if err != nil {
+		return nil, wrap("search users", err)
+	}
+	chout := make(chan ScoredUser, 100)
+	// Select - сидим и ждем, если ни один канал ничего не выдает просто ждем,
+	// нам не надо в цикле крутиться для этого.
+	send := func(su ScoredUser) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case chout <- su:
+			return true
+		}
 	}
-	chout := make(chan User, 100)
 	// На выходе из функции закрываем канал chout, поскольку мы пишем в этой горутине,
-	// то тут и закрываем. Закрытие канала chout будет зависеть от закрытия канала chin,
-	// соответственно должны проверить if !ok, то должны выйти
+	// то тут и закрываем. Закрытие канала chout будет зависеть от закрытия канала chin.
 	go func() {
 		defer close(chout)
-		for {
-			// Select - сидим и ждем, если ни один канал ничего не выдает просто ждем,
-			// нам не надо в цикле крутиться для этого.
-			select {
-			case <-ctx.Done():
+		r := &ranking{limit: MaxRanked}
+		for f := range chin {
+			if f.Err != nil {
+				send(ScoredUser{Err: wrap("search users", f.Err)})
 				return
-			case u, ok := <-chin:
-				if !ok {
+			}
+			if !Ranked(mode) {
+				if !send(ScoredUser{User: f.User, Score: 1}) {
 					return
 				}
-				u.Permissions = 0755
-				chout <- u
+				continue
+			}
+			r.add(ScoredUser{User: f.User, Score: Score(q, f.User)})
+		}
+		for _, su := range r.sorted() {
+			if !send(su) {
+				return
 			}
 		}
 	}()
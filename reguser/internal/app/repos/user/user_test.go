@@ -0,0 +1,86 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
+	"github.com/google/uuid"
+)
+
+// streamStore отдает одного пользователя, ждет release и обрывает выдачу ошибкой errDisk
+type streamStore struct {
+	UserStore
+	release chan struct{}
+}
+
+var errDisk = errors.New("disk on fire")
+
+func (s streamStore) SearchUsers(ctx context.Context, q Query) (chan Found, error) {
+	ch := make(chan Found)
+	go func() {
+		defer close(ch)
+		ch <- Found{User: User{ID: uuid.New(), Name: "ivan"}}
+		<-s.release
+		ch <- Found{Err: errDisk}
+	}()
+	return ch, nil
+}
+
+// TestUsers_SearchUsersStream без ранжирования найденные и ошибка стора доходят сразу, как их отдал стор,
+// с ранжированием до ошибки не отдается никто
+func TestUsers_SearchUsersStream(t *testing.T) {
+	ctx := auth.WithPrincipal(context.Background(), &auth.Principal{Permissions: auth.PermSearch})
+	for _, mode := range []string{ModeExact, ModeFold, ModePrefix, ModeFuzzy} {
+		st := streamStore{release: make(chan struct{})}
+		ch, err := NewUsers(st).SearchUsers(ctx, "ivan", mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !Ranked(mode) {
+			// стор еще не отдал ошибку, а пользователь уже пришел
+			if su := <-ch; su.Err != nil || su.Name != "ivan" || su.Score != 1 {
+				t.Errorf("%s: first %+v", mode, su)
+			}
+		}
+		close(st.release)
+		su, ok := <-ch
+		if !ok || !errors.Is(su.Err, errDisk) {
+			t.Errorf("%s: want store error, got %+v", mode, su)
+		}
+		if _, ok := <-ch; ok {
+			t.Errorf("%s: channel is not closed after error", mode)
+		}
+	}
+}
+
+// readStore находит любую карточку
+type readStore struct {
+	UserStore
+}
+
+func (readStore) Read(ctx context.Context, uid uuid.UUID) (*User, error) {
+	return &User{ID: uid, Name: "ivan"}, nil
+}
+
+// TestUsers_RequirePrincipal без вызывающего в контексте бизнес логика отказывает, внутренние вызовы идут от System
+func TestUsers_RequirePrincipal(t *testing.T) {
+	us := NewUsers(readStore{})
+	cases := []struct {
+		name string
+		ctx  context.Context
+		err  error
+	}{
+		{"no principal", context.Background(), ErrForbidden},
+		{"no permission", auth.WithPrincipal(context.Background(), &auth.Principal{Permissions: auth.PermSearch}), ErrForbidden},
+		{"reader", auth.WithPrincipal(context.Background(), &auth.Principal{Permissions: auth.PermRead}), nil},
+		{"system", auth.WithSystem(context.Background()), nil},
+	}
+	for _, c := range cases {
+		_, err := us.Read(c.ctx, uuid.New())
+		if c.err == nil && err != nil || c.err != nil && !errors.Is(err, c.err) {
+			t.Errorf("%s: %v, want %v", c.name, err, c.err)
+		}
+	}
+}
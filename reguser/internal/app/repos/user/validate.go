@@ -0,0 +1,92 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Ограничения полей карточки, длина в символах
+const (
+	MaxNameLen = 64
+	MaxDataLen = 4096
+)
+
+// ErrValidation карточка не прошла проверку, подробности в Error.Fields
+var ErrValidation = errors.New("invalid user")
+
+// nameRune допустимые символы имени: буквы любого алфавита с диакритикой, цифры, пробел и - ' . _
+func nameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsDigit(r) || strings.ContainsRune(" -'._", r)
+}
+
+// dataRune в Data допустимо все, кроме управляющих символов, переводы строк и табуляция разрешены
+func dataRune(r rune) bool {
+	return !unicode.IsControl(r) || r == '\n' || r == '\r' || r == '\t'
+}
+
+// checkName обрезает пробелы по краям и проверяет имя, нарушение дописывает в fields
+func checkName(name *string, fields *[]FieldError) {
+	*name = strings.TrimSpace(*name)
+	switch n := utf8.RuneCountInString(*name); {
+	case !utf8.ValidString(*name):
+		*fields = append(*fields, FieldError{Field: "name", Code: "invalid_utf8", Message: "name must be valid UTF-8"})
+	case n == 0:
+		*fields = append(*fields, FieldError{Field: "name", Code: "required", Message: "name must not be empty"})
+	case n > MaxNameLen:
+		*fields = append(*fields, FieldError{Field: "name", Code: "too_long",
+			Message: fmt.Sprintf("name must be at most %d characters", MaxNameLen)})
+	case strings.IndexFunc(*name, func(r rune) bool { return !nameRune(r) }) >= 0:
+		*fields = append(*fields, FieldError{Field: "name", Code: "invalid_chars",
+			Message: "name may contain only letters, digits, spaces and - ' . _"})
+	}
+}
+
+// checkData обрезает пробелы по краям и проверяет данные, пустые данные допустимы
+func checkData(data *string, fields *[]FieldError) {
+	*data = strings.TrimSpace(*data)
+	switch {
+	case !utf8.ValidString(*data):
+		*fields = append(*fields, FieldError{Field: "data", Code: "invalid_utf8", Message: "data must be valid UTF-8"})
+	case utf8.RuneCountInString(*data) > MaxDataLen:
+		*fields = append(*fields, FieldError{Field: "data", Code: "too_long",
+			Message: fmt.Sprintf("data must be at most %d characters", MaxDataLen)})
+	case strings.IndexFunc(*data, func(r rune) bool { return !dataRune(r) }) >= 0:
+		*fields = append(*fields, FieldError{Field: "data", Code: "invalid_chars", Message: "data must not contain control characters"})
+	}
+}
+
+// validationError собирает нарушения в одну ошибку, nil если их нет
+func validationError(fields []FieldError) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return Invalid(CodeValidation, ErrValidation, fields...)
+}
+
+// Validate приводит карточку к каноническому виду (обрезает пробелы по краям) и проверяет ее.
+// Возвращает все нарушения сразу, по одному на поле, в Error.Fields с CodeValidation.
+func Validate(u *User) error {
+	var fields []FieldError
+	checkName(&u.Name, &fields)
+	checkData(&u.Data, &fields)
+	return validationError(fields)
+}
+
+// ValidatePatch как Validate, но проверяет только заданные в патче поля
+func ValidatePatch(p *UserPatch) error {
+	var fields []FieldError
+	if p.Name != nil {
+		name := *p.Name
+		checkName(&name, &fields)
+		p.Name = &name
+	}
+	if p.Data != nil {
+		data := *p.Data
+		checkData(&data, &fields)
+		p.Data = &data
+	}
+	return validationError(fields)
+}
@@ -0,0 +1,62 @@
+package user
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name, data string
+		codes      []string
+	}{
+		{"ivan", "", nil},
+		{"  Jean-Luc O'Neil Jr.  ", "line\nnext\ttab", nil},
+		{"Zoë", "", nil},
+		{"李小龙", "", nil},
+		{"", "", []string{"name:required"}},
+		{"   ", strings.Repeat("x", MaxDataLen+1), []string{"name:required", "data:too_long"}},
+		{strings.Repeat("я", MaxNameLen), strings.Repeat("я", MaxDataLen), nil},
+		{strings.Repeat("я", MaxNameLen+1), "", []string{"name:too_long"}},
+		{"ivan@mail", "bell\a", []string{"name:invalid_chars", "data:invalid_chars"}},
+		{"iv\xffan", "", []string{"name:invalid_utf8"}},
+	}
+	for _, c := range cases {
+		u := User{Name: c.name, Data: c.data}
+		err := Validate(&u)
+		var codes []string
+		e := &Error{}
+		if errors.As(err, &e) {
+			for _, f := range e.Fields {
+				codes = append(codes, f.Field+":"+f.Code)
+			}
+			if !errors.Is(err, ErrInvalid) || e.Code != CodeValidation {
+				t.Errorf("%q: error %#v", c.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("%q: unexpected %v", c.name, err)
+		}
+		if strings.Join(codes, ",") != strings.Join(c.codes, ",") {
+			t.Errorf("%q, %q: codes %v, want %v", c.name, c.data, codes, c.codes)
+		}
+		if err == nil && u.Name != strings.TrimSpace(c.name) {
+			t.Errorf("%q: name not trimmed: %q", c.name, u.Name)
+		}
+	}
+}
+
+func TestValidatePatch(t *testing.T) {
+	name, data := "  ivan ", ""
+	p := UserPatch{Name: &name, Data: &data}
+	if err := ValidatePatch(&p); err != nil || *p.Name != "ivan" || name != "  ivan " {
+		t.Errorf("patch %q, err %v, original %q", *p.Name, err, name)
+	}
+	if err := ValidatePatch(&UserPatch{Data: &data}); err != nil {
+		t.Errorf("name is not required in patch: %v", err)
+	}
+	empty := " "
+	if err := ValidatePatch(&UserPatch{Name: &empty}); !errors.Is(err, ErrValidation) {
+		t.Errorf("empty name: %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package user
+
+import (
+	"context"
+	"sync"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
+)
+
+// EventType вид изменения карточки
+type EventType string
+
+const (
+	EventCreated EventType = "created"
+	EventUpdated EventType = "updated"
+	EventDeleted EventType = "deleted"
+)
+
+// Event изменение карточки пользователя. Для EventDeleted User это карточка на момент удаления.
+type Event struct {
+	Type EventType
+	User User
+}
+
+// WatchBuffer сколько событий может ждать подписчика. Если подписчик не успевает их забирать,
+// подписка закрывается, чтобы медленный клиент не задерживал изменения остальных.
+const WatchBuffer = 64
+
+// watcher одна подписка, q фильтр по карточке после изменения
+type watcher struct {
+	q  Query
+	ch chan Event
+}
+
+// watchers подписчики на изменения. События рассылает Users после фиксации транзакции,
+// поэтому они приходят от любого хранилища и только об изменениях, которые действительно применились.
+type watchers struct {
+	mu   sync.Mutex
+	subs map[*watcher]struct{}
+}
+
+func (ws *watchers) add(w *watcher) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.subs == nil {
+		ws.subs = make(map[*watcher]struct{})
+	}
+	ws.subs[w] = struct{}{}
+}
+
+// remove снимает подписку и закрывает ее канал, если она еще не снята
+func (ws *watchers) remove(w *watcher) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if _, ok := ws.subs[w]; ok {
+		delete(ws.subs, w)
+		close(w.ch)
+	}
+}
+
+// publish рассылает событие подходящим подписчикам, не блокируясь на медленных
+func (ws *watchers) publish(typ EventType, u User) {
+	ev := Event{Type: typ, User: u}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for w := range ws.subs {
+		if !w.q.Match(u) {
+			continue
+		}
+		select {
+		case w.ch <- ev:
+		default:
+			delete(ws.subs, w)
+			close(w.ch)
+		}
+	}
+}
+
+// Watch подписка на изменения пользователей, подходящих под запрос s в режиме mode, как в SearchUsers.
+// Фильтр проверяется по карточке после изменения, для удаления по удаленной карточке.
+// Канал закрывается, когда отменяют ctx или подписчик отстал больше чем на WatchBuffer событий.
+func (us *Users) Watch(ctx context.Context, s, mode string) (<-chan Event, error) {
+	if err := auth.Require(ctx, auth.PermSearch); err != nil {
+		return nil, wrap("watch users", err)
+	}
+	q, err := parseSearch(s, mode)
+	if err != nil {
+		return nil, wrap("watch users", err)
+	}
+	w := &watcher{q: q, ch: make(chan Event, WatchBuffer)}
+	us.watch.add(w)
+	go func() {
+		<-ctx.Done()
+		us.watch.remove(w)
+	}()
+	return w.ch, nil
+}
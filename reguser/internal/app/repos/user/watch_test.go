@@ -0,0 +1,65 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
+)
+
+func TestUsers_Watch(t *testing.T) {
+	us := NewUsers(nil)
+	ctx, cancel := context.WithCancel(auth.WithPrincipal(context.Background(), &auth.Principal{Permissions: auth.PermSearch}))
+	defer cancel()
+
+	reader := auth.WithPrincipal(context.Background(), &auth.Principal{Permissions: auth.PermRead})
+	if _, err := us.Watch(reader, "", ""); !errors.Is(err, auth.ErrForbidden) {
+		t.Errorf("watch without search permission: %v", err)
+	}
+	if _, err := us.Watch(ctx, "name:(", ""); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("bad query: %v", err)
+	}
+
+	all, err := us.Watch(ctx, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ivan, err := us.Watch(ctx, "IVAN", ModeFold)
+	if err != nil {
+		t.Fatal(err)
+	}
+	us.watch.publish(EventCreated, User{Name: "petrov"})
+	us.watch.publish(EventDeleted, User{Name: "Ivanov"})
+
+	if ev := <-all; ev.Type != EventCreated || ev.User.Name != "petrov" {
+		t.Errorf("all: %+v", ev)
+	}
+	if ev := <-all; ev.Type != EventDeleted {
+		t.Errorf("all: %+v", ev)
+	}
+	if ev := <-ivan; ev.Type != EventDeleted || ev.User.Name != "Ivanov" {
+		t.Errorf("ivan: %+v", ev)
+	}
+	select {
+	case ev := <-ivan:
+		t.Errorf("ivan got extra %+v", ev)
+	default:
+	}
+
+	// подписчик, который не читает, после WatchBuffer событий отключается, остальные продолжают получать
+	for i := 0; i <= WatchBuffer; i++ {
+		us.watch.publish(EventUpdated, User{Name: "ivan"})
+	}
+	n := 0
+	for range ivan {
+		n++
+	}
+	if n != WatchBuffer {
+		t.Errorf("lagging subscriber got %d events before close, want %d", n, WatchBuffer)
+	}
+
+	cancel()
+	for range all {
+	}
+}
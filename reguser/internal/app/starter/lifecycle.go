@@ -0,0 +1,241 @@
+package starter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultStopTimeout сколько ждем остановки компонента, если при добавлении таймаут не задан
+const DefaultStopTimeout = 5 * time.Second
+
+// Component часть приложения, которую надо запустить и остановить: сервер, хранилище, фоновый обработчик.
+// Start не блокируется, ошибки во время работы компонент присылает в канал и закрывает его после Stop.
+// Если таких ошибок не бывает, Start возвращает nil канал.
+type Component interface {
+	Start(ctx context.Context) (<-chan error, error)
+	Stop(ctx context.Context) error
+}
+
+// State состояние компонента
+type State int
+
+const (
+	StateNew State = iota
+	StateStarting
+	StateRunning
+	StateStopping
+	StateStopped
+	StateFailed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateNew:
+		return "new"
+	case StateStarting:
+		return "starting"
+	case StateRunning:
+		return "running"
+	case StateStopping:
+		return "stopping"
+	case StateStopped:
+		return "stopped"
+	case StateFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("state(%d)", int(s))
+}
+
+// Health состояние одного компонента, Err последняя ошибка, если компонент упал
+type Health struct {
+	Name  string
+	State State
+	Err   error
+}
+
+// Errors несколько ошибок запуска и остановки разом
+type Errors []error
+
+func (es Errors) Error() string {
+	ss := make([]string, len(es))
+	for i, err := range es {
+		ss[i] = err.Error()
+	}
+	return strings.Join(ss, "; ")
+}
+
+// Is чтобы errors.Is находил любую из ошибок
+func (es Errors) Is(target error) bool {
+	for _, err := range es {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+type entry struct {
+	name        string
+	c           Component
+	stopTimeout time.Duration
+	state       State
+	err         error
+}
+
+// Lifecycle запускает компоненты в порядке добавления и останавливает в обратном,
+// так сервер останавливается раньше хранилища, которым он пользуется.
+type Lifecycle struct {
+	mu    sync.Mutex
+	comps []*entry
+}
+
+func NewLifecycle() *Lifecycle {
+	return &Lifecycle{}
+}
+
+// Add добавляет компонент, вызывается до Run. stopTimeout ограничивает Stop этого компонента,
+// если он не уложился, остальные останавливаются все равно.
+func (l *Lifecycle) Add(name string, c Component, stopTimeout time.Duration) {
+	if stopTimeout <= 0 {
+		stopTimeout = DefaultStopTimeout
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.comps = append(l.comps, &entry{name: name, c: c, stopTimeout: stopTimeout})
+}
+
+func (l *Lifecycle) set(e *entry, s State, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// упавший компонент так и остается упавшим, даже если потом нормально остановился
+	if e.state == StateFailed && s != StateFailed {
+		return
+	}
+	e.state = s
+	if err != nil {
+		e.err = err
+	}
+}
+
+// Health состояние всех компонентов в порядке запуска
+func (l *Lifecycle) Health() []Health {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	hs := make([]Health, len(l.comps))
+	for i, e := range l.comps {
+		hs[i] = Health{Name: e.name, State: e.state, Err: e.err}
+	}
+	return hs
+}
+
+// Healthy все компоненты запущены и работают
+func (l *Lifecycle) Healthy() bool {
+	for _, h := range l.Health() {
+		if h.State != StateRunning {
+			return false
+		}
+	}
+	return true
+}
+
+// Run запускает компоненты по порядку и работает до отмены контекста или до первой ошибки компонента.
+// Если компонент не запустился, уже запущенные останавливаются. Возвращает nil при остановке по контексту,
+// иначе Errors со всеми ошибками запуска, работы и остановки.
+func (l *Lifecycle) Run(ctx context.Context) error {
+	l.mu.Lock()
+	comps := append([]*entry(nil), l.comps...)
+	l.mu.Unlock()
+
+	var errs Errors
+	failed := make(chan error, 1)
+	started := 0
+	for _, e := range comps {
+		l.set(e, StateStarting, nil)
+		errc, err := e.c.Start(ctx)
+		if err != nil {
+			l.set(e, StateFailed, err)
+			errs = append(errs, fmt.Errorf("start %s: %w", e.name, err))
+			break
+		}
+		l.set(e, StateRunning, nil)
+		started++
+		if errc != nil {
+			go l.watch(e, errc, failed)
+		}
+	}
+
+	if started == len(comps) {
+		select {
+		case <-ctx.Done():
+		case err := <-failed:
+			errs = append(errs, err)
+		}
+	}
+
+	for i := started - 1; i >= 0; i-- {
+		if err := l.stop(comps[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// watch ждет ошибки компонента во время работы, Run реагирует только на первую
+func (l *Lifecycle) watch(e *entry, errc <-chan error, failed chan<- error) {
+	for err := range errc {
+		if err == nil {
+			continue
+		}
+		l.set(e, StateFailed, err)
+		select {
+		case failed <- fmt.Errorf("%s: %w", e.name, err):
+		default:
+		}
+	}
+}
+
+// stop останавливает компонент, но ждет не дольше его stopTimeout.
+// Зависший Stop остается работать в горутине, ждать его дальше нет смысла.
+func (l *Lifecycle) stop(e *entry) error {
+	l.set(e, StateStopping, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), e.stopTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- e.c.Stop(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		err = fmt.Errorf("stop %s: %w", e.name, err)
+		l.set(e, StateFailed, err)
+		return err
+	}
+	l.set(e, StateStopped, nil)
+	return nil
+}
+
+type closer struct {
+	c io.Closer
+}
+
+func (c closer) Start(ctx context.Context) (<-chan error, error) { return nil, nil }
+func (c closer) Stop(ctx context.Context) error                  { return c.c.Close() }
+
+// Closer компонент для уже открытого ресурса, например хранилища: запускать нечего, при остановке Close
+func Closer(c io.Closer) Component {
+	return closer{c: c}
+}
@@ -0,0 +1,143 @@
+package starter
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeComponent пишет в общий журнал, когда его запускают и останавливают
+type fakeComponent struct {
+	name     string
+	log      *[]string
+	mu       *sync.Mutex
+	startErr error
+	stopErr  error
+	hang     bool
+	errc     chan error
+}
+
+func (c *fakeComponent) record(s string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.log = append(*c.log, s)
+}
+
+func (c *fakeComponent) Start(ctx context.Context) (<-chan error, error) {
+	c.record("start " + c.name)
+	if c.startErr != nil {
+		return nil, c.startErr
+	}
+	return c.errc, nil
+}
+
+func (c *fakeComponent) Stop(ctx context.Context) error {
+	c.record("stop " + c.name)
+	if c.hang {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+	}
+	return c.stopErr
+}
+
+func newFakes(names ...string) ([]*fakeComponent, *[]string) {
+	log := &[]string{}
+	mu := &sync.Mutex{}
+	cs := make([]*fakeComponent, len(names))
+	for i, n := range names {
+		cs[i] = &fakeComponent{name: n, log: log, mu: mu}
+	}
+	return cs, log
+}
+
+func TestLifecycle_Order(t *testing.T) {
+	cs, log := newFakes("store", "worker", "http")
+	l := NewLifecycle()
+	for _, c := range cs {
+		l.Add(c.name, c, 0)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- l.Run(ctx) }()
+	for !l.Healthy() {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"start store", "start worker", "start http", "stop http", "stop worker", "stop store"}
+	if !reflect.DeepEqual(*log, want) {
+		t.Errorf("got %v, want %v", *log, want)
+	}
+	for _, h := range l.Health() {
+		if h.State != StateStopped {
+			t.Errorf("%s is %s", h.Name, h.State)
+		}
+	}
+}
+
+func TestLifecycle_StartFailure(t *testing.T) {
+	errFail := errors.New("fail")
+	cs, log := newFakes("store", "http", "never")
+	cs[1].startErr = errFail
+	l := NewLifecycle()
+	for _, c := range cs {
+		l.Add(c.name, c, 0)
+	}
+
+	err := l.Run(context.Background())
+	if !errors.Is(err, errFail) {
+		t.Fatalf("error %v", err)
+	}
+	want := []string{"start store", "start http", "stop store"}
+	if !reflect.DeepEqual(*log, want) {
+		t.Errorf("got %v, want %v", *log, want)
+	}
+	states := []State{StateStopped, StateFailed, StateNew}
+	for i, h := range l.Health() {
+		if h.State != states[i] {
+			t.Errorf("%s is %s, want %s", h.Name, h.State, states[i])
+		}
+	}
+}
+
+func TestLifecycle_StopErrors(t *testing.T) {
+	errFail := errors.New("fail")
+	errStop := errors.New("stop failed")
+	cs, log := newFakes("store", "worker", "http")
+	cs[0].stopErr = errStop
+	cs[1].hang = true
+	cs[2].errc = make(chan error, 1)
+	cs[2].errc <- errFail
+
+	l := NewLifecycle()
+	l.Add("store", cs[0], 0)
+	l.Add("worker", cs[1], 10*time.Millisecond)
+	l.Add("http", cs[2], 0)
+
+	err := l.Run(context.Background())
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Fatalf("error %v", err)
+	}
+	for _, target := range []error{errFail, errStop, context.DeadlineExceeded} {
+		if !errors.Is(err, target) {
+			t.Errorf("%v not in %v", target, err)
+		}
+	}
+	// зависший worker не помешал остановить store
+	if (*log)[len(*log)-1] != "stop store" {
+		t.Errorf("log %v", *log)
+	}
+	for _, h := range l.Health() {
+		if h.State != StateFailed || h.Err == nil {
+			t.Errorf("%s is %s, err %v", h.Name, h.State, h.Err)
+		}
+	}
+}
@@ -2,7 +2,7 @@ package starter
 
 import (
 	"context"
-	"sync"
+	"time"
 
 	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
 )
@@ -12,9 +12,10 @@ import (
 // точнее стартер - апликейшен уровень над уровнем бизнес логики.
 // Здесь может располагаться бизнес логика по оркестрации запросов дополнительно.
 
-// App Здесь мы должны стартануть приложение
+// App Здесь мы должны стартануть приложение. Компоненты запускает и останавливает Lifecycle.
 type App struct {
 	us *user.Users
+	lc *Lifecycle
 }
 
 // NewApp функция инициализации приложения, котора возвращает уже заполненный апп
@@ -22,26 +23,44 @@ type App struct {
 func NewApp(ust user.UserStore) *App {
 	a := &App{
 		us: user.NewUsers(ust),
+		lc: NewLifecycle(),
 	}
 	return a
 }
 
+// HTTPServer Start возвращает ошибку, если сервер не смог запуститься, например порт занят,
+// а ошибки во время работы присылает в канал. Канал закрывается, когда сервер остановлен.
 type HTTPServer interface {
-	Start(us *user.Users)
-	Stop()
-}
-
-// Serve пока ничего не делает, ему на вход не хватает api, который будет присылать запросы
-// Пробрасываем контекст, чтобы отловить сигналы от операционной системы
-// и вейт группу, и в дефере завершаем ее
-func (a *App) Serve(ctx context.Context, wg *sync.WaitGroup, hs HTTPServer) {
-	defer wg.Done()
-	// вызываем старт
-	hs.Start(a.us)
-	// дожидаемся здесь цтикс дана
-	<-ctx.Done()
-	// после того как все завершили, мы его останавливаем.
-	// стоп добавит 2 сек и нормально остановит с бэкграунд контекстом,
-	// уберем контекст, так как мы ничего не логируем, перенесем его в стоп
-	hs.Stop()
+	Start(us *user.Users) (<-chan error, error)
+	Stop(ctx context.Context) error
+}
+
+type httpComponent struct {
+	hs HTTPServer
+	us *user.Users
+}
+
+func (c httpComponent) Start(ctx context.Context) (<-chan error, error) { return c.hs.Start(c.us) }
+func (c httpComponent) Stop(ctx context.Context) error                  { return c.hs.Stop(ctx) }
+
+// Add добавляет компонент, компоненты стартуют в порядке добавления и останавливаются в обратном
+func (a *App) Add(name string, c Component, stopTimeout time.Duration) {
+	a.lc.Add(name, c, stopTimeout)
+}
+
+// AddHTTPServer добавляет http сервер, ему бизнес логику пробрасывает приложение
+func (a *App) AddHTTPServer(name string, hs HTTPServer, stopTimeout time.Duration) {
+	a.lc.Add(name, httpComponent{hs: hs, us: a.us}, stopTimeout)
+}
+
+// Health состояние компонентов приложения
+func (a *App) Health() []Health {
+	return a.lc.Health()
+}
+
+// Serve запускает все компоненты и работает до отмены контекста или до ошибки любого из них.
+// Пробрасываем контекст, чтобы отловить сигналы от операционной системы.
+// Возвращает nil при нормальной остановке по контексту, иначе ошибку, тогда main завершается с ошибкой.
+func (a *App) Serve(ctx context.Context) error {
+	return a.lc.Run(ctx)
 }
@@ -0,0 +1,61 @@
+package starter
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/audetv/hex-ecample/reguser/internal/db/mem/usermemstore"
+)
+
+type fakeServer struct {
+	startErr error
+	errc     chan error
+	stopped  bool
+}
+
+func (s *fakeServer) Start(us *user.Users) (<-chan error, error) {
+	return s.errc, s.startErr
+}
+
+func (s *fakeServer) Stop(ctx context.Context) error {
+	s.stopped = true
+	return nil
+}
+
+func TestApp_Serve(t *testing.T) {
+	errFail := errors.New("fail")
+	serve := func(ctx context.Context, hs *fakeServer) error {
+		a := NewApp(usermemstore.NewUsers())
+		a.AddHTTPServer("http", hs, 0)
+		return a.Serve(ctx)
+	}
+
+	hs := &fakeServer{startErr: errFail}
+	if err := serve(context.Background(), hs); !errors.Is(err, errFail) {
+		t.Errorf("start error %v", err)
+	}
+	if hs.stopped {
+		t.Error("stopped a server that did not start")
+	}
+
+	hs = &fakeServer{errc: make(chan error, 1)}
+	hs.errc <- errFail
+	if err := serve(context.Background(), hs); !errors.Is(err, errFail) {
+		t.Errorf("serve error %v", err)
+	}
+	if !hs.stopped {
+		t.Error("not stopped after serve error")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	hs = &fakeServer{errc: make(chan error)}
+	if err := serve(ctx, hs); err != nil {
+		t.Errorf("canceled: %v", err)
+	}
+	if !hs.stopped {
+		t.Error("not stopped after cancel")
+	}
+}
@@ -0,0 +1,193 @@
+// Package operatorfilestore операторы в JSON файле. Операторов мало и меняются они редко,
+// поэтому держим их в памяти, а на каждое изменение переписываем файл целиком:
+// пишем во временный файл рядом, делаем fsync и переименовываем поверх старого, так файл всегда целый.
+package operatorfilestore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/operator"
+)
+
+var _ operator.OperatorStore = &Operators{}
+
+// record формат оператора в файле, отдельный от бизнес структуры
+type record struct {
+	Login        string    `json:"login"`
+	PasswordHash string    `json:"password_hash"`
+	Permissions  []string  `json:"permissions"`
+	Disabled     bool      `json:"disabled,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Operators хранилище операторов в файле path
+type Operators struct {
+	sync.Mutex
+	m    map[string]operator.Operator
+	path string
+}
+
+// NewOperators читает файл, если его нет - начинаем с пустого списка, файл появится при первом изменении
+func NewOperators(path string) (*Operators, error) {
+	ops := &Operators{
+		m:    make(map[string]operator.Operator),
+		path: path,
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ops, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := []record{}
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, r := range list {
+		perms, err := auth.ParsePermissions(r.Permissions)
+		if err != nil {
+			return nil, fmt.Errorf("operator %q: %w", r.Login, err)
+		}
+		ops.m[r.Login] = operator.Operator{
+			Login:        r.Login,
+			PasswordHash: []byte(r.PasswordHash),
+			Permissions:  perms,
+			Disabled:     r.Disabled,
+			UpdatedAt:    r.UpdatedAt,
+		}
+	}
+	return ops, nil
+}
+
+// save записывает всех операторов в файл, вызывается под локом
+func (ops *Operators) save() error {
+	list := make([]record, 0, len(ops.m))
+	for _, o := range ops.m {
+		list = append(list, record{
+			Login:        o.Login,
+			PasswordHash: string(o.PasswordHash),
+			Permissions:  auth.PermissionNames(o.Permissions),
+			Disabled:     o.Disabled,
+			UpdatedAt:    o.UpdatedAt,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Login < list[j].Login })
+	b, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(ops.path), filepath.Base(ops.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), ops.path)
+}
+
+// Create и Update меняют мапу и сохраняют файл под одним локом, если файл записать не удалось,
+// изменение в памяти откатываем. Update читает и меняет оператора под тем же локом.
+
+func (ops *Operators) Create(ctx context.Context, o operator.Operator) error {
+	ops.Lock()
+	defer ops.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if _, ok := ops.m[o.Login]; ok {
+		return operator.ErrExists
+	}
+	ops.m[o.Login] = o
+	if err := ops.save(); err != nil {
+		delete(ops.m, o.Login)
+		return fmt.Errorf("save operators: %w", err)
+	}
+	return nil
+}
+
+func (ops *Operators) Read(ctx context.Context, login string) (*operator.Operator, error) {
+	ops.Lock()
+	defer ops.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	o, ok := ops.m[login]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &o, nil
+}
+
+func (ops *Operators) Update(ctx context.Context, login string, f func(o *operator.Operator)) error {
+	ops.Lock()
+	defer ops.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	old, ok := ops.m[login]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	o := old
+	f(&o)
+	o.Login = login
+	ops.m[login] = o
+	if err := ops.save(); err != nil {
+		ops.m[login] = old
+		return fmt.Errorf("save operators: %w", err)
+	}
+	return nil
+}
+
+// List возвращает операторов отсортированными по логину
+func (ops *Operators) List(ctx context.Context) ([]operator.Operator, error) {
+	ops.Lock()
+	defer ops.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	list := make([]operator.Operator, 0, len(ops.m))
+	for _, o := range ops.m {
+		list = append(list, o)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Login < list[j].Login })
+	return list, nil
+}
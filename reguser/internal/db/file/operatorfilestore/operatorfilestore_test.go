@@ -0,0 +1,94 @@
+package operatorfilestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/operator"
+)
+
+func TestOperators_Reload(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "operators.json")
+	ops, err := NewOperators(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := operator.Operator{
+		Login:        "admin",
+		PasswordHash: []byte("$2a$04$hash"),
+		Permissions:  auth.PermRead | auth.PermSearch,
+		UpdatedAt:    time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	if err := ops.Create(ctx, o); err != nil {
+		t.Fatal(err)
+	}
+	if err := ops.Create(ctx, o); !errors.Is(err, operator.ErrExists) {
+		t.Errorf("create existing: %v", err)
+	}
+	o.Disabled = true
+	if err := ops.Update(ctx, "admin", func(so *operator.Operator) { so.Disabled = true }); err != nil {
+		t.Fatal(err)
+	}
+	if err := ops.Update(ctx, "nobody", func(*operator.Operator) {}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("update unknown: %v", err)
+	}
+
+	ops, err = NewOperators(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ops.Read(ctx, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got.PasswordHash) != string(o.PasswordHash) || got.Permissions != o.Permissions || !got.Disabled || !got.UpdatedAt.Equal(o.UpdatedAt) {
+		t.Errorf("reloaded %+v, want %+v", got, o)
+	}
+}
+
+// Update читает и пишет под одним локом, параллельные изменения не теряются
+func TestOperators_UpdateConcurrent(t *testing.T) {
+	ctx := context.Background()
+	ops, err := NewOperators(filepath.Join(t.TempDir(), "operators.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ops.Create(ctx, operator.Operator{Login: "ivan"}); err != nil {
+		t.Fatal(err)
+	}
+	const n = 20
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 2*n)
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			errs <- ops.Update(ctx, "ivan", func(o *operator.Operator) { o.PasswordHash = append(o.PasswordHash, 'x') })
+		}()
+		go func() {
+			defer wg.Done()
+			errs <- ops.Update(ctx, "ivan", func(o *operator.Operator) { o.Disabled = true })
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	o, err := ops.Read(ctx, "ivan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(o.PasswordHash) != n || !o.Disabled {
+		t.Errorf("lost updates: %d password changes, disabled %v", len(o.PasswordHash), o.Disabled)
+	}
+}
@@ -0,0 +1,96 @@
+package userfilestore
+
+import (
+	"context"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/google/uuid"
+)
+
+var _ user.UserTx = &Tx{}
+
+// Tx транзакция файлового хранилища. Изменения сразу применяются в транзакции usermemstore,
+// а записи для журнала копятся и по Commit пишутся одним кадром. Если запись в журнал не удалась,
+// изменения в памяти откатываются, так что память и файл не расходятся.
+type Tx struct {
+	us  *Users
+	mem user.UserTx
+	rs  []record
+}
+
+func (us *Users) Begin(ctx context.Context) (user.UserTx, error) {
+	return us.begin(ctx)
+}
+
+func (us *Users) begin(ctx context.Context) (*Tx, error) {
+	mem, err := us.mem.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{
+		us:  us,
+		mem: mem,
+	}, nil
+}
+
+// Create и Update пишут в журнал карточку, какой ее сохранила память, с версией, которую та назначила
+func (tx *Tx) Create(ctx context.Context, u user.User) (*uuid.UUID, error) {
+	id, err := tx.mem.Create(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	return id, tx.record(ctx, u.ID)
+}
+
+func (tx *Tx) Read(ctx context.Context, uid uuid.UUID) (*user.User, error) {
+	return tx.mem.Read(ctx, uid)
+}
+
+func (tx *Tx) Update(ctx context.Context, u user.User) error {
+	if err := tx.mem.Update(ctx, u); err != nil {
+		return err
+	}
+	return tx.record(ctx, u.ID)
+}
+
+// record добавляет в журнал текущее состояние карточки в транзакции
+func (tx *Tx) record(ctx context.Context, uid uuid.UUID) error {
+	u, err := tx.mem.Read(ctx, uid)
+	if err != nil {
+		return err
+	}
+	tx.rs = append(tx.rs, putRecord(*u))
+	return nil
+}
+
+func (tx *Tx) Patch(ctx context.Context, uid uuid.UUID, p user.UserPatch) (*user.User, error) {
+	u, err := tx.mem.Patch(ctx, uid, p)
+	if err != nil {
+		return nil, err
+	}
+	tx.rs = append(tx.rs, putRecord(*u))
+	return u, nil
+}
+
+func (tx *Tx) Delete(ctx context.Context, uid uuid.UUID) error {
+	if err := tx.mem.Delete(ctx, uid); err != nil {
+		return err
+	}
+	tx.rs = append(tx.rs, deleteRecord(uid))
+	return nil
+}
+
+// Commit сначала пишет журнал, и только потом фиксирует изменения в памяти
+func (tx *Tx) Commit() error {
+	if err := tx.us.write(tx.rs); err != nil {
+		_ = tx.mem.Rollback()
+		return err
+	}
+	tx.rs = nil
+	return tx.mem.Commit()
+}
+
+func (tx *Tx) Rollback() error {
+	tx.rs = nil
+	return tx.mem.Rollback()
+}
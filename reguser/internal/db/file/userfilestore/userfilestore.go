@@ -0,0 +1,306 @@
+// Package userfilestore хранилище пользователей в файле.
+// Текущее состояние держим в памяти в usermemstore, а каждое изменение сначала дописываем в журнал (WAL),
+// при старте журнал проигрывается заново и состояние восстанавливается.
+package userfilestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/audetv/hex-ecample/reguser/internal/db/mem/usermemstore"
+	"github.com/google/uuid"
+)
+
+var _ user.UserStore = &Users{}
+var _ user.NamePolicyStore = &Users{}
+
+// SyncMode когда вызывать fsync журнала
+type SyncMode int
+
+const (
+	// SyncAlways fsync после каждой транзакции, ничего не теряем, но каждая запись ждет диск
+	SyncAlways SyncMode = iota
+	// SyncInterval fsync в фоне раз в SyncInterval, при падении ОС можно потерять последние изменения
+	SyncInterval
+	// SyncNever сброс на диск на усмотрение ОС
+	SyncNever
+)
+
+// Config настройки файлового хранилища
+type Config struct {
+	// Path путь к файлу журнала, файл создается если его нет
+	Path string
+	Sync SyncMode
+	// SyncInterval период фонового fsync для SyncInterval, по умолчанию секунда
+	SyncInterval time.Duration
+}
+
+// ErrFailed журнал разошелся с памятью: кадр не удалось ни дописать, ни отрезать, или не удался фоновый fsync.
+// Хранилище после этого только читает, все изменения возвращают эту ошибку до перезапуска.
+var ErrFailed = errors.New("wal failed, store is read only")
+
+// walFile файл журнала, в тестах подменяется, чтобы имитировать ошибки диска
+type walFile interface {
+	io.Writer
+	io.Seeker
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+// Users хранилище с журналом. Запись в журнал идет внутри транзакции usermemstore,
+// которая держит лок, поэтому порядок записей в журнале совпадает с порядком изменений в памяти.
+type Users struct {
+	mem *usermemstore.Users
+	cfg Config
+
+	// mu защищает файл, его размер, флаг dirty и ошибку failed от фонового fsync.
+	// size конец последнего целого кадра, failed не nil если журнал больше писать нельзя.
+	mu     sync.Mutex
+	f      walFile
+	size   int64
+	dirty  bool
+	failed error
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewUsers открывает журнал, проигрывает его и возвращает готовое хранилище.
+// После работы хранилище нужно закрыть через Close.
+func NewUsers(cfg Config) (*Users, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("userfilestore: empty path")
+	}
+	if cfg.Sync == SyncInterval && cfg.SyncInterval <= 0 {
+		cfg.SyncInterval = time.Second
+	}
+
+	f, err := os.OpenFile(cfg.Path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open wal: %w", err)
+	}
+
+	us := &Users{
+		mem:  usermemstore.NewUsers(),
+		cfg:  cfg,
+		f:    f,
+		stop: make(chan struct{}),
+	}
+	if us.size, err = replay(f, us.apply); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("replay wal: %w", err)
+	}
+	if cfg.Sync == SyncInterval {
+		us.wg.Add(1)
+		go us.syncLoop()
+	}
+	return us, nil
+}
+
+// SetNamePolicy включает уникальность имен по политике p. Политика в журнал не пишется,
+// журнал проигрывается без проверки имен: в нем и промежуточные состояния, и записи,
+// сделанные до включения уникальности. Поэтому проверяется уже итоговое состояние.
+func (us *Users) SetNamePolicy(ctx context.Context, p user.NamePolicy) error {
+	return us.mem.SetNamePolicy(ctx, p)
+}
+
+// apply применяет транзакцию из журнала к состоянию в памяти
+func (us *Users) apply(rs []record) error {
+	ctx := context.Background()
+	for _, r := range rs {
+		switch r.Op {
+		case opPut:
+			u := r.user()
+			// в записях, сделанных до появления версий, ее нет: считаем каждую запись новой версией
+			if u.Version == 0 {
+				if old, err := us.mem.Read(ctx, u.ID); err == nil {
+					u.Version = old.Version + 1
+				}
+			}
+			if _, err := us.mem.Create(ctx, u); err != nil {
+				return err
+			}
+		case opDelete:
+			if err := us.mem.Delete(ctx, r.ID); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown wal op %q", r.Op)
+		}
+	}
+	return nil
+}
+
+// write дописывает транзакцию в журнал и при необходимости делает fsync
+func (us *Users) write(rs []record) error {
+	if len(rs) == 0 {
+		return nil
+	}
+	b, err := encodeFrame(rs)
+	if err != nil {
+		return err
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	if us.f == nil {
+		return os.ErrClosed
+	}
+	if us.failed != nil {
+		return us.failed
+	}
+	if _, err := us.f.Write(b); err != nil {
+		return us.rollback(fmt.Errorf("write wal: %w", err))
+	}
+	if us.cfg.Sync == SyncAlways {
+		if err := us.f.Sync(); err != nil {
+			return us.rollback(fmt.Errorf("sync wal: %w", err))
+		}
+	} else {
+		us.dirty = true
+	}
+	us.size += int64(len(b))
+	return nil
+}
+
+// rollback отрезает от журнала кадр, который не удалось дописать или сбросить на диск. Транзакция уже откатится
+// в памяти, и ее кадр не должен вернуться при проигрывании, а следующие кадры не должны лечь после обрывка.
+// Если отрезать не удалось, журнал больше не совпадает с памятью, и хранилище переходит в ErrFailed.
+func (us *Users) rollback(err error) error {
+	if terr := us.truncate(); terr != nil {
+		us.failed = fmt.Errorf("%w: %v, rollback: %v", ErrFailed, err, terr)
+		return us.failed
+	}
+	return err
+}
+
+// truncate обрезает файл до конца последнего целого кадра и сбрасывает это на диск
+func (us *Users) truncate() error {
+	if err := us.f.Truncate(us.size); err != nil {
+		return err
+	}
+	if _, err := us.f.Seek(us.size, io.SeekStart); err != nil {
+		return err
+	}
+	return us.f.Sync()
+}
+
+func (us *Users) syncLoop() {
+	defer us.wg.Done()
+	t := time.NewTicker(us.cfg.SyncInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-us.stop:
+			return
+		case <-t.C:
+			us.mu.Lock()
+			if us.dirty && us.f != nil && us.failed == nil {
+				// уже подтвержденные транзакции могли не дойти до диска, дальше писать нельзя
+				if err := us.f.Sync(); err != nil {
+					us.failed = fmt.Errorf("%w: sync wal: %v", ErrFailed, err)
+				}
+				us.dirty = false
+			}
+			us.mu.Unlock()
+		}
+	}
+}
+
+// Close сбрасывает журнал на диск и закрывает файл
+func (us *Users) Close() error {
+	us.stopOnce.Do(func() { close(us.stop) })
+	us.wg.Wait()
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	if us.f == nil {
+		return os.ErrClosed
+	}
+	err := us.f.Sync()
+	if cerr := us.f.Close(); err == nil {
+		err = cerr
+	}
+	us.f = nil
+	return err
+}
+
+// Одиночные изменения тоже выполняем как транзакцию из одной записи
+
+func (us *Users) Create(ctx context.Context, u user.User) (*uuid.UUID, error) {
+	tx, err := us.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	id, err := tx.Create(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	return id, tx.Commit()
+}
+
+func (us *Users) Read(ctx context.Context, uid uuid.UUID) (*user.User, error) {
+	return us.mem.Read(ctx, uid)
+}
+
+func (us *Users) Update(ctx context.Context, u user.User) error {
+	tx, err := us.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := tx.Update(ctx, u); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (us *Users) Patch(ctx context.Context, uid uuid.UUID, p user.UserPatch) (*user.User, error) {
+	tx, err := us.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	u, err := tx.Patch(ctx, uid, p)
+	if err != nil {
+		return nil, err
+	}
+	return u, tx.Commit()
+}
+
+func (us *Users) Delete(ctx context.Context, uid uuid.UUID) error {
+	tx, err := us.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := tx.Delete(ctx, uid); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SearchUsers поиск идет по состоянию в памяти, журнал для чтения не нужен
+func (us *Users) SearchUsers(ctx context.Context, q user.Query) (chan user.Found, error) {
+	return us.mem.SearchUsers(ctx, q)
+}
+
+// ListUsers как и поиск читает состояние в памяти
+func (us *Users) ListUsers(ctx context.Context, q user.ListQuery) ([]user.User, error) {
+	return us.mem.ListUsers(ctx, q)
+}
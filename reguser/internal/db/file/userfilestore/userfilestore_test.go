@@ -0,0 +1,314 @@
+package userfilestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/audetv/hex-ecample/reguser/internal/db/storetest"
+	"github.com/google/uuid"
+)
+
+func openUsers(t *testing.T, path string) *Users {
+	t.Helper()
+	us, err := NewUsers(Config{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return us
+}
+
+func TestUsers(t *testing.T) {
+	storetest.Run(t, func() user.UserStore {
+		us := openUsers(t, filepath.Join(t.TempDir(), "users.wal"))
+		t.Cleanup(func() { _ = us.Close() })
+		return us
+	})
+}
+
+func TestUsers_Replay(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "users.wal")
+	us := openUsers(t, path)
+
+	a := user.User{ID: uuid.New(), Name: "a", Data: "data"}
+	b := user.User{ID: uuid.New(), Name: "b"}
+	for _, u := range []user.User{a, b} {
+		if _, err := us.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	name := "aa"
+	if _, err := us.Patch(ctx, a.ID, user.UserPatch{Name: &name}); err != nil {
+		t.Fatal(err)
+	}
+	if err := us.Delete(ctx, b.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// откатанная транзакция не должна попасть в журнал
+	tx, err := us.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Create(ctx, user.User{ID: uuid.New(), Name: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	_ = tx.Rollback()
+
+	if err := us.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	us = openUsers(t, path)
+	defer us.Close()
+
+	u, err := us.Read(ctx, a.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "aa" || u.Data != "data" || u.Version != 2 {
+		t.Errorf("replayed user %+v", u)
+	}
+	if _, err := us.Read(ctx, b.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("deleted user is replayed: %v", err)
+	}
+	ch, err := us.SearchUsers(ctx, user.NameContains(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for range ch {
+		n++
+	}
+	if n != 1 {
+		t.Errorf("replayed %d users, want 1", n)
+	}
+}
+
+// TestUsers_TornTail недописанный последний кадр отбрасывается, а журнал продолжает работать
+func TestUsers_TornTail(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "users.wal")
+	us := openUsers(t, path)
+	a := user.User{ID: uuid.New(), Name: "a"}
+	if _, err := us.Create(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Create(ctx, user.User{ID: uuid.New(), Name: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := us.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, fi.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	us = openUsers(t, path)
+	if _, err := us.Read(ctx, a.ID); err != nil {
+		t.Fatalf("first user lost: %v", err)
+	}
+	c := user.User{ID: uuid.New(), Name: "c"}
+	if _, err := us.Create(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	if err := us.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	us = openUsers(t, path)
+	defer us.Close()
+	if _, err := us.Read(ctx, c.ID); err != nil {
+		t.Errorf("user written after truncated tail is lost: %v", err)
+	}
+}
+
+// TestUsers_CorruptMiddle битый кадр посреди журнала не отрезается вместе со всем, что после него, а не дает открыть стор
+func TestUsers_CorruptMiddle(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "users.wal")
+	us := openUsers(t, path)
+	for _, name := range []string{"a", "b"} {
+		if _, err := us.Create(ctx, user.User{ID: uuid.New(), Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := us.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[headerSize+1] ^= 0xff
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewUsers(Config{Path: path}); !errors.Is(err, errCorrupt) {
+		t.Errorf("open corrupted wal: %v", err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() != int64(len(b)) {
+		t.Errorf("corrupted wal is truncated: %v", err)
+	}
+}
+
+var errDisk = errors.New("disk failure")
+
+// failingFile файл журнала, который ломается по заказу: пишет только половину кадра, не делает fsync
+// или не дает себя обрезать
+type failingFile struct {
+	*os.File
+	shortWrite, failSync, failTruncate bool
+}
+
+func (f *failingFile) Write(b []byte) (int, error) {
+	if f.shortWrite {
+		f.shortWrite = false
+		n, _ := f.File.Write(b[:len(b)/2])
+		return n, errDisk
+	}
+	return f.File.Write(b)
+}
+
+func (f *failingFile) Sync() error {
+	if f.failSync {
+		f.failSync = false
+		return errDisk
+	}
+	return f.File.Sync()
+}
+
+func (f *failingFile) Truncate(size int64) error {
+	if f.failTruncate {
+		return errDisk
+	}
+	return f.File.Truncate(size)
+}
+
+// TestUsers_WriteFailure кадр, который не удалось дописать или сбросить на диск, отрезается: неудавшаяся транзакция
+// не возвращается при проигрывании, а следующие за ней не теряются
+func TestUsers_WriteFailure(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "users.wal")
+	us := openUsers(t, path)
+	f := &failingFile{File: us.f.(*os.File)}
+	us.f = f
+
+	a := user.User{ID: uuid.New(), Name: "a"}
+	if _, err := us.Create(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	f.shortWrite = true
+	short := user.User{ID: uuid.New(), Name: "short"}
+	if _, err := us.Create(ctx, short); !errors.Is(err, errDisk) {
+		t.Fatalf("short write: %v", err)
+	}
+	f.failSync = true
+	unsynced := user.User{ID: uuid.New(), Name: "unsynced"}
+	if _, err := us.Create(ctx, unsynced); !errors.Is(err, errDisk) {
+		t.Fatalf("failed sync: %v", err)
+	}
+	b := user.User{ID: uuid.New(), Name: "b"}
+	if _, err := us.Create(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+	if err := us.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	us = openUsers(t, path)
+	defer us.Close()
+	for _, u := range []user.User{a, b} {
+		if _, err := us.Read(ctx, u.ID); err != nil {
+			t.Errorf("committed user %s is lost: %v", u.Name, err)
+		}
+	}
+	for _, u := range []user.User{short, unsynced} {
+		if _, err := us.Read(ctx, u.ID); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("failed user %s is replayed: %v", u.Name, err)
+		}
+	}
+}
+
+// TestUsers_WriteFailureRollbackFails если обрывок кадра не удалось отрезать, стор отказывает во всех изменениях
+func TestUsers_WriteFailureRollbackFails(t *testing.T) {
+	ctx := context.Background()
+	us := openUsers(t, filepath.Join(t.TempDir(), "users.wal"))
+	defer us.Close()
+	f := &failingFile{File: us.f.(*os.File), shortWrite: true, failTruncate: true}
+	us.f = f
+
+	a := user.User{ID: uuid.New(), Name: "a"}
+	if _, err := us.Create(ctx, a); !errors.Is(err, ErrFailed) {
+		t.Fatalf("failed rollback: %v", err)
+	}
+	if _, err := us.Create(ctx, user.User{ID: uuid.New(), Name: "b"}); !errors.Is(err, ErrFailed) {
+		t.Errorf("write after failure: %v", err)
+	}
+	if _, err := us.Read(ctx, a.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("failed user is in memory: %v", err)
+	}
+}
+
+func TestUsers_SyncInterval(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "users.wal")
+	us, err := NewUsers(Config{Path: path, Sync: SyncInterval})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Create(ctx, user.User{ID: uuid.New(), Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := us.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Create(ctx, user.User{ID: uuid.New(), Name: "b"}); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("create after close: %v", err)
+	}
+}
+
+// Уникальность проверяется по итоговому состоянию журнала, а не по каждой записи
+func TestUsers_UniqueNames(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "users.wal")
+	us := openUsers(t, path)
+	a := user.User{ID: uuid.New(), Name: "ivan"}
+	for _, u := range []user.User{a, {ID: uuid.New(), Name: "Ivan"}} {
+		if _, err := us.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := us.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	us = openUsers(t, path)
+	defer us.Close()
+	if err := us.SetNamePolicy(ctx, user.NamesFold); !errors.Is(err, user.ErrNameTaken) {
+		t.Fatalf("policy over duplicates: %v", err)
+	}
+	if err := us.SetNamePolicy(ctx, user.NamesExact); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Create(ctx, user.User{ID: uuid.New(), Name: "ivan"}); !errors.Is(err, user.ErrNameTaken) {
+		t.Errorf("create duplicate: %v", err)
+	}
+	if err := us.Delete(ctx, a.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Create(ctx, user.User{ID: uuid.New(), Name: "ivan"}); err != nil {
+		t.Errorf("name is not freed by delete: %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package userfilestore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/google/uuid"
+)
+
+// Журнал (write-ahead log) - это файл из кадров, которые только дописываются в конец.
+// Кадр: длина данных (4 байта), crc32 данных (4 байта), данные - JSON массив записей.
+// Один кадр - одна зафиксированная транзакция, поэтому при восстановлении она применяется целиком или никак.
+// Если процесс упал посреди записи, в конце файла останется обрезанный или битый кадр,
+// его отбрасываем и обрезаем файл до последнего целого кадра. Битый кадр, за которым в файле есть
+// еще данные, так не получится: это порча журнала, и проигрывание останавливается ошибкой errCorrupt.
+
+const headerSize = 8
+
+// maxFrameSize защита от мусора в заголовке, больше этого транзакция быть не может
+const maxFrameSize = 64 << 20
+
+const (
+	opPut    = "put"
+	opDelete = "del"
+)
+
+// record одна операция в журнале, put сохраняет карточку целиком, del удаляет по ID
+type record struct {
+	Op   string    `json:"op"`
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name,omitempty"`
+	Data string    `json:"data,omitempty"`
+	Perm int       `json:"perm,omitempty"`
+	Ver  int64     `json:"ver,omitempty"`
+}
+
+func putRecord(u user.User) record {
+	return record{
+		Op:   opPut,
+		ID:   u.ID,
+		Name: u.Name,
+		Data: u.Data,
+		Perm: u.Permissions,
+		Ver:  u.Version,
+	}
+}
+
+func deleteRecord(uid uuid.UUID) record {
+	return record{
+		Op: opDelete,
+		ID: uid,
+	}
+}
+
+func (r record) user() user.User {
+	return user.User{
+		ID:          r.ID,
+		Name:        r.Name,
+		Data:        r.Data,
+		Permissions: r.Perm,
+		Version:     r.Ver,
+	}
+}
+
+var (
+	errBadFrame = errors.New("bad wal frame")
+	errCorrupt  = errors.New("wal is corrupted")
+)
+
+// encodeFrame собирает кадр из записей одной транзакции
+func encodeFrame(rs []record) ([]byte, error) {
+	data, err := json.Marshal(rs)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, headerSize+len(data))
+	binary.LittleEndian.PutUint32(b[0:4], uint32(len(data)))
+	binary.LittleEndian.PutUint32(b[4:8], crc32.ChecksumIEEE(data))
+	copy(b[headerSize:], data)
+	return b, nil
+}
+
+// readFrame читает следующий кадр и возвращает его размер, io.EOF если файл кончился ровно на границе кадра,
+// errBadFrame если кадр обрезан или поврежден. У поврежденного кадра размер тот, что заявлен в заголовке.
+func readFrame(r io.Reader) ([]record, int64, error) {
+	h := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, h); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, 0, io.EOF
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, headerSize, errBadFrame
+		}
+		return nil, 0, err
+	}
+	n := binary.LittleEndian.Uint32(h[0:4])
+	size := int64(headerSize) + int64(n)
+	if n > maxFrameSize {
+		return nil, size, errBadFrame
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, size, errBadFrame
+		}
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(h[4:8]) {
+		return nil, size, errBadFrame
+	}
+	rs := []record{}
+	if err := json.Unmarshal(data, &rs); err != nil {
+		return nil, size, errBadFrame
+	}
+	return rs, size, nil
+}
+
+// replay читает журнал с начала, вызывает apply для каждой транзакции
+// и обрезает битый хвост. Возвращает размер целой части журнала.
+// Битый хвост это последний кадр, который по заголовку доходит до конца файла или дальше:
+// только так выглядит запись, оборванная падением. Если после битого кадра еще есть данные,
+// за ним могут быть зафиксированные транзакции, и молча отрезать их нельзя.
+func replay(f *os.File, apply func(rs []record) error) (int64, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	br := bufio.NewReader(f)
+	var off int64
+	for {
+		rs, n, err := readFrame(br)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, errBadFrame) {
+			if off+n < fi.Size() {
+				return 0, fmt.Errorf("%w: bad frame at offset %d followed by %d bytes", errCorrupt, off, fi.Size()-off-n)
+			}
+			if err := f.Truncate(off); err != nil {
+				return 0, fmt.Errorf("truncate wal tail: %w", err)
+			}
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if err := apply(rs); err != nil {
+			return 0, err
+		}
+		off += n
+	}
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return off, nil
+}
@@ -0,0 +1,98 @@
+package operatormemstore
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/operator"
+)
+
+var _ operator.OperatorStore = &Operators{}
+
+// Operators операторы в памяти, ключ мапы логин
+type Operators struct {
+	sync.Mutex
+	m map[string]operator.Operator
+}
+
+func NewOperators() *Operators {
+	return &Operators{
+		m: make(map[string]operator.Operator),
+	}
+}
+
+func (ops *Operators) Create(ctx context.Context, o operator.Operator) error {
+	ops.Lock()
+	defer ops.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if _, ok := ops.m[o.Login]; ok {
+		return operator.ErrExists
+	}
+	ops.m[o.Login] = o
+	return nil
+}
+
+func (ops *Operators) Read(ctx context.Context, login string) (*operator.Operator, error) {
+	ops.Lock()
+	defer ops.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	o, ok := ops.m[login]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &o, nil
+}
+
+// Update меняет оператора через f под локом
+func (ops *Operators) Update(ctx context.Context, login string, f func(o *operator.Operator)) error {
+	ops.Lock()
+	defer ops.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	o, ok := ops.m[login]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	f(&o)
+	o.Login = login
+	ops.m[login] = o
+	return nil
+}
+
+// List возвращает операторов отсортированными по логину
+func (ops *Operators) List(ctx context.Context) ([]operator.Operator, error) {
+	ops.Lock()
+	defer ops.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	list := make([]operator.Operator, 0, len(ops.m))
+	for _, o := range ops.m {
+		list = append(list, o)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Login < list[j].Login })
+	return list, nil
+}
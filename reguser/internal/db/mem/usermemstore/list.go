@@ -0,0 +1,87 @@
+package usermemstore
+
+import (
+	"context"
+	"sort"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+)
+
+// order отсортированный срез ключей одного порядка списка. Страница находится бинарным поиском
+// за O(log n) плюс размер страницы. Вставка и удаление сдвигают хвост среза, это memmove,
+// на сотнях тысяч пользователей это дешевле, чем держать дерево.
+type order struct {
+	sort string
+	keys []user.ListKey
+}
+
+// search первая позиция, ключ на которой не меньше k
+func (o *order) search(k user.ListKey) int {
+	return sort.Search(len(o.keys), func(i int) bool {
+		return user.CompareKeys(o.sort, o.keys[i], k) >= 0
+	})
+}
+
+func (o *order) insert(k user.ListKey) {
+	i := o.search(k)
+	o.keys = append(o.keys, user.ListKey{})
+	copy(o.keys[i+1:], o.keys[i:])
+	o.keys[i] = k
+}
+
+func (o *order) delete(k user.ListKey) {
+	i := o.search(k)
+	if i < len(o.keys) && o.keys[i] == k {
+		o.keys = append(o.keys[:i], o.keys[i+1:]...)
+	}
+}
+
+// page ключи страницы по q
+func (o *order) page(q user.ListQuery) []user.ListKey {
+	ks := make([]user.ListKey, 0, q.Limit)
+	if !q.Desc {
+		i := 0
+		if q.After != nil {
+			i = o.search(*q.After)
+			if i < len(o.keys) && o.keys[i] == *q.After {
+				i++
+			}
+		}
+		for ; i < len(o.keys) && len(ks) < q.Limit; i++ {
+			ks = append(ks, o.keys[i])
+		}
+		return ks
+	}
+	// в обратном порядке идем от последнего ключа меньше After
+	i := len(o.keys) - 1
+	if q.After != nil {
+		i = o.search(*q.After) - 1
+	}
+	for ; i >= 0 && len(ks) < q.Limit; i-- {
+		ks = append(ks, o.keys[i])
+	}
+	return ks
+}
+
+// ListUsers страница по индексу нужного порядка, карточки копируются под локом
+func (us *Users) ListUsers(ctx context.Context, q user.ListQuery) ([]user.User, error) {
+	us.Lock()
+	defer us.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	o := us.byID
+	if q.Sort == user.SortByName {
+		o = us.byName
+	}
+	ks := o.page(q)
+	users := make([]user.User, 0, len(ks))
+	for _, k := range ks {
+		users = append(users, us.m[k.ID])
+	}
+	return users, nil
+}
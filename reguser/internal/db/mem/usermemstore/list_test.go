@@ -0,0 +1,25 @@
+package usermemstore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+)
+
+// BenchmarkListUsers страница из середины списка, время не должно расти с числом пользователей
+func BenchmarkListUsers(b *testing.B) {
+	for _, n := range []int{1000, 100000} {
+		us := fillUsers(b, n)
+		after := user.KeyOf(us.m[us.byName.keys[n/2].ID])
+		q := user.ListQuery{Sort: user.SortByName, After: &after, Limit: 50}
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := us.ListUsers(context.Background(), q); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package usermemstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/google/uuid"
+)
+
+func fillUsers(b *testing.B, n int) *Users {
+	b.Helper()
+	r := rand.New(rand.NewSource(1))
+	us := NewUsers()
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("user%x", r.Int63())
+		if _, err := us.Create(context.Background(), user.User{ID: uuid.New(), Name: name}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return us
+}
+
+// scan прежний поиск полным перебором мапы, для сравнения с индексом
+func (us *Users) scan(s string) []user.User {
+	us.Lock()
+	defer us.Unlock()
+	found := []user.User{}
+	for _, u := range us.m {
+		if strings.Contains(u.Name, s) {
+			found = append(found, u)
+		}
+	}
+	return found
+}
+
+func BenchmarkSearch(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000} {
+		us := fillUsers(b, n)
+		b.Run(fmt.Sprintf("index/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_ = us.match(user.NameContains("abc1"))
+			}
+		})
+		b.Run(fmt.Sprintf("scan/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_ = us.scan("abc1")
+			}
+		})
+	}
+}
+
+func TestUsers_SearchUsers(t *testing.T) {
+	ctx := context.Background()
+	us := NewUsers()
+	a := user.User{ID: uuid.New(), Name: "ivan"}
+	b := user.User{ID: uuid.New(), Name: "ivanov"}
+	for _, u := range []user.User{a, b} {
+		if _, err := us.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	name := "petrov"
+	if _, err := us.Patch(ctx, b.ID, user.UserPatch{Name: &name}); err != nil {
+		t.Fatal(err)
+	}
+
+	ch, err := us.SearchUsers(ctx, user.NameContains("iv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []user.User{}
+	for f := range ch {
+		got = append(got, f.User)
+	}
+	if len(got) != 1 || got[0].ID != a.ID {
+		t.Errorf("search iv: %+v", got)
+	}
+}
+
+// TestUsers_SearchSlowReader читатель, который не забирает результаты, получает ошибку последним элементом,
+// а не выдачу с молча пропущенными пользователями
+func TestUsers_SearchSlowReader(t *testing.T) {
+	defer func(d time.Duration) { sendTimeout = d }(sendTimeout)
+	sendTimeout = 10 * time.Millisecond
+
+	ctx := context.Background()
+	us := NewUsers()
+	const n = 150
+	for i := 0; i < n; i++ {
+		if _, err := us.Create(ctx, user.User{ID: uuid.New(), Name: fmt.Sprintf("user%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ch, err := us.SearchUsers(ctx, user.NameContains("user"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * sendTimeout)
+
+	var got []user.Found
+	for f := range ch {
+		got = append(got, f)
+	}
+	if len(got) == 0 || len(got) > n {
+		t.Fatalf("got %d results", len(got))
+	}
+	if err := got[len(got)-1].Err; !errors.Is(err, ErrSlowReader) {
+		t.Errorf("last result error %v", err)
+	}
+	for _, f := range got[:len(got)-1] {
+		if f.Err != nil {
+			t.Errorf("error before the end: %v", f.Err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package usermemstore
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/google/uuid"
+)
+
+var _ user.UserTx = &Tx{}
+
+// Tx транзакция над хранилищем в памяти. Держит мьютекс хранилища от Begin до Commit или Rollback,
+// поэтому транзакции выполняются строго по очереди, а остальные запросы ждут ее завершения.
+// Для отката запоминаем исходное состояние каждой затронутой записи (undo log),
+// чтобы не копировать всю мапу на каждую транзакцию.
+type Tx struct {
+	us   *Users
+	undo map[uuid.UUID]*user.User
+	done bool
+}
+
+// Begin начинает транзакцию. Ее обязательно нужно завершить через Commit или Rollback,
+// иначе хранилище останется залоченным, поэтому Rollback удобно вызывать в defer.
+func (us *Users) Begin(ctx context.Context) (user.UserTx, error) {
+	us.Lock()
+
+	select {
+	case <-ctx.Done():
+		us.Unlock()
+		return nil, ctx.Err()
+	default:
+	}
+
+	return &Tx{
+		us:   us,
+		undo: make(map[uuid.UUID]*user.User),
+	}, nil
+}
+
+// remember сохраняет состояние записи до первого изменения в транзакции, nil означает что записи не было
+func (tx *Tx) remember(uid uuid.UUID) {
+	if _, ok := tx.undo[uid]; ok {
+		return
+	}
+	if u, ok := tx.us.m[uid]; ok {
+		tx.undo[uid] = &u
+		return
+	}
+	tx.undo[uid] = nil
+}
+
+// check проверяет что транзакция еще активна и контекст не прерван
+func (tx *Tx) check(ctx context.Context) error {
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+	return nil
+}
+
+func (tx *Tx) Create(ctx context.Context, u user.User) (*uuid.UUID, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+	tx.remember(u.ID)
+	return tx.us.create(u)
+}
+
+func (tx *Tx) Read(ctx context.Context, uid uuid.UUID) (*user.User, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+	return tx.us.read(uid)
+}
+
+func (tx *Tx) Update(ctx context.Context, u user.User) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+	tx.remember(u.ID)
+	return tx.us.update(u)
+}
+
+func (tx *Tx) Patch(ctx context.Context, uid uuid.UUID, p user.UserPatch) (*user.User, error) {
+	if err := tx.check(ctx); err != nil {
+		return nil, err
+	}
+	tx.remember(uid)
+	return tx.us.patch(uid, p)
+}
+
+func (tx *Tx) Delete(ctx context.Context, uid uuid.UUID) error {
+	if err := tx.check(ctx); err != nil {
+		return err
+	}
+	tx.remember(uid)
+	return tx.us.delete(uid)
+}
+
+// Commit фиксирует изменения, они уже лежат в мапе, остается только отпустить лок
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	tx.done = true
+	tx.undo = nil
+	tx.us.Unlock()
+	return nil
+}
+
+// Rollback возвращает затронутые записи в исходное состояние.
+// После Commit ничего не делает, чтобы его можно было безопасно вызывать в defer.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return nil
+	}
+	tx.done = true
+	for uid, u := range tx.undo {
+		if u == nil {
+			tx.us.remove(uid)
+			continue
+		}
+		tx.us.put(*u)
+	}
+	tx.undo = nil
+	tx.us.Unlock()
+	return nil
+}
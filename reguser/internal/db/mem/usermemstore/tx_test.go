@@ -0,0 +1,76 @@
+package usermemstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/google/uuid"
+)
+
+func TestTx_Rollback(t *testing.T) {
+	ctx := context.Background()
+	us := NewUsers()
+	kept := user.User{ID: uuid.New(), Name: "kept"}
+	if _, err := us.Create(ctx, kept); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := us.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added := user.User{ID: uuid.New(), Name: "added"}
+	if _, err := tx.Create(ctx, added); err != nil {
+		t.Fatal(err)
+	}
+	name := "changed"
+	if _, err := tx.Patch(ctx, kept.ID, user.UserPatch{Name: &name}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Delete(ctx, kept.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := us.Read(ctx, added.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("created user is not rolled back: %v", err)
+	}
+	u, err := us.Read(ctx, kept.ID)
+	if err != nil {
+		t.Fatalf("deleted user is not restored: %v", err)
+	}
+	if u.Name != "kept" {
+		t.Errorf("patched user is not restored: %q", u.Name)
+	}
+}
+
+func TestTx_Commit(t *testing.T) {
+	ctx := context.Background()
+	us := NewUsers()
+
+	tx, err := us.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := user.User{ID: uuid.New(), Name: "user"}
+	if _, err := tx.Create(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Errorf("rollback after commit: %v", err)
+	}
+	if err := tx.Commit(); !errors.Is(err, sql.ErrTxDone) {
+		t.Errorf("second commit: %v", err)
+	}
+	if _, err := us.Read(ctx, u.ID); err != nil {
+		t.Errorf("committed user not found: %v", err)
+	}
+}
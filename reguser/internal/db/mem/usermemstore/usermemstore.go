@@ -3,30 +3,84 @@ package usermemstore
 import (
 	"context"
 	"database/sql"
-	"strings"
+	"errors"
+	"fmt"
 	"sync"
 	"time"
 
 	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/audetv/hex-ecample/reguser/internal/libs/fuzzy"
+	"github.com/audetv/hex-ecample/reguser/internal/libs/suffixtree"
 	"github.com/google/uuid"
 )
 
 // Для проверки, что соответствует интерфейсу юзер бизнес логики
 var _ user.UserStore = &Users{}
+var _ user.NamePolicyStore = &Users{}
+
+// ErrSlowReader читатель выдачи поиска слишком долго не забирал результаты, выдача оборвана
+var ErrSlowReader = errors.New("search reader is too slow")
+
+// sendTimeout сколько поиск ждет, пока читатель заберет очередного пользователя
+var sendTimeout = 2 * time.Second
 
 // Users коллекция. Защитим мьютексом, так к этой коллекции могут обращаться
 // из разных запросов внешних, а они могут приходить параллельно,
+// names суффиксное дерево по именам для поиска по подстроке, folded такое же по нормализованным именам,
+// byID и byName порядки для постраничного списка, unique уникальный индекс имен по ключу policy,
+// все индексы меняются вместе с мапой.
 type Users struct {
 	sync.Mutex
-	m map[uuid.UUID]user.User
+	m      map[uuid.UUID]user.User
+	names  *suffixtree.Tree
+	folded *suffixtree.Tree
+	byID   *order
+	byName *order
+	policy user.NamePolicy
+	unique map[string]uuid.UUID
 }
 
+// NewUsers пустое хранилище, имена не обязаны быть уникальными, см. SetNamePolicy
 func NewUsers() *Users {
 	return &Users{
-		m: make(map[uuid.UUID]user.User),
+		m:      make(map[uuid.UUID]user.User),
+		names:  suffixtree.New(),
+		folded: suffixtree.New(),
+		byID:   &order{sort: user.SortByID},
+		byName: &order{sort: user.SortByName},
+		policy: user.NamesAny,
+		unique: make(map[string]uuid.UUID),
 	}
 }
 
+// SetNamePolicy включает уникальность имен по политике p и строит индекс по уже сохраненным пользователям.
+// Если среди них уже есть одинаковые имена, возвращает ошибку с user.ErrNameTaken и политику не меняет.
+// После этого Create, Update и Patch с занятым именем возвращают user.ErrNameTaken.
+func (us *Users) SetNamePolicy(ctx context.Context, p user.NamePolicy) error {
+	us.Lock()
+	defer us.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	unique := make(map[string]uuid.UUID)
+	for _, u := range us.m {
+		k, ok := p.Key(u.Name)
+		if !ok {
+			continue
+		}
+		if _, dup := unique[k]; dup {
+			return fmt.Errorf("%w: %q", user.ErrNameTaken, u.Name)
+		}
+		unique[k] = u.ID
+	}
+	us.policy, us.unique = p, unique
+	return nil
+}
+
 func (us *Users) Create(ctx context.Context, u user.User) (*uuid.UUID, error) {
 	us.Lock()
 	defer us.Unlock()
@@ -39,8 +93,7 @@ func (us *Users) Create(ctx context.Context, u user.User) (*uuid.UUID, error) {
 	default:
 	}
 
-	us.m[u.ID] = u
-	return &u.ID, nil
+	return us.create(u)
 }
 func (us *Users) Read(ctx context.Context, uid uuid.UUID) (*user.User, error) {
 	us.Lock()
@@ -53,13 +106,35 @@ func (us *Users) Read(ctx context.Context, uid uuid.UUID) (*user.User, error) {
 	default:
 	}
 
-	u, ok := us.m[uid]
-	if ok {
-		return &u, nil
+	return us.read(uid)
+}
+
+// Update заменяет карточку целиком, если пользователя нет - sql.ErrNoRows, если u.Version не та - user.ErrVersionMismatch
+func (us *Users) Update(ctx context.Context, u user.User) error {
+	us.Lock()
+	defer us.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
 	}
-	// Если ничего не найдено, то нил и для красоты типизированная ошибка,
-	// которую можно проверять на равенство sql.ErrNoRows
-	return nil, sql.ErrNoRows
+
+	return us.update(u)
+}
+
+// Patch читает и меняет карточку под одним локом, чтобы между чтением и записью никто не вклинился
+func (us *Users) Patch(ctx context.Context, uid uuid.UUID, p user.UserPatch) (*user.User, error) {
+	us.Lock()
+	defer us.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	return us.patch(uid, p)
 }
 
 // Delete не возвращает ошибку если не нашли
@@ -73,10 +148,131 @@ func (us *Users) Delete(ctx context.Context, uid uuid.UUID) error {
 	default:
 	}
 
-	delete(us.m, uid)
+	return us.delete(uid)
+}
+
+// Дальше операции над мапой без блокировки, их вызывают публичные методы после лока
+// и транзакция, которая держит лок все время своей жизни.
+
+// taken имя u уже занято другим пользователем
+func (us *Users) taken(u user.User) bool {
+	k, ok := us.policy.Key(u.Name)
+	if !ok {
+		return false
+	}
+	id, ok := us.unique[k]
+	return ok && id != u.ID
+}
+
+// unindex убирает имя из уникального индекса, если оно записано за этим пользователем.
+// При откате транзакции имя может быть уже переписано на другого, тогда его не трогаем.
+func (us *Users) unindex(u user.User) {
+	if k, ok := us.policy.Key(u.Name); ok && us.unique[k] == u.ID {
+		delete(us.unique, k)
+	}
+}
+
+// put кладет карточку в мапу и поддерживает индексы. Занятость имени проверяют до put,
+// сам put индекс уникальности не проверяет, чтобы откат транзакции всегда проходил.
+func (us *Users) put(u user.User) {
+	old, ok := us.m[u.ID]
+	us.m[u.ID] = u
+	if ok && old.Name == u.Name {
+		return
+	}
+	if ok {
+		us.unindex(old)
+	}
+	if k, ok := us.policy.Key(u.Name); ok {
+		us.unique[k] = u.ID
+	}
+	if ok {
+		us.names.Delete(old.ID, old.Name)
+		us.folded.Delete(old.ID, fuzzy.Normalize(old.Name))
+		us.byName.delete(user.KeyOf(old))
+	} else {
+		us.byID.insert(user.KeyOf(u))
+	}
+	us.names.Insert(u.ID, u.Name)
+	us.folded.Insert(u.ID, fuzzy.Normalize(u.Name))
+	us.byName.insert(user.KeyOf(u))
+}
+
+// remove удаляет карточку из мапы и из индексов
+func (us *Users) remove(uid uuid.UUID) {
+	if old, ok := us.m[uid]; ok {
+		us.unindex(old)
+		us.names.Delete(old.ID, old.Name)
+		us.folded.Delete(old.ID, fuzzy.Normalize(old.Name))
+		us.byID.delete(user.KeyOf(old))
+		us.byName.delete(user.KeyOf(old))
+		delete(us.m, uid)
+	}
+}
+
+func (us *Users) create(u user.User) (*uuid.UUID, error) {
+	if u.Version == 0 {
+		u.Version = 1
+	}
+	if us.taken(u) {
+		return nil, user.ErrNameTaken
+	}
+	us.put(u)
+	return &u.ID, nil
+}
+
+func (us *Users) read(uid uuid.UUID) (*user.User, error) {
+	u, ok := us.m[uid]
+	if ok {
+		return &u, nil
+	}
+	// Если ничего не найдено, то нил и для красоты типизированная ошибка,
+	// которую можно проверять на равенство sql.ErrNoRows
+	return nil, sql.ErrNoRows
+}
+
+func (us *Users) update(u user.User) error {
+	old, ok := us.m[u.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	// compare-and-swap: проверка версии и запись идут под одним локом
+	if u.Version != 0 && u.Version != old.Version {
+		return user.ErrVersionMismatch
+	}
+	if us.taken(u) {
+		return user.ErrNameTaken
+	}
+	u.Version = old.Version + 1
+	us.put(u)
 	return nil
 }
-func (us *Users) SearchUsers(ctx context.Context, s string) (chan user.User, error) {
+
+func (us *Users) patch(uid uuid.UUID, p user.UserPatch) (*user.User, error) {
+	u, ok := us.m[uid]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	if p.Version != 0 && p.Version != u.Version {
+		return nil, user.ErrVersionMismatch
+	}
+	p.Apply(&u)
+	if us.taken(u) {
+		return nil, user.ErrNameTaken
+	}
+	u.Version++
+	us.put(u)
+	return &u, nil
+}
+
+func (us *Users) delete(uid uuid.UUID) error {
+	us.remove(uid)
+	return nil
+}
+
+// SearchUsers фильтры не переводятся ни во что, каждый кандидат проверяется через q.Match,
+// а кандидатов, где можно, сужает индекс по именам.
+func (us *Users) SearchUsers(ctx context.Context, q user.Query) (chan user.Found, error) {
 	us.Lock()
 	defer us.Unlock()
 
@@ -86,10 +282,8 @@ func (us *Users) SearchUsers(ctx context.Context, s string) (chan user.User, err
 	default:
 	}
 
-	// FIXME: переделать на дерево остатков
-
 	// Мы будем просто проходить мапу, чтобы пройти надо создать канал, мы же возвращаем канал.
-	chout := make(chan user.User, 100)
+	chout := make(chan user.Found, 100)
 	// прежде чем вернуть канал, надо запустить горутину, в которой будем опять лочиться,
 	// после лока должны будем перебрать мапу
 	// Если по контексту прервали обработку и выходим, то в этом случае дефер закрывает канал
@@ -103,23 +297,75 @@ func (us *Users) SearchUsers(ctx context.Context, s string) (chan user.User, err
 	// Чтобы это заработало, мы отправку должны поместить внутрь селекта
 	// На стороне бизнес логики нельзя закрывать канал chout, потому что мы в него здесь пишем,
 	// будет паника, поэтому нужен отдельный сигнальный канал.
+	// Совпадения ищем по суффиксному дереву и копируем под локом, а отправляем уже без лока,
+	// чтобы медленный читатель не держал все хранилище.
+	// Если читатель не забирает очередного пользователя дольше sendTimeout, выдача обрывается ошибкой
+	// ErrSlowReader, а не пропускает пользователя молча: иначе клиент принял бы неполный результат за полный.
 	go func() {
 		defer close(chout)
-		us.Lock()
-		defer us.Unlock()
-		for _, u := range us.m {
-			if strings.Contains(u.Name, s) {
+		found := us.match(q)
+		for _, u := range found {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(sendTimeout):
 				select {
 				case <-ctx.Done():
-					return
-				case <-time.After(2 * time.Second):
-
-				case chout <- u:
+				case chout <- user.Found{Err: ErrSlowReader}:
 				}
-
+				return
+			case chout <- user.Found{User: u}:
 			}
 		}
-
 	}()
 	return chout, nil
 }
+
+// match возвращает копии пользователей, подходящих под запрос.
+// Если в запросе есть обязательное условие на подстроку имени, кандидатов берем из суффиксного дерева,
+// иначе перебираем всех.
+func (us *Users) match(q user.Query) []user.User {
+	us.Lock()
+	defer us.Unlock()
+
+	if t, ok := nameTerm(q); ok {
+		tree := us.names
+		if t.Fold || t.Op == user.OpWordPrefix {
+			tree = us.folded
+		}
+		ids := tree.Search(t.Value)
+		found := make([]user.User, 0, len(ids))
+		for _, id := range ids {
+			if u := us.m[id]; q.Match(u) {
+				found = append(found, u)
+			}
+		}
+		return found
+	}
+	found := make([]user.User, 0)
+	for _, u := range us.m {
+		if q.Match(u) {
+			found = append(found, u)
+		}
+	}
+	return found
+}
+
+// nameTerm условие на подстроку или префикс имени, без которого запрос не выполняется.
+// Префикс тоже подстрока, так что дерево дает всех кандидатов, а Match отсеет лишних.
+// Нечеткое сравнение деревом не сузить, тогда перебор.
+func nameTerm(q user.Query) (user.Term, bool) {
+	switch q := q.(type) {
+	case user.Term:
+		if q.Field == user.FieldName && q.Value != "" && q.Op != user.OpFuzzy {
+			return q, true
+		}
+	case user.And:
+		for _, c := range q {
+			if t, ok := nameTerm(c); ok {
+				return t, true
+			}
+		}
+	}
+	return user.Term{}, false
+}
@@ -0,0 +1,12 @@
+package usermemstore
+
+import (
+	"testing"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/audetv/hex-ecample/reguser/internal/db/storetest"
+)
+
+func TestUsers(t *testing.T) {
+	storetest.Run(t, func() user.UserStore { return NewUsers() })
+}
@@ -0,0 +1,48 @@
+package pgstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+)
+
+// ListUsers keyset пагинация: страница начинается строго после ключа After, а не со смещения,
+// поэтому вставки между запросами страниц не сдвигают и не дублируют строки.
+func (us *Users) ListUsers(ctx context.Context, q user.ListQuery) ([]user.User, error) {
+	op, dir := ">", "ASC"
+	if q.Desc {
+		op, dir = "<", "DESC"
+	}
+	args := []interface{}{q.Limit}
+	where := ""
+	order := fmt.Sprintf(`id %s`, dir)
+	if q.Sort == user.SortByName {
+		order = fmt.Sprintf(`name COLLATE "C" %s, id %s`, dir, dir)
+		if q.After != nil {
+			where = fmt.Sprintf(`WHERE (name COLLATE "C", id) %s ($2::text COLLATE "C", $3::uuid)`, op)
+			args = append(args, q.After.Name, q.After.ID)
+		}
+	} else if q.After != nil {
+		where = fmt.Sprintf(`WHERE id %s $2`, op)
+		args = append(args, q.After.ID)
+	}
+
+	rows, err := us.db.QueryContext(ctx,
+		`SELECT id, name, data, permissions, version FROM users `+where+` ORDER BY `+order+` LIMIT $1`, args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]user.User, 0, q.Limit)
+	for rows.Next() {
+		u := user.User{}
+		if err := rows.Scan(&u.ID, &u.Name, &u.Data, &u.Permissions, &u.Version); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
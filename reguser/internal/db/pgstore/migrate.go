@@ -0,0 +1,148 @@
+package pgstore
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/audetv/hex-ecample/reguser/internal/libs/fuzzy"
+)
+
+// Миграции схемы лежат в migrations и вшиваются в бинарник, применяются по порядку имен файлов.
+// Примененные версии запоминаем в schema_migrations, поэтому каждая миграция выполняется один раз.
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migrationLock ключ advisory lock, чтобы несколько экземпляров сервиса не мигрировали одновременно
+const migrationLock = 7346110
+
+// backfills шаги на Go для миграций, которые нельзя выразить в SQL, выполняются в той же транзакции
+// сразу после миграции с тем же именем
+var backfills = map[string]func(ctx context.Context, tx *sql.Tx) error{
+	"0004_users_norm": normalizeAll,
+}
+
+type migration struct {
+	version string
+	sql     string
+}
+
+func migrations() ([]migration, error) {
+	names, err := fs.Glob(migrationsFS, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	ms := make([]migration, 0, len(names))
+	for _, name := range names {
+		b, err := migrationsFS.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, migration{
+			version: strings.TrimSuffix(strings.TrimPrefix(name, "migrations/"), ".sql"),
+			sql:     string(b),
+		})
+	}
+	return ms, nil
+}
+
+// Migrate применяет недостающие миграции, все в одной транзакции
+func Migrate(ctx context.Context, db *sql.DB) error {
+	ms, err := migrations()
+	if err != nil {
+		return fmt.Errorf("read migrations: %w", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLock); err != nil {
+		return fmt.Errorf("lock migrations: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    text PRIMARY KEY,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	applied := map[string]bool{}
+	rows, err := tx.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[v] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range ms {
+		if applied[m.version] {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+			return fmt.Errorf("migration %s: %w", m.version, err)
+		}
+		if f := backfills[m.version]; f != nil {
+			if err := f(ctx, tx); err != nil {
+				return fmt.Errorf("migration %s: %w", m.version, err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, m.version); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// readAll все карточки без прав и версий, для пересчета производных колонок
+func readAll(ctx context.Context, tx *sql.Tx) ([]user.User, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id, name, data FROM users`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []user.User
+	for rows.Next() {
+		u := user.User{}
+		if err := rows.Scan(&u.ID, &u.Name, &u.Data); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// normalizeAll заполняет name_norm и data_norm у всех карточек
+func normalizeAll(ctx context.Context, tx *sql.Tx) error {
+	users, err := readAll(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		_, err := tx.ExecContext(ctx, `UPDATE users SET name_norm = $2, data_norm = $3 WHERE id = $1`,
+			u.ID, fuzzy.Normalize(u.Name), fuzzy.Normalize(u.Data))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,6 @@
+CREATE TABLE users (
+    id          uuid PRIMARY KEY,
+    name        text    NOT NULL,
+    data        text    NOT NULL DEFAULT '',
+    permissions integer NOT NULL DEFAULT 0
+);
@@ -0,0 +1,3 @@
+-- Порядок для постраничного списка по имени. Имена сравниваются побайтно, как и в остальных хранилищах,
+-- поэтому индекс и запросы используют COLLATE "C", а не сортировку локали базы.
+CREATE INDEX users_name_id ON users (name COLLATE "C", id);
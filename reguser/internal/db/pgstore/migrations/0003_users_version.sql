@@ -0,0 +1,2 @@
+-- Версия карточки для optimistic concurrency, у существующих пользователей первая версия
+ALTER TABLE users ADD COLUMN version bigint NOT NULL DEFAULT 1;
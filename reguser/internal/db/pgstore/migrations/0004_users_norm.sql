@@ -0,0 +1,5 @@
+-- Имя и данные после fuzzy.Normalize для поиска без учета регистра и диакритики, их пишет стор.
+-- Нормализации как в Go в PostgreSQL нет, поэтому у существующих пользователей поля заполняет шаг на Go, см. backfills.
+ALTER TABLE users
+    ADD COLUMN name_norm text NOT NULL DEFAULT '',
+    ADD COLUMN data_norm text NOT NULL DEFAULT '';
@@ -0,0 +1,10 @@
+-- Ключ имени по политике уникальности (user.NamePolicy.Key), NULL если политика имена не ограничивает.
+-- Ключи пишет стор, пересчитывает их SetNamePolicy при смене политики, см. settings.
+ALTER TABLE users ADD COLUMN name_key text;
+CREATE UNIQUE INDEX users_name_key ON users (name_key);
+
+-- Настройки хранилища, которые должны пережить перезапуск: unique_names политика, по которой посчитаны name_key
+CREATE TABLE settings (
+    key   text PRIMARY KEY,
+    value text NOT NULL
+);
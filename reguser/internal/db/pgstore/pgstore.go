@@ -0,0 +1,343 @@
+// Package pgstore хранилище пользователей в PostgreSQL через database/sql.
+package pgstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/audetv/hex-ecample/reguser/internal/libs/fuzzy"
+	"github.com/google/uuid"
+	// драйвер регистрируется в database/sql под именем postgres
+	"github.com/lib/pq"
+)
+
+var _ user.UserStore = &Users{}
+var _ user.NamePolicyStore = &Users{}
+
+// fetchSize сколько строк за раз забираем из курсора при поиске
+const fetchSize = 100
+
+// Users хранилище поверх пула соединений, names политика уникальности имен, см. SetNamePolicy
+type Users struct {
+	db    *sql.DB
+	names user.NamePolicy
+}
+
+// NewUsers подключается по dsn, проверяет соединение и применяет миграции.
+// После работы хранилище нужно закрыть через Close.
+func NewUsers(ctx context.Context, dsn string) (*Users, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("ping postgres: %w", err)
+	}
+	if err := Migrate(ctx, db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &Users{db: db, names: user.NamesAny}, nil
+}
+
+// SetNamePolicy включает уникальность имен по политике p: ключ имени пишется в name_key под уникальным индексом.
+// Политика запоминается в базе, ключи всех карточек пересчитываются, только если она поменялась.
+// Если среди карточек уже есть одинаковые по новой политике имена, возвращает ошибку с user.ErrNameTaken
+// и ничего не меняет. Вызывать до начала работы с хранилищем.
+func (us *Users) SetNamePolicy(ctx context.Context, p user.NamePolicy) error {
+	tx, err := us.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// несколько экземпляров сервиса не должны пересчитывать ключи одновременно
+	if _, err := tx.ExecContext(ctx, `LOCK TABLE settings IN EXCLUSIVE MODE`); err != nil {
+		return err
+	}
+	cur := string(user.NamesAny)
+	err = tx.QueryRowContext(ctx, `SELECT value FROM settings WHERE key = 'unique_names'`).Scan(&cur)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if user.NamePolicy(cur) != p {
+		if err := rekey(ctx, tx, p); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO settings (key, value) VALUES ('unique_names', $1) ON CONFLICT (key) DO UPDATE SET value = excluded.value`,
+			string(p))
+		if err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	us.names = p
+	return nil
+}
+
+// rekey пересчитывает ключи имен всех карточек по политике p. Старые ключи сначала стираются,
+// чтобы они не мешали новым, а совпадение новых ключей уникальный индекс вернет ошибкой.
+func rekey(ctx context.Context, tx *sql.Tx, p user.NamePolicy) error {
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET name_key = NULL`); err != nil {
+		return err
+	}
+	users, err := readAll(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		k, ok := p.Key(u.Name)
+		if !ok {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE users SET name_key = $2 WHERE id = $1`, u.ID, k); err != nil {
+			if nameTaken(err) {
+				return fmt.Errorf("%w: %q", user.ErrNameTaken, u.Name)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// nameTaken нарушен уникальный индекс по ключу имени
+func nameTaken(err error) bool {
+	e := &pq.Error{}
+	return errors.As(err, &e) && e.Code == "23505" && e.Constraint == "users_name_key"
+}
+
+// nameKey ключ имени для колонки name_key, NULL если политика имена не ограничивает
+func nameKey(p user.NamePolicy, name string) interface{} {
+	if k, ok := p.Key(name); ok {
+		return k
+	}
+	return nil
+}
+
+// Close закрывает пул соединений
+func (us *Users) Close() error {
+	return us.db.Close()
+}
+
+// querier общее у *sql.DB и *sql.Tx, чтобы одни и те же запросы работали и в транзакции и без нее
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// create, update и patch пишут ключ имени по политике names, занятое имя возвращают как user.ErrNameTaken
+func create(ctx context.Context, q querier, names user.NamePolicy, u user.User) (*uuid.UUID, error) {
+	if u.Version == 0 {
+		u.Version = 1
+	}
+	var id uuid.UUID
+	err := q.QueryRowContext(ctx,
+		`INSERT INTO users (id, name, data, permissions, version, name_norm, data_norm, name_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		u.ID, u.Name, u.Data, u.Permissions, u.Version, fuzzy.Normalize(u.Name), fuzzy.Normalize(u.Data),
+		nameKey(names, u.Name),
+	).Scan(&id)
+	if nameTaken(err) {
+		return nil, user.ErrNameTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// read если строки нет, Scan вернет sql.ErrNoRows, который бизнес логика и хэндлеры уже понимают
+func read(ctx context.Context, q querier, uid uuid.UUID) (*user.User, error) {
+	u := user.User{}
+	err := q.QueryRowContext(ctx,
+		`SELECT id, name, data, permissions, version FROM users WHERE id = $1`, uid,
+	).Scan(&u.ID, &u.Name, &u.Data, &u.Permissions, &u.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// missing почему UPDATE по id и версии не нашел строку: строки нет или версия другая
+func missing(ctx context.Context, q querier, uid uuid.UUID) error {
+	var v int64
+	if err := q.QueryRowContext(ctx, `SELECT version FROM users WHERE id = $1`, uid).Scan(&v); err != nil {
+		return err
+	}
+	return user.ErrVersionMismatch
+}
+
+// update compare-and-swap по версии одним запросом, version 0 в u обновляет без проверки
+func update(ctx context.Context, q querier, names user.NamePolicy, u user.User) error {
+	res, err := q.ExecContext(ctx,
+		`UPDATE users SET name = $2, data = $3, permissions = $4, version = version + 1,
+		name_norm = $6, data_norm = $7, name_key = $8
+		WHERE id = $1 AND ($5 = 0 OR version = $5)`,
+		u.ID, u.Name, u.Data, u.Permissions, u.Version, fuzzy.Normalize(u.Name), fuzzy.Normalize(u.Data),
+		nameKey(names, u.Name),
+	)
+	if nameTaken(err) {
+		return user.ErrNameTaken
+	}
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return missing(ctx, q, u.ID)
+	}
+	return nil
+}
+
+// normalized fuzzy.Normalize от поля патча, nil если поле не меняется
+func normalized(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	n := fuzzy.Normalize(*s)
+	return &n
+}
+
+// patch меняет только переданные поля одним запросом, поэтому атомарен без явной транзакции
+func patch(ctx context.Context, q querier, names user.NamePolicy, uid uuid.UUID, p user.UserPatch) (*user.User, error) {
+	var key interface{}
+	if p.Name != nil {
+		key = nameKey(names, *p.Name)
+	}
+	u := user.User{}
+	err := q.QueryRowContext(ctx,
+		`UPDATE users SET name = COALESCE($2, name), data = COALESCE($3, data), version = version + 1,
+		name_norm = COALESCE($5, name_norm), data_norm = COALESCE($6, data_norm),
+		name_key = CASE WHEN $7 THEN $8 ELSE name_key END
+		WHERE id = $1 AND ($4 = 0 OR version = $4) RETURNING id, name, data, permissions, version`,
+		uid, p.Name, p.Data, p.Version, normalized(p.Name), normalized(p.Data), p.Name != nil, key,
+	).Scan(&u.ID, &u.Name, &u.Data, &u.Permissions, &u.Version)
+	if nameTaken(err) {
+		return nil, user.ErrNameTaken
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, missing(ctx, q, uid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// remove как и в usermemstore не возвращает ошибку если удалять нечего
+func remove(ctx context.Context, q querier, uid uuid.UUID) error {
+	_, err := q.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, uid)
+	return err
+}
+
+func (us *Users) Create(ctx context.Context, u user.User) (*uuid.UUID, error) {
+	return create(ctx, us.db, us.names, u)
+}
+
+func (us *Users) Read(ctx context.Context, uid uuid.UUID) (*user.User, error) {
+	return read(ctx, us.db, uid)
+}
+
+func (us *Users) Update(ctx context.Context, u user.User) error {
+	return update(ctx, us.db, us.names, u)
+}
+
+func (us *Users) Patch(ctx context.Context, uid uuid.UUID, p user.UserPatch) (*user.User, error) {
+	return patch(ctx, us.db, us.names, uid, p)
+}
+
+func (us *Users) Delete(ctx context.Context, uid uuid.UUID) error {
+	return remove(ctx, us.db, uid)
+}
+
+// SearchUsers объявляет серверный курсор в read only транзакции и забирает из него строки пачками по fetchSize,
+// так в памяти никогда не лежит весь результат. Ошибка объявления курсора возвращается сразу,
+// дальше горутина отдает строки в канал, пока они не кончатся или не отменят контекст.
+// Ошибка чтения курсора на середине уходит в канал последним элементом.
+// Условие запроса переводится в SQL, strpos ищет подстроку как есть, без спецсимволов LIKE, так же как strings.Contains,
+// а без учета регистра и диакритики сравнивает колонки name_norm и data_norm.
+func (us *Users) SearchUsers(ctx context.Context, q user.Query) (chan user.Found, error) {
+	args := []interface{}{}
+	cond, exact := condition(q, &args)
+	// если условие отбирает с запасом, лишних отсеиваем на каждой строке
+	filter := func(u user.User) bool { return exact || q.Match(u) }
+
+	tx, err := us.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx,
+		`DECLARE users_search NO SCROLL CURSOR FOR
+		SELECT id, name, data, permissions, version FROM users WHERE `+cond, args...,
+	)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	chout := make(chan user.Found, fetchSize)
+	go func() {
+		defer close(chout)
+		// курсор живет до конца транзакции, откат его и закроет
+		defer func() { _ = tx.Rollback() }()
+		for {
+			n, err := fetch(ctx, tx, chout, filter)
+			if err != nil {
+				sendErr(ctx, chout, err)
+				return
+			}
+			if n < fetchSize {
+				return
+			}
+		}
+	}()
+	return chout, nil
+}
+
+// fetch забирает очередную пачку строк из курсора и отправляет их в канал, возвращает сколько строк прочитано
+func fetch(ctx context.Context, tx *sql.Tx, chout chan user.Found, filter func(user.User) bool) (int, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`FETCH %d FROM users_search`, fetchSize))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		u := user.User{}
+		if err := rows.Scan(&u.ID, &u.Name, &u.Data, &u.Permissions, &u.Version); err != nil {
+			return n, err
+		}
+		n++
+		if !filter(u) {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return n, ctx.Err()
+		case chout <- user.Found{User: u}:
+		}
+	}
+	return n, rows.Err()
+}
+
+// sendErr отдает ошибку последним элементом выдачи. Если контекст отменен, читать уже некому.
+func sendErr(ctx context.Context, chout chan user.Found, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case chout <- user.Found{Err: err}:
+	}
+}
@@ -0,0 +1,337 @@
+package pgstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/audetv/hex-ecample/reguser/internal/db/storetest"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// testDSN возвращает строку подключения к тестовой базе. Берется из PGSTORE_TEST_DSN,
+// а если переменной нет, запускаем локальный postgres из PATH во временном каталоге на unix сокете,
+// без сети. Если нет ни того ни другого, тест пропускается.
+func testDSN(t *testing.T) string {
+	t.Helper()
+	if dsn := os.Getenv("PGSTORE_TEST_DSN"); dsn != "" {
+		return dsn
+	}
+	initdb, err := exec.LookPath("initdb")
+	if err != nil {
+		t.Skip("PGSTORE_TEST_DSN is not set and postgres is not installed")
+	}
+	postgres, err := exec.LookPath("postgres")
+	if err != nil {
+		t.Skip("PGSTORE_TEST_DSN is not set and postgres is not installed")
+	}
+
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "-A", "trust").CombinedOutput()
+	if err != nil {
+		t.Fatalf("initdb: %v\n%s", err, out)
+	}
+	cmd := exec.Command(postgres, "-D", data, "-k", dir, "-c", "listen_addresses=", "-p", "5432")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Signal(os.Interrupt)
+		_ = cmd.Wait()
+	})
+
+	dsn := fmt.Sprintf("host=%s port=5432 user=postgres dbname=postgres sslmode=disable", dir)
+	for i := 0; i < 50; i++ {
+		if _, err := os.Stat(filepath.Join(dir, ".s.PGSQL.5432")); err == nil {
+			return dsn
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("postgres did not start")
+	return ""
+}
+
+func newTestUsers(t *testing.T) *Users {
+	t.Helper()
+	return openTestUsers(t, testDSN(t))
+}
+
+// openTestUsers подключается к базе и очищает таблицу, чтобы каждый тест начинал с пустого хранилища
+func openTestUsers(t *testing.T, dsn string) *Users {
+	t.Helper()
+	ctx := context.Background()
+	us, err := NewUsers(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.db.ExecContext(ctx, `TRUNCATE users, settings`); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = us.Close() })
+	return us
+}
+
+// TestMigrations вшитые миграции читаются без базы: версии по порядку и без повторов, у каждой есть SQL,
+// а шаги на Go привязаны к существующим миграциям
+func TestMigrations(t *testing.T) {
+	ms, err := migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ms) == 0 || ms[0].version != "0001_create_users" {
+		t.Fatalf("migrations %+v", ms)
+	}
+	versions := map[string]bool{}
+	for i, m := range ms {
+		if i > 0 && m.version <= ms[i-1].version {
+			t.Errorf("%s goes after %s", m.version, ms[i-1].version)
+		}
+		if strings.Contains(m.version, "/") || strings.HasSuffix(m.version, ".sql") {
+			t.Errorf("version %q is not a bare file name", m.version)
+		}
+		if strings.TrimSpace(m.sql) == "" {
+			t.Errorf("%s: empty migration", m.version)
+		}
+		versions[m.version] = true
+	}
+	for v := range backfills {
+		if !versions[v] {
+			t.Errorf("backfill for unknown migration %s", v)
+		}
+	}
+}
+
+// TestCondition перевод запроса в SQL без базы: текст условия, параметры и признак точного условия
+func TestCondition(t *testing.T) {
+	uid := uuid.MustParse("95b9791e-aff3-4432-9624-12a12534e9df")
+	cases := []struct {
+		name  string
+		q     user.Query
+		cond  string
+		args  []interface{}
+		exact bool
+	}{
+		{"all", user.All{}, "TRUE", nil, true},
+		{"contains", user.NameContains("ivan"), "strpos(name, $1) > 0", []interface{}{"ivan"}, true},
+		{"fold", user.Term{Field: user.FieldData, Op: user.OpContains, Value: "moscow", Fold: true},
+			"strpos(data_norm, $1) > 0", []interface{}{"moscow"}, true},
+		{"prefix", user.Term{Field: user.FieldName, Op: user.OpPrefix, Value: "iv"}, "strpos(name, $1) = 1", []interface{}{"iv"}, true},
+		{"word prefix", user.Term{Field: user.FieldName, Op: user.OpWordPrefix, Value: "pet"},
+			"strpos(name_norm, $1) > 0", []interface{}{"pet"}, false},
+		{"fuzzy", user.Term{Field: user.FieldName, Op: user.OpFuzzy, Value: "ivn"}, "TRUE", nil, false},
+		{"id", user.Term{Field: user.FieldID, Op: user.OpEquals, Value: uid.String()}, "id = $1", []interface{}{uid}, true},
+		{"bad id", user.Term{Field: user.FieldID, Op: user.OpEquals, Value: "nope"}, "FALSE", nil, true},
+		{"permissions", user.Term{Field: user.FieldPermissions, Op: user.OpEquals, Mask: 0}, "permissions = $1", []interface{}{0}, true},
+		{"has", user.Term{Field: user.FieldPermissions, Op: user.OpHas, Mask: auth.PermRead},
+			"(permissions & $1) = $1", []interface{}{auth.PermRead}, true},
+		{"and or", user.And{user.NameContains("a"), user.Or{user.NameContains("b"), user.NameContains("c")}},
+			"(strpos(name, $1) > 0 AND (strpos(name, $2) > 0 OR strpos(name, $3) > 0))", []interface{}{"a", "b", "c"}, true},
+		{"not", user.Not{Q: user.NameContains("a")}, "NOT strpos(name, $1) > 0", []interface{}{"a"}, true},
+		// отрицание условия с запасом в SQL не попадает, и его параметры тоже
+		{"not inexact", user.And{
+			user.Not{Q: user.Term{Field: user.FieldName, Op: user.OpWordPrefix, Value: "x"}},
+			user.NameContains("b"),
+		}, "(TRUE AND strpos(name, $1) > 0)", []interface{}{"b"}, false},
+	}
+	for _, c := range cases {
+		args := []interface{}{}
+		cond, exact := condition(c.q, &args)
+		if cond != c.cond || exact != c.exact {
+			t.Errorf("%s: condition %q exact %v, want %q %v", c.name, cond, exact, c.cond, c.exact)
+		}
+		if fmt.Sprint(args) != fmt.Sprint(c.args) || len(args) != len(c.args) {
+			t.Errorf("%s: args %v, want %v", c.name, args, c.args)
+		}
+	}
+}
+
+func TestUsers(t *testing.T) {
+	dsn := testDSN(t)
+	storetest.Run(t, func() user.UserStore { return openTestUsers(t, dsn) })
+}
+
+func TestUsers_Cursor(t *testing.T) {
+	ctx := context.Background()
+	us := newTestUsers(t)
+
+	// миграции идемпотентны
+	if err := Migrate(ctx, us.db); err != nil {
+		t.Fatal(err)
+	}
+
+	a := user.User{ID: uuid.New(), Name: "ivan", Data: "data"}
+	if _, err := us.Create(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < fetchSize+1; i++ {
+		if _, err := us.Create(ctx, user.User{ID: uuid.New(), Name: fmt.Sprintf("petr%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	name := "ivanov"
+	u, err := us.Patch(ctx, a.ID, user.UserPatch{Name: &name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "ivanov" || u.Data != "data" {
+		t.Errorf("patched %+v", u)
+	}
+	if err := us.Update(ctx, user.User{ID: uuid.New()}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("update unknown: %v", err)
+	}
+
+	ch, err := us.SearchUsers(ctx, user.NameContains("petr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for f := range ch {
+		if f.Err != nil {
+			t.Fatal(f.Err)
+		}
+		n++
+	}
+	if n != fetchSize+1 {
+		t.Errorf("found %d users, want %d", n, fetchSize+1)
+	}
+
+	tx, err := us.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Delete(ctx, a.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Read(ctx, a.ID); err != nil {
+		t.Errorf("rolled back delete: %v", err)
+	}
+
+	if err := us.Delete(ctx, a.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Read(ctx, a.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("read deleted: %v", err)
+	}
+}
+
+// TestUsers_SearchPushdown поиск из бизнес логики во всех режимах, кроме нечеткого, фильтрует в базе:
+// условие целиком переводится в SQL, а без учета регистра сравниваются нормализованные колонки.
+func TestUsers_SearchPushdown(t *testing.T) {
+	cases := []struct {
+		q, mode, want string
+		cond          string
+		exact         bool
+	}{
+		{"Ivan", user.ModeExact, "Ivan Petrov", "strpos(name, $1) > 0", true},
+		{"IVAN", user.ModeFold, "Ivan Petrov,ivanov", "strpos(name_norm, $1) > 0", true},
+		{"data:MOSCOW -ivan", user.ModeFold, "Éloïse", "(strpos(data_norm, $1) > 0 AND NOT strpos(name_norm, $2) > 0)", true},
+		{"pet OR data:mos", user.ModePrefix, "Ivan Petrov,ivanov,Éloïse", "(strpos(name_norm, $1) > 0 OR strpos(data_norm, $2) > 0)", false},
+		{"ivan -petrv", user.ModeFuzzy, "ivanov", "(TRUE AND TRUE)", false},
+	}
+	for _, c := range cases {
+		q, err := user.ParseQuery(c.q)
+		if err == nil {
+			q, err = user.ApplyMode(q, c.mode)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		args := []interface{}{}
+		if cond, exact := condition(q, &args); cond != c.cond || exact != c.exact {
+			t.Errorf("%q %s: condition %q exact %v", c.q, c.mode, cond, exact)
+		}
+	}
+
+	ctx := auth.WithPrincipal(context.Background(), &auth.Principal{Permissions: auth.PermSearch})
+	us := newTestUsers(t)
+	for _, name := range []string{"Ivan Petrov", "Éloïse", "ivanov"} {
+		if _, err := us.Create(ctx, user.User{ID: uuid.New(), Name: name, Data: "Moscow"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	users := user.NewUsers(us)
+	for _, c := range cases {
+		ch, err := users.SearchUsers(ctx, c.q, c.mode)
+		if err != nil {
+			t.Fatalf("%q %s: %v", c.q, c.mode, err)
+		}
+		var got []string
+		for su := range ch {
+			if su.Err != nil {
+				t.Fatalf("%q %s: %v", c.q, c.mode, su.Err)
+			}
+			got = append(got, su.Name)
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != c.want {
+			t.Errorf("%q %s: got %v, want %q", c.q, c.mode, got, c.want)
+		}
+	}
+}
+
+func TestNameTaken(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&pq.Error{Code: "23505", Constraint: "users_name_key"}, true},
+		{fmt.Errorf("create: %w", &pq.Error{Code: "23505", Constraint: "users_name_key"}), true},
+		{&pq.Error{Code: "23505", Constraint: "users_pkey"}, false},
+		{&pq.Error{Code: "23503", Constraint: "users_name_key"}, false},
+		{sql.ErrNoRows, false},
+		{nil, false},
+	}
+	for _, c := range cases {
+		if got := nameTaken(c.err); got != c.want {
+			t.Errorf("%v: %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+// TestUsers_UniqueNamesShared политика хранится в базе, второй экземпляр сервиса видит ее без пересчета,
+// а ключи, записанные одним экземпляром, соблюдаются другим
+func TestUsers_UniqueNamesShared(t *testing.T) {
+	ctx := context.Background()
+	dsn := testDSN(t)
+	us := openTestUsers(t, dsn)
+	if err := us.SetNamePolicy(ctx, user.NamesFold); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Create(ctx, user.User{ID: uuid.New(), Name: "Ivan Petrov"}); err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := NewUsers(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err := other.SetNamePolicy(ctx, user.NamesFold); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Create(ctx, user.User{ID: uuid.New(), Name: "ivan  PETROV"}); !errors.Is(err, user.ErrNameTaken) {
+		t.Errorf("second instance: %v", err)
+	}
+	if _, err := other.Create(ctx, user.User{ID: uuid.New(), Name: "Petr"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Create(ctx, user.User{ID: uuid.New(), Name: "PETR"}); !errors.Is(err, user.ErrNameTaken) {
+		t.Errorf("first instance: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package pgstore
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/google/uuid"
+)
+
+// condition переводит дерево запроса в условие WHERE, значения уходят параметрами $n в args.
+// Условия без учета регистра и диакритики сравнивают колонки name_norm и data_norm.
+// exact=false если условие отбирает с запасом: начало слова ищется как подстрока, а нечеткое сравнение
+// в SQL не переводится вовсе, тогда найденных досеивает q.Match.
+func condition(q user.Query, args *[]interface{}) (cond string, exact bool) {
+	param := func(v interface{}) string {
+		*args = append(*args, v)
+		return fmt.Sprintf("$%d", len(*args))
+	}
+	join := func(qs []user.Query, op string) (string, bool) {
+		parts := make([]string, 0, len(qs))
+		exact := true
+		for _, c := range qs {
+			s, ok := condition(c, args)
+			parts = append(parts, s)
+			exact = exact && ok
+		}
+		return "(" + strings.Join(parts, " "+op+" ") + ")", exact
+	}
+
+	switch q := q.(type) {
+	case user.All:
+		return "TRUE", true
+	case user.And:
+		return join(q, "AND")
+	case user.Or:
+		return join(q, "OR")
+	case user.Not:
+		// отрицание условия с запасом потеряло бы подходящих, такое отрицание проверяет только q.Match
+		n := len(*args)
+		s, ok := condition(q.Q, args)
+		if !ok {
+			*args = (*args)[:n]
+			return "TRUE", false
+		}
+		return "NOT " + s, true
+	case user.Term:
+		switch q.Field {
+		case user.FieldName, user.FieldData:
+			if q.Op == user.OpFuzzy {
+				return "TRUE", false
+			}
+			column := q.Field
+			if q.Fold || q.Op == user.OpWordPrefix {
+				column = q.Field + "_norm"
+			}
+			// strpos находит первое вхождение, значит = 1 это префикс
+			if q.Op == user.OpPrefix {
+				return fmt.Sprintf("strpos(%s, %s) = 1", column, param(q.Value)), true
+			}
+			return fmt.Sprintf("strpos(%s, %s) > 0", column, param(q.Value)), q.Op != user.OpWordPrefix
+		case user.FieldID:
+			uid, err := uuid.Parse(q.Value)
+			if err != nil {
+				return "FALSE", true
+			}
+			return "id = " + param(uid), true
+		case user.FieldPermissions:
+			if q.Op == user.OpHas {
+				p := param(q.Mask)
+				return fmt.Sprintf("(permissions & %s) = %s", p, p), true
+			}
+			return "permissions = " + param(q.Mask), true
+		}
+	}
+	return "TRUE", false
+}
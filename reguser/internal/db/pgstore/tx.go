@@ -0,0 +1,68 @@
+package pgstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/google/uuid"
+)
+
+var _ user.UserTx = &Tx{}
+
+// Tx транзакция базы данных, в ней выполняются те же запросы что и в Users
+type Tx struct {
+	tx    *sql.Tx
+	names user.NamePolicy
+}
+
+func (us *Users) Begin(ctx context.Context) (user.UserTx, error) {
+	tx, err := us.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{tx: tx, names: us.names}, nil
+}
+
+func (tx *Tx) Create(ctx context.Context, u user.User) (*uuid.UUID, error) {
+	return create(ctx, tx.tx, tx.names, u)
+}
+
+// Read берет блокировку строки до конца транзакции, чтобы прочитанное не поменяли до Commit,
+// например между чтением и удалением в user.Users.Delete.
+func (tx *Tx) Read(ctx context.Context, uid uuid.UUID) (*user.User, error) {
+	u := user.User{}
+	err := tx.tx.QueryRowContext(ctx,
+		`SELECT id, name, data, permissions, version FROM users WHERE id = $1 FOR UPDATE`, uid,
+	).Scan(&u.ID, &u.Name, &u.Data, &u.Permissions, &u.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (tx *Tx) Update(ctx context.Context, u user.User) error {
+	return update(ctx, tx.tx, tx.names, u)
+}
+
+func (tx *Tx) Patch(ctx context.Context, uid uuid.UUID, p user.UserPatch) (*user.User, error) {
+	return patch(ctx, tx.tx, tx.names, uid, p)
+}
+
+func (tx *Tx) Delete(ctx context.Context, uid uuid.UUID) error {
+	return remove(ctx, tx.tx, uid)
+}
+
+func (tx *Tx) Commit() error {
+	return tx.tx.Commit()
+}
+
+// Rollback после Commit по контракту user.UserTx не ошибка
+func (tx *Tx) Rollback() error {
+	err := tx.tx.Rollback()
+	if errors.Is(err, sql.ErrTxDone) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,49 @@
+package sqlitestore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+)
+
+// ListUsers keyset пагинация по индексу users_name_id или первичному ключу.
+// id хранится текстом в нижнем регистре, поэтому его порядок совпадает с порядком байт uuid.
+func (us *Users) ListUsers(ctx context.Context, q user.ListQuery) ([]user.User, error) {
+	op, dir := ">", "ASC"
+	if q.Desc {
+		op, dir = "<", "DESC"
+	}
+	args := []interface{}{}
+	where := ""
+	order := fmt.Sprintf(`id %s`, dir)
+	if q.Sort == user.SortByName {
+		order = fmt.Sprintf(`name %s, id %s`, dir, dir)
+		if q.After != nil {
+			where = fmt.Sprintf(`WHERE (name, id) %s (?, ?)`, op)
+			args = append(args, q.After.Name, q.After.ID)
+		}
+	} else if q.After != nil {
+		where = fmt.Sprintf(`WHERE id %s ?`, op)
+		args = append(args, q.After.ID)
+	}
+	args = append(args, q.Limit)
+
+	rows, err := us.db.QueryContext(ctx,
+		`SELECT id, name, data, permissions, version FROM users `+where+` ORDER BY `+order+` LIMIT ?`, args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]user.User, 0, q.Limit)
+	for rows.Next() {
+		u := user.User{}
+		if err := rows.Scan(&u.ID, &u.Name, &u.Data, &u.Permissions, &u.Version); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
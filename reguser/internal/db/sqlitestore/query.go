@@ -0,0 +1,81 @@
+package sqlitestore
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/google/uuid"
+)
+
+// condition переводит дерево запроса в условие WHERE с параметрами ? в args.
+// Подстроки имени от трех символов ищутся через FTS индекс, короткие через instr перебором.
+// Условия без учета регистра и диакритики сравнивают колонки name_norm и data_norm и индекс users_norm_fts.
+// exact=false если условие отбирает с запасом: начало слова ищется как подстрока, а нечеткое сравнение
+// в SQL не переводится вовсе, тогда найденных досеивает q.Match.
+func condition(q user.Query, args *[]interface{}) (cond string, exact bool) {
+	param := func(v interface{}) string {
+		*args = append(*args, v)
+		return "?"
+	}
+	join := func(qs []user.Query, op string) (string, bool) {
+		parts := make([]string, 0, len(qs))
+		exact := true
+		for _, c := range qs {
+			s, ok := condition(c, args)
+			parts = append(parts, s)
+			exact = exact && ok
+		}
+		return "(" + strings.Join(parts, " "+op+" ") + ")", exact
+	}
+
+	switch q := q.(type) {
+	case user.All:
+		return "1", true
+	case user.And:
+		return join(q, "AND")
+	case user.Or:
+		return join(q, "OR")
+	case user.Not:
+		// отрицание условия с запасом потеряло бы подходящих, такое отрицание проверяет только q.Match
+		n := len(*args)
+		s, ok := condition(q.Q, args)
+		if !ok {
+			*args = (*args)[:n]
+			return "1", false
+		}
+		return "NOT " + s, true
+	case user.Term:
+		switch q.Field {
+		case user.FieldName, user.FieldData:
+			if q.Op == user.OpFuzzy {
+				return "1", false
+			}
+			column, fts := q.Field, "users_fts"
+			if q.Fold || q.Op == user.OpWordPrefix {
+				column, fts = q.Field+"_norm", "users_norm_fts"
+			}
+			exact := q.Op != user.OpWordPrefix
+			if q.Field == user.FieldName && q.Op != user.OpPrefix && len([]rune(q.Value)) >= minFTSQuery {
+				return fmt.Sprintf("rowid IN (SELECT rowid FROM %s WHERE %s MATCH %s)", fts, fts, param(ftsPhrase(q.Value))), exact
+			}
+			// instr находит первое вхождение, значит = 1 это префикс
+			if q.Op == user.OpPrefix {
+				return fmt.Sprintf("instr(%s, %s) = 1", column, param(q.Value)), true
+			}
+			return fmt.Sprintf("instr(%s, %s) > 0", column, param(q.Value)), exact
+		case user.FieldID:
+			uid, err := uuid.Parse(q.Value)
+			if err != nil {
+				return "0", true
+			}
+			return "id = " + param(uid), true
+		case user.FieldPermissions:
+			if q.Op == user.OpHas {
+				return fmt.Sprintf("(permissions & %s) = %s", param(q.Mask), param(q.Mask)), true
+			}
+			return "permissions = " + param(q.Mask), true
+		}
+	}
+	return "1", false
+}
@@ -0,0 +1,68 @@
+-- name_norm и data_norm те же поля после fuzzy.Normalize, их пишет стор, чтобы поиск без учета регистра
+-- и диакритики шел в базе: нормализации как в Go в SQLite нет.
+-- name_key ключ имени по политике уникальности (user.NamePolicy.Key), NULL если политика имена не ограничивает.
+CREATE TABLE IF NOT EXISTS users (
+    id          TEXT PRIMARY KEY,
+    name        TEXT    NOT NULL,
+    data        TEXT    NOT NULL DEFAULT '',
+    permissions INTEGER NOT NULL DEFAULT 0,
+    version     INTEGER NOT NULL DEFAULT 1,
+    name_norm   TEXT    NOT NULL DEFAULT '',
+    data_norm   TEXT    NOT NULL DEFAULT '',
+    name_key    TEXT
+);
+
+-- Уникальность имен, NULL ключи друг другу не мешают
+CREATE UNIQUE INDEX IF NOT EXISTS users_name_key ON users (name_key);
+
+-- Настройки хранилища, которые должны пережить перезапуск: unique_names политика, по которой посчитаны name_key
+CREATE TABLE IF NOT EXISTS settings (
+    key   TEXT PRIMARY KEY,
+    value TEXT NOT NULL
+);
+
+-- Полнотекстовый индекс по именам на триграммах, external content таблица над users,
+-- поэтому имена не дублируются, а индекс поддерживают триггеры.
+CREATE VIRTUAL TABLE IF NOT EXISTS users_fts USING fts5(
+    name,
+    content = 'users',
+    content_rowid = 'rowid',
+    tokenize = 'trigram case_sensitive 1'
+);
+
+CREATE TRIGGER IF NOT EXISTS users_ai AFTER INSERT ON users BEGIN
+    INSERT INTO users_fts (rowid, name) VALUES (new.rowid, new.name);
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_ad AFTER DELETE ON users BEGIN
+    INSERT INTO users_fts (users_fts, rowid, name) VALUES ('delete', old.rowid, old.name);
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_au AFTER UPDATE OF name ON users BEGIN
+    INSERT INTO users_fts (users_fts, rowid, name) VALUES ('delete', old.rowid, old.name);
+    INSERT INTO users_fts (rowid, name) VALUES (new.rowid, new.name);
+END;
+
+-- Такой же индекс по нормализованным именам для поиска без учета регистра и диакритики
+CREATE VIRTUAL TABLE IF NOT EXISTS users_norm_fts USING fts5(
+    name_norm,
+    content = 'users',
+    content_rowid = 'rowid',
+    tokenize = 'trigram case_sensitive 1'
+);
+
+CREATE TRIGGER IF NOT EXISTS users_norm_ai AFTER INSERT ON users BEGIN
+    INSERT INTO users_norm_fts (rowid, name_norm) VALUES (new.rowid, new.name_norm);
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_norm_ad AFTER DELETE ON users BEGIN
+    INSERT INTO users_norm_fts (users_norm_fts, rowid, name_norm) VALUES ('delete', old.rowid, old.name_norm);
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_norm_au AFTER UPDATE OF name_norm ON users BEGIN
+    INSERT INTO users_norm_fts (users_norm_fts, rowid, name_norm) VALUES ('delete', old.rowid, old.name_norm);
+    INSERT INTO users_norm_fts (rowid, name_norm) VALUES (new.rowid, new.name_norm);
+END;
+
+-- Порядок для постраничного списка по имени, BINARY сравнивает побайтно, как и остальные хранилища.
+CREATE INDEX IF NOT EXISTS users_name_id ON users (name, id);
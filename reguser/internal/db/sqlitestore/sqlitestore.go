@@ -0,0 +1,419 @@
+// Package sqlitestore хранилище пользователей во встроенной SQLite (чистый Go, без cgo) в одном файле.
+// Поиск по имени идет через FTS5 индекс с триграммным токенайзером, поэтому подстрока ищется без полного перебора.
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/audetv/hex-ecample/reguser/internal/libs/fuzzy"
+	"github.com/google/uuid"
+
+	// драйвер регистрируется в database/sql под именем sqlite
+	sqlite "github.com/glebarez/go-sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var _ user.UserStore = &Users{}
+var _ user.NamePolicyStore = &Users{}
+
+//go:embed schema.sql
+var schema string
+
+// minFTSQuery триграммный индекс находит только подстроки от трех символов,
+// более короткие запросы ищем перебором
+const minFTSQuery = 3
+
+// Users хранилище поверх файла базы, names политика уникальности имен, см. SetNamePolicy
+type Users struct {
+	db    *sql.DB
+	names user.NamePolicy
+}
+
+// NewUsers открывает или создает файл базы по пути path и создает схему.
+// WAL журнал позволяет читать во время записи, busy_timeout ждет лок вместо мгновенной ошибки,
+// а immediate транзакции сразу берут лок на запись, чтобы не ловить SQLITE_BUSY посреди транзакции.
+func NewUsers(ctx context.Context, path string) (*Users, error) {
+	q := url.Values{}
+	q.Add("_pragma", "journal_mode(WAL)")
+	q.Add("_pragma", "busy_timeout(5000)")
+	q.Add("_pragma", "synchronous(NORMAL)")
+	q.Set("_txlock", "immediate")
+
+	// путь экранируем, иначе ? или # в имени файла стали бы началом параметров, SQLite раскодирует %XX обратно
+	dsn := url.URL{Scheme: "file", Opaque: (&url.URL{Path: path}).EscapedPath(), RawQuery: q.Encode()}
+	db, err := sql.Open("sqlite", dsn.String())
+	if err != nil {
+		return nil, err
+	}
+	if err := upgrade(ctx, db); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("create schema: %w", err)
+	}
+	return &Users{db: db, names: user.NamesAny}, nil
+}
+
+// columns колонки, которых может не быть в базе, созданной старой версией схемы.
+// Всем старым карточкам достается версия 1, нормализованные поля считаются в Go, ключей имен нет.
+var columns = []struct{ name, ddl string }{
+	{"version", `ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1`},
+	{"name_norm", `ALTER TABLE users ADD COLUMN name_norm TEXT NOT NULL DEFAULT ''`},
+	{"data_norm", `ALTER TABLE users ADD COLUMN data_norm TEXT NOT NULL DEFAULT ''`},
+	{"name_key", `ALTER TABLE users ADD COLUMN name_key TEXT`},
+}
+
+// upgrade создает схему, а базу, созданную старой версией, сначала доводит до нее: CREATE TABLE IF NOT EXISTS
+// существующую таблицу не меняет, поэтому колонки проверяем сами. Колонки добавляются и заполняются
+// до создания индексов и триггеров по ним, а FTS индекс по нормализованным именам потом строится целиком.
+func upgrade(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var n int
+	if err := tx.QueryRowContext(ctx, `SELECT count(*) FROM pragma_table_info('users')`).Scan(&n); err != nil {
+		return err
+	}
+	normalize := false
+	for _, c := range columns {
+		if n == 0 {
+			break
+		}
+		var ok bool
+		err := tx.QueryRowContext(ctx, `SELECT count(*) > 0 FROM pragma_table_info('users') WHERE name = ?`, c.name).Scan(&ok)
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, c.ddl); err != nil {
+			return fmt.Errorf("add %s: %w", c.name, err)
+		}
+		normalize = normalize || c.name == "name_norm"
+	}
+	if normalize {
+		if err := normalizeAll(ctx, tx); err != nil {
+			return fmt.Errorf("normalize fields: %w", err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, schema); err != nil {
+		return err
+	}
+	if normalize {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO users_norm_fts (users_norm_fts) VALUES ('rebuild')`); err != nil {
+			return fmt.Errorf("rebuild index: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// readAll все карточки без прав и версий, для пересчета производных колонок
+func readAll(ctx context.Context, tx *sql.Tx) ([]user.User, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id, name, data FROM users`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []user.User
+	for rows.Next() {
+		u := user.User{}
+		if err := rows.Scan(&u.ID, &u.Name, &u.Data); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// normalizeAll заполняет name_norm и data_norm у всех карточек
+func normalizeAll(ctx context.Context, tx *sql.Tx) error {
+	users, err := readAll(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		_, err := tx.ExecContext(ctx, `UPDATE users SET name_norm = ?, data_norm = ? WHERE id = ?`,
+			fuzzy.Normalize(u.Name), fuzzy.Normalize(u.Data), u.ID.String())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetNamePolicy включает уникальность имен по политике p: ключ имени пишется в name_key под уникальным индексом.
+// Политика запоминается в базе, ключи всех карточек пересчитываются, только если она поменялась.
+// Если среди карточек уже есть одинаковые по новой политике имена, возвращает ошибку с user.ErrNameTaken
+// и ничего не меняет. Вызывать до начала работы с хранилищем.
+func (us *Users) SetNamePolicy(ctx context.Context, p user.NamePolicy) error {
+	tx, err := us.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	cur := string(user.NamesAny)
+	err = tx.QueryRowContext(ctx, `SELECT value FROM settings WHERE key = 'unique_names'`).Scan(&cur)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if user.NamePolicy(cur) != p {
+		if err := rekey(ctx, tx, p); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO settings (key, value) VALUES ('unique_names', ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value`,
+			string(p))
+		if err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	us.names = p
+	return nil
+}
+
+// rekey пересчитывает ключи имен всех карточек по политике p. Старые ключи сначала стираются,
+// чтобы они не мешали новым, а совпадение новых ключей уникальный индекс вернет ошибкой.
+func rekey(ctx context.Context, tx *sql.Tx, p user.NamePolicy) error {
+	if _, err := tx.ExecContext(ctx, `UPDATE users SET name_key = NULL`); err != nil {
+		return err
+	}
+	users, err := readAll(ctx, tx)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		k, ok := p.Key(u.Name)
+		if !ok {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE users SET name_key = ? WHERE id = ?`, k, u.ID.String()); err != nil {
+			if nameTaken(err) {
+				return fmt.Errorf("%w: %q", user.ErrNameTaken, u.Name)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// nameTaken нарушен уникальный индекс по ключу имени. Других уникальных индексов у users нет,
+// а совпадение id SQLite возвращает другим кодом, SQLITE_CONSTRAINT_PRIMARYKEY.
+func nameTaken(err error) bool {
+	e := &sqlite.Error{}
+	return errors.As(err, &e) && e.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+// nameKey ключ имени для колонки name_key, NULL если политика имена не ограничивает
+func nameKey(p user.NamePolicy, name string) interface{} {
+	if k, ok := p.Key(name); ok {
+		return k
+	}
+	return nil
+}
+
+// Close закрывает базу
+func (us *Users) Close() error {
+	return us.db.Close()
+}
+
+// querier общее у *sql.DB и *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// create, update и patch пишут ключ имени по политике names, занятое имя возвращают как user.ErrNameTaken
+func create(ctx context.Context, q querier, names user.NamePolicy, u user.User) (*uuid.UUID, error) {
+	if u.Version == 0 {
+		u.Version = 1
+	}
+	_, err := q.ExecContext(ctx,
+		`INSERT INTO users (id, name, data, permissions, version, name_norm, data_norm, name_key)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		u.ID.String(), u.Name, u.Data, u.Permissions, u.Version, fuzzy.Normalize(u.Name), fuzzy.Normalize(u.Data),
+		nameKey(names, u.Name),
+	)
+	if nameTaken(err) {
+		return nil, user.ErrNameTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u.ID, nil
+}
+
+// read если строки нет, Scan вернет sql.ErrNoRows
+func read(ctx context.Context, q querier, uid uuid.UUID) (*user.User, error) {
+	u := user.User{}
+	err := q.QueryRowContext(ctx,
+		`SELECT id, name, data, permissions, version FROM users WHERE id = ?`, uid.String(),
+	).Scan(&u.ID, &u.Name, &u.Data, &u.Permissions, &u.Version)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// missing почему UPDATE по id и версии не нашел строку: строки нет или версия другая
+func missing(ctx context.Context, q querier, uid uuid.UUID) error {
+	var v int64
+	err := q.QueryRowContext(ctx, `SELECT version FROM users WHERE id = ?`, uid.String()).Scan(&v)
+	if err != nil {
+		return err
+	}
+	return user.ErrVersionMismatch
+}
+
+// update compare-and-swap по версии одним запросом, version 0 в u обновляет без проверки
+func update(ctx context.Context, q querier, names user.NamePolicy, u user.User) error {
+	res, err := q.ExecContext(ctx,
+		`UPDATE users SET name = ?, data = ?, permissions = ?, version = version + 1,
+		name_norm = ?, data_norm = ?, name_key = ?
+		WHERE id = ? AND (? = 0 OR version = ?)`,
+		u.Name, u.Data, u.Permissions, fuzzy.Normalize(u.Name), fuzzy.Normalize(u.Data), nameKey(names, u.Name),
+		u.ID.String(), u.Version, u.Version,
+	)
+	if nameTaken(err) {
+		return user.ErrNameTaken
+	}
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return missing(ctx, q, u.ID)
+	}
+	return nil
+}
+
+// normalized fuzzy.Normalize от поля патча, nil если поле не меняется
+func normalized(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	n := fuzzy.Normalize(*s)
+	return &n
+}
+
+func patch(ctx context.Context, q querier, names user.NamePolicy, uid uuid.UUID, p user.UserPatch) (*user.User, error) {
+	var key interface{}
+	if p.Name != nil {
+		key = nameKey(names, *p.Name)
+	}
+	u := user.User{}
+	err := q.QueryRowContext(ctx,
+		`UPDATE users SET name = COALESCE(?, name), data = COALESCE(?, data), version = version + 1,
+		name_norm = COALESCE(?, name_norm), data_norm = COALESCE(?, data_norm),
+		name_key = CASE WHEN ? THEN ? ELSE name_key END
+		WHERE id = ? AND (? = 0 OR version = ?) RETURNING id, name, data, permissions, version`,
+		p.Name, p.Data, normalized(p.Name), normalized(p.Data), p.Name != nil, key, uid.String(), p.Version, p.Version,
+	).Scan(&u.ID, &u.Name, &u.Data, &u.Permissions, &u.Version)
+	if nameTaken(err) {
+		return nil, user.ErrNameTaken
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, missing(ctx, q, uid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// remove как и в usermemstore не возвращает ошибку если удалять нечего
+func remove(ctx context.Context, q querier, uid uuid.UUID) error {
+	_, err := q.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, uid.String())
+	return err
+}
+
+func (us *Users) Create(ctx context.Context, u user.User) (*uuid.UUID, error) {
+	return create(ctx, us.db, us.names, u)
+}
+
+func (us *Users) Read(ctx context.Context, uid uuid.UUID) (*user.User, error) {
+	return read(ctx, us.db, uid)
+}
+
+func (us *Users) Update(ctx context.Context, u user.User) error {
+	return update(ctx, us.db, us.names, u)
+}
+
+func (us *Users) Patch(ctx context.Context, uid uuid.UUID, p user.UserPatch) (*user.User, error) {
+	return patch(ctx, us.db, us.names, uid, p)
+}
+
+func (us *Users) Delete(ctx context.Context, uid uuid.UUID) error {
+	return remove(ctx, us.db, uid)
+}
+
+// ftsPhrase экранирует запрос как фразу FTS5, для триграмм фраза означает подстроку
+func ftsPhrase(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// SearchUsers условие запроса переводится в SQL, подстроки имени идут через FTS индекс.
+// Если условие отбирает с запасом, лишних отсеивает q.Match.
+// Запрос выполняется сразу, чтобы вернуть его ошибку, а строки отдаются в канал из горутины
+// по мере чтения, пока не кончатся или не отменят контекст. Ошибка чтения строк уходит в канал последним элементом.
+func (us *Users) SearchUsers(ctx context.Context, q user.Query) (chan user.Found, error) {
+	args := []interface{}{}
+	cond, exact := condition(q, &args)
+	rows, err := us.db.QueryContext(ctx,
+		`SELECT id, name, data, permissions, version FROM users WHERE `+cond, args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	chout := make(chan user.Found, 100)
+	go func() {
+		defer close(chout)
+		defer rows.Close()
+		for rows.Next() {
+			u := user.User{}
+			if err := rows.Scan(&u.ID, &u.Name, &u.Data, &u.Permissions, &u.Version); err != nil {
+				sendErr(ctx, chout, err)
+				return
+			}
+			if !exact && !q.Match(u) {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case chout <- user.Found{User: u}:
+			}
+		}
+		if err := rows.Err(); err != nil {
+			sendErr(ctx, chout, err)
+		}
+	}()
+	return chout, nil
+}
+
+// sendErr отдает ошибку последним элементом выдачи. Если контекст отменен, читать уже некому.
+func sendErr(ctx context.Context, chout chan user.Found, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case chout <- user.Found{Err: err}:
+	}
+}
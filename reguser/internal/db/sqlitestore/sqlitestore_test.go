@@ -0,0 +1,358 @@
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/auth"
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/audetv/hex-ecample/reguser/internal/db/storetest"
+	"github.com/google/uuid"
+)
+
+func newTestUsers(t *testing.T) *Users {
+	t.Helper()
+	us, err := NewUsers(context.Background(), filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = us.Close() })
+	return us
+}
+
+func TestUsers(t *testing.T) {
+	storetest.Run(t, func() user.UserStore { return newTestUsers(t) })
+}
+
+func search(t *testing.T, us *Users, q string) string {
+	t.Helper()
+	ch, err := us.SearchUsers(context.Background(), user.NameContains(q))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for u := range ch {
+		names = append(names, u.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func TestUsers_SearchUsers(t *testing.T) {
+	ctx := context.Background()
+	us := newTestUsers(t)
+	ids := map[string]uuid.UUID{}
+	for _, name := range []string{"ivan", "Ivanov", "petrov", `a"b`} {
+		id := uuid.New()
+		ids[name] = id
+		if _, err := us.Create(ctx, user.User{ID: id, Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := map[string]string{
+		"van":  "Ivanov,ivan",
+		"ivan": "ivan",
+		"ov":   "Ivanov,petrov",
+		`a"b`:  `a"b`,
+		"zzz":  "",
+	}
+	for q, want := range cases {
+		if got := search(t, us, q); got != want {
+			t.Errorf("search %q: got %q, want %q", q, got, want)
+		}
+	}
+
+	// индекс должен следить за изменением и удалением имен
+	name := "sidorov"
+	if _, err := us.Patch(ctx, ids["petrov"], user.UserPatch{Name: &name}); err != nil {
+		t.Fatal(err)
+	}
+	if err := us.Delete(ctx, ids["ivan"]); err != nil {
+		t.Fatal(err)
+	}
+	if got := search(t, us, "van"); got != "Ivanov" {
+		t.Errorf("search after delete: %q", got)
+	}
+	if got := search(t, us, "dor"); got != "sidorov" {
+		t.Errorf("search after patch: %q", got)
+	}
+}
+
+func TestUsers_Tx(t *testing.T) {
+	ctx := context.Background()
+	us := newTestUsers(t)
+	u := user.User{ID: uuid.New(), Name: "user", Data: "data"}
+
+	tx, err := us.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Create(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Read(ctx, u.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("rolled back user: %v", err)
+	}
+
+	if _, err := us.Create(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	u.Version = 1
+	got, err := us.Read(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != u {
+		t.Errorf("read %+v, want %+v", got, u)
+	}
+	if err := us.Update(ctx, user.User{ID: uuid.New()}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("update unknown: %v", err)
+	}
+}
+
+func TestUsers_SearchCanceled(t *testing.T) {
+	us := newTestUsers(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := us.SearchUsers(ctx, user.NameContains("user")); err == nil {
+		t.Error("search with canceled context")
+	}
+}
+
+// TestUsers_SearchStreamError строка, которую нельзя прочитать, обрывает выдачу ошибкой последним элементом,
+// а уже прочитанные строки успевают дойти
+func TestUsers_SearchStreamError(t *testing.T) {
+	ctx := context.Background()
+	us := newTestUsers(t)
+	if _, err := us.Create(ctx, user.User{ID: uuid.New(), Name: "ivan"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.db.ExecContext(ctx, `INSERT INTO users (id, name) VALUES ('broken', 'ivanov')`); err != nil {
+		t.Fatal(err)
+	}
+	ch, err := us.SearchUsers(ctx, user.All{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []user.Found
+	for f := range ch {
+		got = append(got, f)
+	}
+	if len(got) != 2 || got[0].Name != "ivan" || got[0].Err != nil || got[1].Err == nil {
+		t.Errorf("stream %+v", got)
+	}
+}
+
+// База, созданная до появления версий и нормализованных полей, получает эти колонки при открытии
+func TestUsers_Upgrade(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "users.db")
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid := uuid.New()
+	for _, q := range []string{
+		`CREATE TABLE users (id TEXT PRIMARY KEY, name TEXT NOT NULL, data TEXT NOT NULL DEFAULT '', permissions INTEGER NOT NULL DEFAULT 0)`,
+		`INSERT INTO users (id, name, data) VALUES ('` + uid.String() + `', 'Ivan', 'Moscow')`,
+	} {
+		if _, err := db.ExecContext(ctx, q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		us, err := NewUsers(ctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := us.Read(ctx, uid)
+		if err != nil || u.Version != 1 {
+			t.Errorf("open %d: read %+v, %v", i, u, err)
+		}
+		for _, q := range []user.Query{
+			user.Term{Field: user.FieldName, Op: user.OpContains, Value: "iva", Fold: true},
+			user.Term{Field: user.FieldData, Op: user.OpPrefix, Value: "mos", Fold: true},
+		} {
+			ch, err := us.SearchUsers(ctx, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []user.Found
+			for f := range ch {
+				got = append(got, f)
+			}
+			if len(got) != 1 || got[0].ID != uid {
+				t.Errorf("open %d: search %+v: %+v", i, q, got)
+			}
+		}
+		_ = us.Close()
+	}
+}
+
+// TestUsers_SearchPushdown поиск из бизнес логики во всех режимах, кроме нечеткого, фильтрует в базе.
+// Строку, которую нельзя прочитать, видит только полный перебор, и он оборвался бы на ней ошибкой.
+func TestUsers_SearchPushdown(t *testing.T) {
+	ctx := auth.WithPrincipal(context.Background(), &auth.Principal{Permissions: auth.PermSearch})
+	us := newTestUsers(t)
+	for _, name := range []string{"Ivan Petrov", "Éloïse", "ivanov"} {
+		if _, err := us.Create(ctx, user.User{ID: uuid.New(), Name: name, Data: "Moscow"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := us.db.ExecContext(ctx, `INSERT INTO users (id, name) VALUES ('broken', 'zzz')`); err != nil {
+		t.Fatal(err)
+	}
+	users := user.NewUsers(us)
+
+	cases := []struct {
+		q, mode, want string
+		err           bool
+	}{
+		{"Ivan", user.ModeExact, "Ivan Petrov", false},
+		{"name:iv*", user.ModeExact, "ivanov", false},
+		{"IVAN", user.ModeFold, "Ivan Petrov,ivanov", false},
+		{"elo", user.ModeFold, "Éloïse", false},
+		{"data:MOSCOW -ivan", user.ModeFold, "Éloïse", false},
+		{"pet", user.ModePrefix, "Ivan Petrov", false},
+		{"pet OR data:mos", user.ModePrefix, "Ivan Petrov,ivanov,Éloïse", false},
+		// отрицание нечеткого условия в SQL не переводится, видно и битую строку
+		{"ivan -petrv", user.ModeFuzzy, "", true},
+	}
+	for _, c := range cases {
+		ch, err := users.SearchUsers(ctx, c.q, c.mode)
+		if err != nil {
+			t.Fatalf("%q %s: %v", c.q, c.mode, err)
+		}
+		var got []string
+		var serr error
+		for su := range ch {
+			if su.Err != nil {
+				serr = su.Err
+				continue
+			}
+			got = append(got, su.Name)
+		}
+		sort.Strings(got)
+		if (serr != nil) != c.err {
+			t.Errorf("%q %s: error %v", c.q, c.mode, serr)
+		}
+		if !c.err && strings.Join(got, ",") != c.want {
+			t.Errorf("%q %s: got %v, want %q", c.q, c.mode, got, c.want)
+		}
+	}
+}
+
+// TestNameTaken занятым именем считается только нарушение индекса name_key, а не совпадение id
+func TestNameTaken(t *testing.T) {
+	ctx := context.Background()
+	us, err := NewUsers(ctx, filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer us.Close()
+	if err := us.SetNamePolicy(ctx, user.NamesExact); err != nil {
+		t.Fatal(err)
+	}
+	u := user.User{ID: uuid.New(), Name: "ivan"}
+	if _, err := us.Create(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = us.Create(ctx, user.User{ID: uuid.New(), Name: "ivan"})
+	if !errors.Is(err, user.ErrNameTaken) {
+		t.Errorf("same name: %v", err)
+	}
+	_, err = us.Create(ctx, user.User{ID: u.ID, Name: "petr"})
+	if err == nil || errors.Is(err, user.ErrNameTaken) {
+		t.Errorf("same id: %v", err)
+	}
+	if nameTaken(nil) || nameTaken(errors.New("UNIQUE constraint failed: users.name_key")) {
+		t.Error("error without sqlite code")
+	}
+}
+
+// TestUsers_UniqueNamesReopen политика хранится в базе, после перезапуска ключи не пересчитываются
+// и занятое имя остается занятым
+func TestUsers_UniqueNamesReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "users.db")
+	us, err := NewUsers(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := us.SetNamePolicy(ctx, user.NamesFold); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Create(ctx, user.User{ID: uuid.New(), Name: "Ivan Petrov"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := us.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	us, err = NewUsers(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer us.Close()
+	var policy string
+	if err := us.db.QueryRowContext(ctx, `SELECT value FROM settings WHERE key = 'unique_names'`).Scan(&policy); err != nil {
+		t.Fatal(err)
+	}
+	if policy != string(user.NamesFold) {
+		t.Errorf("stored policy %q", policy)
+	}
+	if err := us.SetNamePolicy(ctx, user.NamesFold); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := us.Create(ctx, user.User{ID: uuid.New(), Name: "ivan petrov"}); !errors.Is(err, user.ErrNameTaken) {
+		t.Errorf("after reopen: %v", err)
+	}
+}
+
+// TestNewUsers_Path символы, особые для URI, в пути к базе это просто часть имени файла
+func TestNewUsers_Path(t *testing.T) {
+	ctx := auth.WithSystem(context.Background())
+	dir := filepath.Join(t.TempDir(), "a?b#c")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "users 100%.db")
+	us, err := NewUsers(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := us.Create(ctx, user.User{ID: uuid.New(), Name: "ivan"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := us.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("database is not at the given path: %v", err)
+	}
+
+	us, err = NewUsers(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer us.Close()
+	if _, err := us.Read(ctx, *id); err != nil {
+		t.Errorf("reopen: %v", err)
+	}
+}
@@ -0,0 +1,664 @@
+// Package storetest общий набор тестов поведения для любой реализации user.UserStore.
+// Адаптер подключает его из своего _test.go файла:
+//
+//	func TestUsers(t *testing.T) {
+//		storetest.Run(t, func() user.UserStore { return NewUsers() })
+//	}
+//
+// Каждый подтест вызывает конструктор заново и ожидает пустое хранилище.
+// Закрытие хранилища, если оно нужно, конструктор регистрирует сам через t.Cleanup.
+package storetest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/audetv/hex-ecample/reguser/internal/app/repos/user"
+	"github.com/google/uuid"
+)
+
+// closeTimeout сколько ждем закрытия канала поиска
+const closeTimeout = 5 * time.Second
+
+// Run прогоняет все проверки на хранилищах, созданных newStore
+func Run(t *testing.T, newStore func() user.UserStore) {
+	tests := []struct {
+		name string
+		f    func(t *testing.T, st user.UserStore)
+	}{
+		{"CreateRead", testCreateRead},
+		{"NotFound", testNotFound},
+		{"Delete", testDelete},
+		{"Update", testUpdate},
+		{"Patch", testPatch},
+		{"Version", testVersion},
+		{"Search", testSearch},
+		{"SearchQuery", testSearchQuery},
+		{"SearchClosesChannel", testSearchClosesChannel},
+		{"SearchCanceled", testSearchCanceled},
+		{"List", testList},
+		{"ListStable", testListStable},
+		{"ContextCanceled", testContextCanceled},
+		{"TxCommit", testTxCommit},
+		{"TxRollback", testTxRollback},
+		{"Concurrent", testConcurrent},
+		{"UniqueNames", testUniqueNames},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.f(t, newStore())
+		})
+	}
+}
+
+func create(t *testing.T, st user.UserStore, name string) user.User {
+	t.Helper()
+	u := user.User{
+		ID:          uuid.New(),
+		Name:        name,
+		Data:        "data of " + name,
+		Permissions: 0o644,
+	}
+	id, err := st.Create(context.Background(), u)
+	if err != nil {
+		t.Fatalf("create %q: %v", name, err)
+	}
+	if *id != u.ID {
+		t.Fatalf("create returned id %s, want %s", id, u.ID)
+	}
+	u.Version = 1
+	return u
+}
+
+func read(t *testing.T, st user.UserStore, uid uuid.UUID) user.User {
+	t.Helper()
+	u, err := st.Read(context.Background(), uid)
+	if err != nil {
+		t.Fatalf("read %s: %v", uid, err)
+	}
+	return *u
+}
+
+// collect вычитывает канал до закрытия, если канал не закрылся за closeTimeout
+// или хранилище оборвало выдачу ошибкой, тест падает
+func collect(t *testing.T, ch chan user.Found) []user.User {
+	t.Helper()
+	res := []user.User{}
+	timeout := time.After(closeTimeout)
+	for {
+		select {
+		case f, ok := <-ch:
+			if !ok {
+				return res
+			}
+			if f.Err != nil {
+				t.Fatalf("search stream error: %v", f.Err)
+			}
+			res = append(res, f.User)
+		case <-timeout:
+			t.Fatal("search channel is not closed")
+		}
+	}
+}
+
+func names(us []user.User) string {
+	res := make([]string, 0, len(us))
+	for _, u := range us {
+		res = append(res, u.Name)
+	}
+	sort.Strings(res)
+	return strings.Join(res, ",")
+}
+
+func testCreateRead(t *testing.T, st user.UserStore) {
+	u := create(t, st, "ivan")
+	if got := read(t, st, u.ID); got != u {
+		t.Errorf("read %+v, want %+v", got, u)
+	}
+}
+
+func testNotFound(t *testing.T, st user.UserStore) {
+	ctx := context.Background()
+	uid := uuid.New()
+	if _, err := st.Read(ctx, uid); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("read: %v, want sql.ErrNoRows", err)
+	}
+	if err := st.Update(ctx, user.User{ID: uid, Name: "nobody"}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("update: %v, want sql.ErrNoRows", err)
+	}
+	name := "nobody"
+	if _, err := st.Patch(ctx, uid, user.UserPatch{Name: &name}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("patch: %v, want sql.ErrNoRows", err)
+	}
+	// удаление несуществующего пользователя не ошибка
+	if err := st.Delete(ctx, uid); err != nil {
+		t.Errorf("delete: %v", err)
+	}
+}
+
+func testDelete(t *testing.T, st user.UserStore) {
+	ctx := context.Background()
+	u := create(t, st, "ivan")
+	other := create(t, st, "petr")
+	if err := st.Delete(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Read(ctx, u.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("read deleted: %v, want sql.ErrNoRows", err)
+	}
+	read(t, st, other.ID)
+}
+
+func testUpdate(t *testing.T, st user.UserStore) {
+	u := create(t, st, "ivan")
+	u.Name = "petr"
+	u.Data = ""
+	if err := st.Update(context.Background(), u); err != nil {
+		t.Fatal(err)
+	}
+	u.Version = 2
+	if got := read(t, st, u.ID); got != u {
+		t.Errorf("read %+v, want %+v", got, u)
+	}
+}
+
+func testPatch(t *testing.T, st user.UserStore) {
+	u := create(t, st, "ivan")
+	name := "petr"
+	got, err := st.Patch(context.Background(), u.ID, user.UserPatch{Name: &name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Name = name
+	u.Version = 2
+	if *got != u {
+		t.Errorf("patch returned %+v, want %+v", got, u)
+	}
+	if got := read(t, st, u.ID); got != u {
+		t.Errorf("read %+v, want %+v", got, u)
+	}
+}
+
+// testVersion compare-and-swap по версии: устаревшая версия не меняет карточку, 0 меняет без проверки
+func testVersion(t *testing.T, st user.UserStore) {
+	ctx := context.Background()
+	u := create(t, st, "ivan")
+
+	stale := u
+	stale.Name = "stale"
+	stale.Version = 2
+	if err := st.Update(ctx, stale); !errors.Is(err, user.ErrVersionMismatch) {
+		t.Errorf("update stale: %v, want user.ErrVersionMismatch", err)
+	}
+	name := "stale"
+	if _, err := st.Patch(ctx, u.ID, user.UserPatch{Name: &name, Version: 2}); !errors.Is(err, user.ErrVersionMismatch) {
+		t.Errorf("patch stale: %v, want user.ErrVersionMismatch", err)
+	}
+	if got := read(t, st, u.ID); got != u {
+		t.Errorf("stale write changed user: %+v", got)
+	}
+
+	name = "petr"
+	got, err := st.Patch(ctx, u.ID, user.UserPatch{Name: &name, Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != 2 {
+		t.Errorf("patch version %d, want 2", got.Version)
+	}
+	u = *got
+	u.Data = "new"
+	u.Version = 0
+	if err := st.Update(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, st, u.ID); got.Version != 3 || got.Data != "new" {
+		t.Errorf("unconditional update: %+v", got)
+	}
+	if err := st.Update(ctx, user.User{ID: uuid.New(), Name: "nobody", Version: 1}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("update missing with version: %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testSearch(t *testing.T, st user.UserStore) {
+	ctx := context.Background()
+	for _, name := range []string{"ivan", "Ivanov", "petrov", "иванов", "ivanovich"} {
+		create(t, st, name)
+	}
+	cases := map[string]string{
+		"ivan":  "ivan,ivanovich",
+		"van":   "Ivanov,ivan,ivanovich",
+		"ov":    "Ivanov,ivanovich,petrov",
+		"ано":   "иванов",
+		"sidor": "",
+	}
+	for q, want := range cases {
+		ch, err := st.SearchUsers(ctx, user.NameContains(q))
+		if err != nil {
+			t.Fatalf("search %q: %v", q, err)
+		}
+		if got := names(collect(t, ch)); got != want {
+			t.Errorf("search %q: got %q, want %q", q, got, want)
+		}
+	}
+}
+
+// testSearchClosesChannel результатов больше буфера канала, все должны дойти и канал закрыться
+// testSearchQuery хранилище, которое переводит запрос в свои условия, должно находить то же,
+// что и проверка каждого пользователя через Match. mode пустой - запрос как есть, без user.ApplyMode.
+func testSearchQuery(t *testing.T, st user.UserStore) {
+	ctx := context.Background()
+	users := []user.User{
+		{ID: uuid.New(), Name: "ivan", Data: "moscow office", Permissions: 0},
+		{ID: uuid.New(), Name: "ivanov", Data: "kazan", Permissions: 1},
+		{ID: uuid.New(), Name: "petrov", Data: "moscow", Permissions: 3},
+		{ID: uuid.New(), Name: "Ivanova", Data: "", Permissions: 33},
+		{ID: uuid.New(), Name: "sidorov-ivanov", Data: "data: 100%", Permissions: 1},
+		{ID: uuid.New(), Name: "Éloïse", Data: "Paris", Permissions: 0},
+	}
+	for _, u := range users {
+		if _, err := st.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := []struct {
+		q, mode, want string
+	}{
+		{"", "", "Ivanova,ivan,ivanov,petrov,sidorov-ivanov,Éloïse"},
+		{"ivan", "", "ivan,ivanov,sidorov-ivanov"},
+		{"name:ivan*", "", "ivan,ivanov"},
+		{`name:ivan* AND data:"moscow"`, "", "ivan"},
+		{"name:ivan* -permissions:0", "", "ivanov"},
+		{"ov OR data:kazan", "", "Ivanova,ivanov,petrov,sidorov-ivanov"},
+		{"NOT (ov OR an)", "", "Éloïse"},
+		{"permissions:read", "", "Ivanova,ivanov,petrov,sidorov-ivanov"},
+		{"permissions:read,create", "", "petrov"},
+		{"permissions:33", "", "Ivanova"},
+		{"data:100%", "", "sidorov-ivanov"},
+		{"id:" + users[2].ID.String(), "", "petrov"},
+		{"-id:" + users[2].ID.String() + " v", "", "Ivanova,ivan,ivanov,sidorov-ivanov"},
+		{`"v-i"`, "", "sidorov-ivanov"},
+		{"Ivan", user.ModeExact, "Ivanova"},
+		{"eloise", user.ModeExact, ""},
+		{"IVAN", user.ModeFold, "Ivanova,ivan,ivanov,sidorov-ivanov"},
+		{"eloise", user.ModeFold, "Éloïse"},
+		{"name:IVAN*", user.ModeFold, "Ivanova,ivan,ivanov"},
+		{"data:PARIS", user.ModeFold, "Éloïse"},
+		{"-ivan", user.ModeFold, "petrov,Éloïse"},
+		{"elo OR permissions:3", user.ModeFold, "petrov,Éloïse"},
+		{"iva", user.ModePrefix, "Ivanova,ivan,ivanov,sidorov-ivanov"},
+		{"-iva", user.ModePrefix, "petrov,Éloïse"},
+		{"dorov", user.ModePrefix, ""},
+		{"dorov OR data:kaz", user.ModePrefix, "ivanov"},
+		{"ivan* dorov", user.ModePrefix, ""},
+		{"ivnov", user.ModeFuzzy, "ivanov,sidorov-ivanov"},
+		{"petorv -permissions:0", user.ModeFuzzy, "petrov"},
+		{"elose", user.ModeFuzzy, "Éloïse"},
+	}
+	for _, tt := range cases {
+		q, err := user.ParseQuery(tt.q)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.q, err)
+		}
+		if tt.mode != "" {
+			if q, err = user.ApplyMode(q, tt.mode); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var expect []user.User
+		for _, u := range users {
+			if q.Match(u) {
+				expect = append(expect, u)
+			}
+		}
+		if got := names(expect); got != tt.want {
+			t.Fatalf("match %q %s: got %q, want %q", tt.q, tt.mode, got, tt.want)
+		}
+		ch, err := st.SearchUsers(ctx, q)
+		if err != nil {
+			t.Fatalf("search %q %s: %v", tt.q, tt.mode, err)
+		}
+		if got := names(collect(t, ch)); got != tt.want {
+			t.Errorf("search %q %s: got %q, want %q", tt.q, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func testSearchClosesChannel(t *testing.T, st user.UserStore) {
+	const n = 250
+	for i := 0; i < n; i++ {
+		create(t, st, fmt.Sprintf("user%d", i))
+	}
+	ch, err := st.SearchUsers(context.Background(), user.NameContains("user"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := collect(t, ch); len(got) != n {
+		t.Errorf("found %d users, want %d", len(got), n)
+	}
+}
+
+// testSearchCanceled после отмены контекста хранилище должно перестать писать и закрыть канал
+func testSearchCanceled(t *testing.T, st user.UserStore) {
+	for i := 0; i < 250; i++ {
+		create(t, st, fmt.Sprintf("user%d", i))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := st.SearchUsers(ctx, user.NameContains("user"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-ch
+	cancel()
+	collect(t, ch)
+
+	// хранилище после отмены поиска продолжает работать
+	create(t, st, "after")
+}
+
+// listAll проходит весь список страницами по limit, продолжая с ключа последнего пользователя
+func listAll(t *testing.T, st user.UserStore, q user.ListQuery, between func()) []user.User {
+	t.Helper()
+	var all []user.User
+	for {
+		page, err := st.ListUsers(context.Background(), q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) > q.Limit {
+			t.Fatalf("page of %d users, limit %d", len(page), q.Limit)
+		}
+		all = append(all, page...)
+		if len(page) < q.Limit {
+			return all
+		}
+		k := user.KeyOf(page[len(page)-1])
+		q.After = &k
+		if between != nil {
+			between()
+			between = nil
+		}
+	}
+}
+
+func testList(t *testing.T, st user.UserStore) {
+	var users []user.User
+	for _, n := range []string{"bob", "alice", "Carol", "alice", "ёжик", "dave", "Bob"} {
+		users = append(users, create(t, st, n))
+	}
+
+	for _, sortBy := range []string{user.SortByID, user.SortByName} {
+		for _, desc := range []bool{false, true} {
+			want := append([]user.User(nil), users...)
+			sort.Slice(want, func(i, j int) bool {
+				c := user.CompareKeys(sortBy, user.KeyOf(want[i]), user.KeyOf(want[j]))
+				if desc {
+					return c > 0
+				}
+				return c < 0
+			})
+			got := listAll(t, st, user.ListQuery{Sort: sortBy, Desc: desc, Limit: 2}, nil)
+			if len(got) != len(want) {
+				t.Fatalf("%s desc=%v: %d users, want %d", sortBy, desc, len(got), len(want))
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Errorf("%s desc=%v: at %d got %v, want %v", sortBy, desc, i, got[i], want[i])
+				}
+			}
+		}
+	}
+
+	page, err := st.ListUsers(context.Background(), user.ListQuery{Sort: user.SortByName, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 1 || page[0].Name != "Bob" {
+		t.Errorf("first by name %v", page)
+	}
+}
+
+// testListStable вставки между страницами не сдвигают список: уже пройденное не повторяется,
+// а то что добавили дальше курсора, попадает в следующие страницы
+func testListStable(t *testing.T, st user.UserStore) {
+	for _, n := range []string{"b", "c", "d", "e", "f"} {
+		create(t, st, n)
+	}
+	got := listAll(t, st, user.ListQuery{Sort: user.SortByName, Limit: 2}, func() {
+		create(t, st, "a")
+		create(t, st, "cc")
+		create(t, st, "z")
+	})
+	if s := names(got); s != "b,c,cc,d,e,f,z" {
+		t.Errorf("got %s", s)
+	}
+}
+
+func testContextCanceled(t *testing.T, st user.UserStore) {
+	u := create(t, st, "ivan")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := st.Create(ctx, user.User{ID: uuid.New(), Name: "petr"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("create: %v, want context.Canceled", err)
+	}
+	if _, err := st.Read(ctx, u.ID); !errors.Is(err, context.Canceled) {
+		t.Errorf("read: %v, want context.Canceled", err)
+	}
+	if err := st.Delete(ctx, u.ID); !errors.Is(err, context.Canceled) {
+		t.Errorf("delete: %v, want context.Canceled", err)
+	}
+	if _, err := st.SearchUsers(ctx, user.NameContains("ivan")); !errors.Is(err, context.Canceled) {
+		t.Errorf("search: %v, want context.Canceled", err)
+	}
+	if _, err := st.ListUsers(ctx, user.ListQuery{Limit: 1}); !errors.Is(err, context.Canceled) {
+		t.Errorf("list: %v, want context.Canceled", err)
+	}
+	if _, err := st.Begin(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("begin: %v, want context.Canceled", err)
+	}
+	read(t, st, u.ID)
+}
+
+func testTxCommit(t *testing.T, st user.UserStore) {
+	ctx := context.Background()
+	u := create(t, st, "ivan")
+
+	tx, err := st.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nu := user.User{ID: uuid.New(), Name: "petr"}
+	if _, err := tx.Create(ctx, nu); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Delete(ctx, u.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Read(ctx, u.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("read deleted in tx: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Errorf("rollback after commit: %v", err)
+	}
+	if err := tx.Commit(); !errors.Is(err, sql.ErrTxDone) {
+		t.Errorf("second commit: %v, want sql.ErrTxDone", err)
+	}
+
+	read(t, st, nu.ID)
+	if _, err := st.Read(ctx, u.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("read deleted: %v", err)
+	}
+}
+
+func testTxRollback(t *testing.T, st user.UserStore) {
+	ctx := context.Background()
+	u := create(t, st, "ivan")
+
+	tx, err := st.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nu := user.User{ID: uuid.New(), Name: "petr"}
+	if _, err := tx.Create(ctx, nu); err != nil {
+		t.Fatal(err)
+	}
+	name := "ivanov"
+	if _, err := tx.Patch(ctx, u.ID, user.UserPatch{Name: &name}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := st.Read(ctx, nu.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("read rolled back user: %v", err)
+	}
+	if got := read(t, st, u.ID); got != u {
+		t.Errorf("read %+v, want %+v", got, u)
+	}
+	ch, err := st.SearchUsers(ctx, user.NameContains("ivanov"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := collect(t, ch); len(got) != 0 {
+		t.Errorf("search finds rolled back name: %+v", got)
+	}
+}
+
+// testConcurrent параллельные запросы, смысл в запуске с -race
+func testConcurrent(t *testing.T, st user.UserStore) {
+	ctx := context.Background()
+	const workers, n = 8, 20
+	errs := make(chan error, workers)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				u := user.User{ID: uuid.New(), Name: fmt.Sprintf("w%d-%d", w, i)}
+				if _, err := st.Create(ctx, u); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := st.Read(ctx, u.ID); err != nil {
+					errs <- err
+					return
+				}
+				ch, err := st.SearchUsers(ctx, user.NameContains(fmt.Sprintf("w%d-", w)))
+				if err != nil {
+					errs <- err
+					return
+				}
+				for range ch {
+				}
+				if i%2 == 0 {
+					if err := st.Delete(ctx, u.ID); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	ch, err := st.SearchUsers(ctx, user.NameContains("w"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := collect(t, ch); len(got) != workers*n/2 {
+		t.Errorf("found %d users, want %d", len(got), workers*n/2)
+	}
+}
+
+// testUniqueNames уникальность имен по политике user.NamesFold, если хранилище ее поддерживает
+func testUniqueNames(t *testing.T, st user.UserStore) {
+	ns, ok := st.(user.NamePolicyStore)
+	if !ok {
+		t.Skip("store has no name policy")
+	}
+	ctx := context.Background()
+	a := create(t, st, "Ivan Petrov")
+	b := create(t, st, "Petr")
+	if err := ns.SetNamePolicy(ctx, user.NamesFold); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := st.Create(ctx, user.User{ID: uuid.New(), Name: "ivan  PETROV"}); !errors.Is(err, user.ErrNameTaken) {
+		t.Errorf("create duplicate: %v", err)
+	}
+	if err := st.Update(ctx, user.User{ID: b.ID, Name: "IVAN PETROV"}); !errors.Is(err, user.ErrNameTaken) {
+		t.Errorf("update to taken name: %v", err)
+	}
+	name := "ivan petrov"
+	if _, err := st.Patch(ctx, b.ID, user.UserPatch{Name: &name}); !errors.Is(err, user.ErrNameTaken) {
+		t.Errorf("patch to taken name: %v", err)
+	}
+	// свое имя в другом регистре не конфликт, а патч без имени ключ не освобождает
+	if _, err := st.Patch(ctx, a.ID, user.UserPatch{Name: &name}); err != nil {
+		t.Errorf("patch own name: %v", err)
+	}
+	data := "moscow"
+	if _, err := st.Patch(ctx, a.ID, user.UserPatch{Data: &data}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Create(ctx, user.User{ID: uuid.New(), Name: "Ivan Petrov"}); !errors.Is(err, user.ErrNameTaken) {
+		t.Errorf("after data patch: %v", err)
+	}
+
+	// откат возвращает имя прежнему владельцу
+	tx, err := st.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Delete(ctx, a.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Create(ctx, user.User{ID: uuid.New(), Name: "Ivan Petrov"}); err != nil {
+		t.Fatalf("name of deleted user: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Create(ctx, user.User{ID: uuid.New(), Name: "Ivan Petrov"}); !errors.Is(err, user.ErrNameTaken) {
+		t.Errorf("after rollback: %v", err)
+	}
+
+	if err := st.Delete(ctx, a.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Create(ctx, user.User{ID: uuid.New(), Name: "Ivan Petrov"}); err != nil {
+		t.Errorf("name is not freed by delete: %v", err)
+	}
+
+	if err := ns.SetNamePolicy(ctx, user.NamesAny); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Create(ctx, user.User{ID: uuid.New(), Name: "Petr"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.SetNamePolicy(ctx, user.NamesExact); !errors.Is(err, user.ErrNameTaken) {
+		t.Errorf("policy over duplicates: %v", err)
+	}
+	// неудачная смена политики ничего не меняет
+	if _, err := st.Create(ctx, user.User{ID: uuid.New(), Name: "Petr"}); err != nil {
+		t.Errorf("after failed policy change: %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+// Package fuzzy нормализация строк и нечеткое сравнение для поиска.
+// Normalize приводит строку к виду, в котором не важны регистр и диакритика: Ё и е, É и e совпадают.
+// Similarity и Match сравнивают запрос со словами строки с учетом опечаток по расстоянию редактирования.
+package fuzzy
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+var folder = cases.Fold()
+
+// Normalize раскладывает символы (NFD), выбрасывает диакритические знаки и приводит регистр через case folding.
+// Результат годится только для сравнения, показывать его пользователю не нужно.
+func Normalize(s string) string {
+	d := norm.NFD.String(s)
+	var b strings.Builder
+	b.Grow(len(d))
+	for _, r := range d {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return folder.String(norm.NFC.String(b.String()))
+}
+
+// Words слова строки, разделители все кроме букв и цифр
+func Words(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Distance расстояние Дамерау-Левенштейна (optimal string alignment) в рунах:
+// вставка, удаление, замена и перестановка соседних символов стоят по единице.
+func Distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 {
+		return len(rb)
+	}
+	if len(rb) == 0 {
+		return len(ra)
+	}
+	// три строки матрицы: позапрошлая нужна для перестановок
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] && prev2[j-2]+1 < cur[j] {
+				cur[j] = prev2[j-2] + 1
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
+
+func min(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// MaxTypos сколько опечаток прощаем в запросе длины n рун: в коротких словах одну, в длинных больше
+func MaxTypos(n int) int {
+	switch {
+	case n <= 2:
+		return 0
+	case n <= 5:
+		return 1
+	case n <= 9:
+		return 2
+	}
+	return 3
+}
+
+// Similarity насколько нормализованная строка s похожа на нормализованный запрос q, от 0 до 1.
+// 1 если строки равны, чуть меньше если s начинается с q или q это слово или начало слова в s,
+// еще меньше если q просто подстрока. Иначе сравниваем q с каждым словом и с началом каждого слова
+// той же длины, и если опечаток не больше MaxTypos, похожесть падает с числом опечаток.
+// 0 значит не похоже совсем.
+func Similarity(q, s string) float64 {
+	if q == "" {
+		return 1
+	}
+	switch {
+	case s == q:
+		return 1
+	case strings.HasPrefix(s, q):
+		return 0.95
+	}
+	n := len([]rune(q))
+	best := 0.0
+	if strings.Contains(s, q) {
+		best = 0.8
+	}
+	maxTypos := MaxTypos(n)
+	for _, w := range Words(s) {
+		if w == q {
+			return 0.9
+		}
+		if strings.HasPrefix(w, q) {
+			best = max(best, 0.85)
+			continue
+		}
+		d := Distance(q, w)
+		// начало слова длиной с запрос, чтобы опечатка в недописанном слове тоже находилась
+		if rw := []rune(w); len(rw) > n {
+			if dp := Distance(q, string(rw[:n])); dp < d {
+				d = dp
+			}
+		}
+		if d <= maxTypos {
+			best = max(best, 0.7*(1-float64(d)/float64(n+1)))
+		}
+	}
+	return best
+}
+
+func max(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
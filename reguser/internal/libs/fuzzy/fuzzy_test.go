@@ -0,0 +1,59 @@
+package fuzzy
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	cases := map[string]string{
+		"Ivan":   "ivan",
+		"Éloïse": "eloise",
+		"ЁЖИК":   "ежик",
+		"Straße": "strasse",
+		"école": "ecole",
+	}
+	for in, want := range cases {
+		if got := Normalize(in); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"ivan", "ivan", 0},
+		{"ivan", "ivna", 1},
+		{"ivanov", "ivnov", 1},
+		{"kitten", "sitting", 3},
+		{"иван", "иванов", 2},
+	}
+	for _, tt := range cases {
+		if got := Distance(tt.a, tt.b); got != tt.want {
+			t.Errorf("Distance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := Distance(tt.b, tt.a); got != tt.want {
+			t.Errorf("Distance(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	// по убыванию похожести на запрос ivan
+	ordered := []string{"ivan", "ivanov", "petr ivan", "petr ivanovich", "sidorivan", "ivna"}
+	prev := 2.0
+	for _, s := range ordered {
+		got := Similarity("ivan", s)
+		if got <= 0 || got >= prev {
+			t.Errorf("Similarity(ivan, %q) = %v, previous %v", s, got, prev)
+		}
+		prev = got
+	}
+	for _, s := range []string{"petr", "iv", "maria"} {
+		if got := Similarity("ivan", s); got != 0 {
+			t.Errorf("Similarity(ivan, %q) = %v, want 0", s, got)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// Key ключ проверки подписи. Тип key определяет алгоритм:
+// []byte - HS256, *rsa.PublicKey - RS256, ed25519.PublicKey - EdDSA.
+type Key struct {
+	ID  string
+	Alg string
+	key interface{}
+}
+
+// NewHMACKey симметричный ключ для HS256
+func NewHMACKey(id string, secret []byte) Key {
+	return Key{ID: id, Alg: AlgHS256, key: secret}
+}
+
+// NewRSAKey публичный ключ для RS256
+func NewRSAKey(id string, pub *rsa.PublicKey) Key {
+	return Key{ID: id, Alg: AlgRS256, key: pub}
+}
+
+// NewEd25519Key публичный ключ для EdDSA
+func NewEd25519Key(id string, pub ed25519.PublicKey) Key {
+	return Key{ID: id, Alg: AlgEdDSA, key: pub}
+}
+
+// KeySet набор ключей, из которых выбирается ключ по kid из заголовка токена
+type KeySet struct {
+	Keys []Key
+}
+
+// jwk ключ в формате JSON Web Key (RFC 7517), только нужные нам поля
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// oct
+	K string `json:"k"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// OKP
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+}
+
+// LoadJWKS читает набор ключей из локального файла
+func LoadJWKS(path string) (*KeySet, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ks, err := ParseJWKS(b)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return ks, nil
+}
+
+// ParseJWKS разбирает JWKS документ {"keys":[...]}. Ключи не для подписи (use != sig) пропускаются,
+// неизвестный тип ключа - ошибка, чтобы опечатка в файле не превращалась в молча пропавший ключ.
+func ParseJWKS(b []byte) (*KeySet, error) {
+	doc := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	ks := &KeySet{}
+	for i, j := range doc.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		k, err := j.key()
+		if err != nil {
+			return nil, fmt.Errorf("key %d (%s): %w", i, j.Kid, err)
+		}
+		ks.Keys = append(ks.Keys, k)
+	}
+	if len(ks.Keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	return ks, nil
+}
+
+func b64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+func (j jwk) key() (Key, error) {
+	var k Key
+	switch j.Kty {
+	case "oct":
+		secret, err := b64(j.K)
+		if err != nil || len(secret) == 0 {
+			return k, errors.New("bad oct key")
+		}
+		k = NewHMACKey(j.Kid, secret)
+	case "RSA":
+		n, err := b64(j.N)
+		if err != nil || len(n) == 0 {
+			return k, errors.New("bad rsa modulus")
+		}
+		e, err := b64(j.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return k, errors.New("bad rsa exponent")
+		}
+		pub := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		if pub.N.BitLen() < 2048 {
+			return k, errors.New("rsa key is shorter than 2048 bits")
+		}
+		k = NewRSAKey(j.Kid, pub)
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return k, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		x, err := b64(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return k, errors.New("bad ed25519 key")
+		}
+		k = NewEd25519Key(j.Kid, ed25519.PublicKey(x))
+	default:
+		return k, fmt.Errorf("unsupported key type %q", j.Kty)
+	}
+	if j.Alg != "" && j.Alg != k.Alg {
+		return k, fmt.Errorf("key type %s does not match alg %s", j.Kty, j.Alg)
+	}
+	return k, nil
+}
@@ -0,0 +1,219 @@
+// Package jwt проверка подписанных JWT (RFC 7519) в компактной форме: подпись HS256, RS256 или EdDSA,
+// издатель, аудитория и сроки действия с допуском на расхождение часов.
+// Только проверка, выпуск токенов не нужен. Пакет не зависит от слоев приложения.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+var (
+	ErrMalformed     = errors.New("jwt: malformed token")
+	ErrAlgorithm     = errors.New("jwt: unsupported algorithm")
+	ErrUnknownKey    = errors.New("jwt: unknown key")
+	ErrSignature     = errors.New("jwt: invalid signature")
+	ErrExpired       = errors.New("jwt: token is expired")
+	ErrNotYetValid   = errors.New("jwt: token is not valid yet")
+	ErrIssuer        = errors.New("jwt: invalid issuer")
+	ErrAudience      = errors.New("jwt: invalid audience")
+	ErrMissingExpiry = errors.New("jwt: token has no expiry")
+)
+
+// Claims полезная нагрузка токена как есть, числа приходят как float64
+type Claims map[string]interface{}
+
+func (c Claims) str(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Subject sub
+func (c Claims) Subject() string {
+	return c.str("sub")
+}
+
+// Issuer iss
+func (c Claims) Issuer() string {
+	return c.str("iss")
+}
+
+// Audience aud может быть строкой или массивом строк
+func (c Claims) Audience() []string {
+	switch v := c["aud"].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		res := make([]string, 0, len(v))
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+// time возвращает числовую дату (секунды unix) и есть ли она в токене
+func (c Claims) time(name string) (time.Time, bool) {
+	f, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+// Config правила проверки. Пустые Issuer и Audience не проверяются.
+type Config struct {
+	Keys     *KeySet
+	Issuer   string
+	Audience string
+	// Leeway допустимое расхождение часов для exp, nbf и iat
+	Leeway time.Duration
+	// AllowNoExpiry разрешить токены без exp, по умолчанию такие отклоняются
+	AllowNoExpiry bool
+	// Now источник времени, по умолчанию time.Now
+	Now func() time.Time
+}
+
+// Verifier проверяет токены по Config, безопасен для параллельного использования
+type Verifier struct {
+	cfg Config
+}
+
+func NewVerifier(cfg Config) *Verifier {
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &Verifier{cfg: cfg}
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// Verify проверяет подпись и утверждения токена и возвращает его claims
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	hb, err := b64(parts[0])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	h := header{}
+	if err := json.Unmarshal(hb, &h); err != nil {
+		return nil, ErrMalformed
+	}
+	sig, err := b64(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if err := v.verifySignature(h, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	cb, err := b64(parts[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	c := Claims{}
+	if err := json.Unmarshal(cb, &c); err != nil {
+		return nil, ErrMalformed
+	}
+	if err := v.validate(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// verifySignature ключ выбирается по kid, а без kid пробуются все ключи нужного алгоритма.
+// Алгоритм ключа должен совпадать с alg из заголовка, иначе можно было бы, например,
+// подписать токен HMAC-ом на публичном RSA ключе.
+func (v *Verifier) verifySignature(h header, signed, sig []byte) error {
+	switch h.Alg {
+	case AlgHS256, AlgRS256, AlgEdDSA:
+	default:
+		return ErrAlgorithm
+	}
+	if v.cfg.Keys == nil {
+		return ErrUnknownKey
+	}
+	found := false
+	for _, k := range v.cfg.Keys.Keys {
+		if k.Alg != h.Alg || (h.Kid != "" && k.ID != h.Kid) {
+			continue
+		}
+		found = true
+		if k.verify(signed, sig) {
+			return nil
+		}
+	}
+	if !found {
+		return ErrUnknownKey
+	}
+	return ErrSignature
+}
+
+func (k Key) verify(signed, sig []byte) bool {
+	switch key := k.key.(type) {
+	case []byte:
+		m := hmac.New(sha256.New, key)
+		m.Write(signed)
+		return hmac.Equal(m.Sum(nil), sig)
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, signed, sig)
+	}
+	return false
+}
+
+func (v *Verifier) validate(c Claims) error {
+	now := v.cfg.Now()
+	leeway := v.cfg.Leeway
+
+	exp, ok := c.time("exp")
+	if !ok && !v.cfg.AllowNoExpiry {
+		return ErrMissingExpiry
+	}
+	if ok && !now.Before(exp.Add(leeway)) {
+		return ErrExpired
+	}
+	if nbf, ok := c.time("nbf"); ok && now.Add(leeway).Before(nbf) {
+		return ErrNotYetValid
+	}
+	if iat, ok := c.time("iat"); ok && now.Add(leeway).Before(iat) {
+		return ErrNotYetValid
+	}
+	if v.cfg.Issuer != "" && c.Issuer() != v.cfg.Issuer {
+		return ErrIssuer
+	}
+	if v.cfg.Audience != "" {
+		for _, a := range c.Audience() {
+			if a == v.cfg.Audience {
+				return nil
+			}
+		}
+		return ErrAudience
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func enc(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// sign собирает токен, signer получает подписываемую часть
+func sign(h header, c Claims, signer func(signed []byte) []byte) string {
+	s := enc(h) + "." + enc(c)
+	return s + "." + base64.RawURLEncoding.EncodeToString(signer([]byte(s)))
+}
+
+func hs256(secret []byte) func([]byte) []byte {
+	return func(b []byte) []byte {
+		m := hmac.New(sha256.New, secret)
+		m.Write(b)
+		return m.Sum(nil)
+	}
+}
+
+func claims() Claims {
+	return Claims{
+		"sub": "ivan",
+		"iss": "https://auth.example",
+		"aud": []string{"other", "reguser"},
+		"exp": float64(now.Add(time.Minute).Unix()),
+		"iat": float64(now.Unix()),
+	}
+}
+
+func verifier(keys *KeySet) *Verifier {
+	return NewVerifier(Config{
+		Keys:     keys,
+		Issuer:   "https://auth.example",
+		Audience: "reguser",
+		Leeway:   30 * time.Second,
+		Now:      func() time.Time { return now },
+	})
+}
+
+func TestVerifier_JWKS(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	epub, epriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := base64.RawURLEncoding.EncodeToString
+	jwks := fmt.Sprintf(`{"keys":[
+		{"kty":"oct","kid":"hs","k":%q},
+		{"kty":"RSA","kid":"rs","alg":"RS256","n":%q,"e":%q},
+		{"kty":"OKP","kid":"ed","crv":"Ed25519","x":%q},
+		{"kty":"RSA","kid":"enc","use":"enc","n":"AQAB","e":"AQAB"}
+	]}`, b(secret), b(rk.N.Bytes()), b(big.NewInt(int64(rk.E)).Bytes()), b(epub))
+	ks, err := ParseJWKS([]byte(jwks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ks.Keys) != 3 {
+		t.Fatalf("parsed %d keys, want 3", len(ks.Keys))
+	}
+	v := verifier(ks)
+
+	rs256 := func(signed []byte) []byte {
+		sum := sha256.Sum256(signed)
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, rk, crypto.SHA256, sum[:])
+		return sig
+	}
+	eddsa := func(signed []byte) []byte {
+		return ed25519.Sign(epriv, signed)
+	}
+
+	tokens := map[string]string{
+		"HS256": sign(header{Alg: AlgHS256, Kid: "hs"}, claims(), hs256(secret)),
+		"RS256": sign(header{Alg: AlgRS256, Kid: "rs"}, claims(), rs256),
+		"EdDSA": sign(header{Alg: AlgEdDSA}, claims(), eddsa),
+	}
+	for name, tok := range tokens {
+		c, err := v.Verify(tok)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if c.Subject() != "ivan" {
+			t.Errorf("%s: subject %q", name, c.Subject())
+		}
+	}
+
+	// HS256 подписанный модулем публичного RSA ключа не должен пройти
+	confused := sign(header{Alg: AlgHS256, Kid: "rs"}, claims(), hs256(rk.N.Bytes()))
+	if _, err := v.Verify(confused); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("alg confusion: %v", err)
+	}
+}
+
+func TestVerifier_Claims(t *testing.T) {
+	secret := []byte("secret")
+	v := verifier(&KeySet{Keys: []Key{NewHMACKey("", secret)}})
+
+	cases := []struct {
+		name   string
+		change func(c Claims)
+		want   error
+	}{
+		{"valid", func(c Claims) {}, nil},
+		{"expired", func(c Claims) { c["exp"] = float64(now.Add(-time.Minute).Unix()) }, ErrExpired},
+		{"expired within leeway", func(c Claims) { c["exp"] = float64(now.Add(-10 * time.Second).Unix()) }, nil},
+		{"no expiry", func(c Claims) { delete(c, "exp") }, ErrMissingExpiry},
+		{"not yet valid", func(c Claims) { c["nbf"] = float64(now.Add(time.Minute).Unix()) }, ErrNotYetValid},
+		{"nbf within leeway", func(c Claims) { c["nbf"] = float64(now.Add(10 * time.Second).Unix()) }, nil},
+		{"issuer", func(c Claims) { c["iss"] = "https://evil.example" }, ErrIssuer},
+		{"audience", func(c Claims) { c["aud"] = "other" }, ErrAudience},
+		{"audience string", func(c Claims) { c["aud"] = "reguser" }, nil},
+	}
+	for _, tt := range cases {
+		c := claims()
+		tt.change(c)
+		_, err := v.Verify(sign(header{Alg: AlgHS256}, c, hs256(secret)))
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	bad := sign(header{Alg: AlgHS256}, claims(), hs256([]byte("other")))
+	if _, err := v.Verify(bad); !errors.Is(err, ErrSignature) {
+		t.Errorf("wrong secret: %v", err)
+	}
+	none := sign(header{Alg: "none"}, claims(), func([]byte) []byte { return nil })
+	if _, err := v.Verify(none); !errors.Is(err, ErrAlgorithm) {
+		t.Errorf("alg none: %v", err)
+	}
+	if _, err := v.Verify("a.b"); !errors.Is(err, ErrMalformed) {
+		t.Errorf("malformed: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+// Package suffixtree обобщенное суффиксное дерево для поиска подстроки в наборе строк.
+// Каждая строка добавляется всеми своими суффиксами в сжатое префиксное (radix) дерево,
+// поэтому поиск подстроки - это спуск по дереву на длину запроса и обход найденного поддерева,
+// время поиска зависит от длины запроса и количества совпадений, а не от размера набора.
+// Строки сравниваются побайтно, как в strings.Contains.
+// Дерево не потокобезопасно, синхронизация на стороне вызывающего.
+package suffixtree
+
+import (
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+type node struct {
+	// label метка ребра от родителя к узлу
+	label string
+	// children отсортированы по первому байту метки, у всех детей первые байты разные
+	children []*node
+	// ids строки, у которых суффикс заканчивается в этом узле
+	ids map[uuid.UUID]struct{}
+}
+
+// Tree индекс строк по идентификаторам
+type Tree struct {
+	root node
+	size int
+}
+
+func New() *Tree {
+	return &Tree{}
+}
+
+// Len количество строк в индексе
+func (t *Tree) Len() int {
+	return t.size
+}
+
+// Insert добавляет строку s с идентификатором id.
+// Один id должен соответствовать одной строке, перед сменой строки ее нужно удалить через Delete.
+func (t *Tree) Insert(id uuid.UUID, s string) {
+	for i := 0; i < len(s); i++ {
+		t.root.insert(s[i:], id)
+	}
+	t.size++
+}
+
+// Delete удаляет строку s с идентификатором id, s должна совпадать с той, что была передана в Insert.
+func (t *Tree) Delete(id uuid.UUID, s string) {
+	for i := 0; i < len(s); i++ {
+		t.root.delete(s[i:], id)
+	}
+	t.size--
+}
+
+// Search возвращает идентификаторы строк, содержащих подстроку q, без повторов и в произвольном порядке.
+// Пустой запрос ничего не находит, его вызывающий должен обработать сам.
+func (t *Tree) Search(q string) []uuid.UUID {
+	n := t.root.find(q)
+	if n == nil {
+		return nil
+	}
+	seen := make(map[uuid.UUID]struct{})
+	res := []uuid.UUID{}
+	n.walk(func(id uuid.UUID) {
+		if _, ok := seen[id]; ok {
+			return
+		}
+		seen[id] = struct{}{}
+		res = append(res, id)
+	})
+	return res
+}
+
+// child ищет ребенка по первому байту метки, возвращает позицию для вставки если не нашли
+func (n *node) child(b byte) (int, bool) {
+	i := sort.Search(len(n.children), func(i int) bool {
+		return n.children[i].label[0] >= b
+	})
+	return i, i < len(n.children) && n.children[i].label[0] == b
+}
+
+func commonPrefix(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+func (n *node) insert(s string, id uuid.UUID) {
+	for {
+		if s == "" {
+			if n.ids == nil {
+				n.ids = make(map[uuid.UUID]struct{}, 1)
+			}
+			n.ids[id] = struct{}{}
+			return
+		}
+		i, ok := n.child(s[0])
+		if !ok {
+			c := &node{label: s}
+			n.children = append(n.children, nil)
+			copy(n.children[i+1:], n.children[i:])
+			n.children[i] = c
+			n = c
+			s = ""
+			continue
+		}
+		c := n.children[i]
+		l := commonPrefix(s, c.label)
+		if l < len(c.label) {
+			// разрезаем ребро: новый промежуточный узел с общей частью метки
+			mid := &node{label: c.label[:l], children: []*node{c}}
+			c.label = c.label[l:]
+			n.children[i] = mid
+			c = mid
+		}
+		n = c
+		s = s[l:]
+	}
+}
+
+// delete удаляет id из узла суффикса s и чистит дерево: пустые листья удаляются,
+// а пустой узел с одним ребенком склеивается с ним.
+func (n *node) delete(s string, id uuid.UUID) {
+	if s == "" {
+		delete(n.ids, id)
+		return
+	}
+	i, ok := n.child(s[0])
+	if !ok {
+		return
+	}
+	c := n.children[i]
+	if len(s) < len(c.label) || s[:len(c.label)] != c.label {
+		return
+	}
+	c.delete(s[len(c.label):], id)
+
+	if len(c.ids) > 0 {
+		return
+	}
+	switch len(c.children) {
+	case 0:
+		n.children = append(n.children[:i], n.children[i+1:]...)
+	case 1:
+		gc := c.children[0]
+		gc.label = c.label + gc.label
+		n.children[i] = gc
+	}
+}
+
+// find возвращает узел, в поддереве которого лежат все суффиксы начинающиеся с q
+func (n *node) find(q string) *node {
+	for q != "" {
+		i, ok := n.child(q[0])
+		if !ok {
+			return nil
+		}
+		c := n.children[i]
+		l := commonPrefix(q, c.label)
+		if l == len(q) {
+			return c
+		}
+		if l < len(c.label) {
+			return nil
+		}
+		n = c
+		q = q[l:]
+	}
+	return n
+}
+
+func (n *node) walk(f func(id uuid.UUID)) {
+	for id := range n.ids {
+		f(id)
+	}
+	for _, c := range n.children {
+		c.walk(f)
+	}
+}
@@ -0,0 +1,85 @@
+package suffixtree
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func randString(r *rand.Rand, n int) string {
+	const alphabet = "abcд"
+	rs := []rune(alphabet)
+	b := strings.Builder{}
+	for i := 0; i < n; i++ {
+		b.WriteRune(rs[r.Intn(len(rs))])
+	}
+	return b.String()
+}
+
+func sorted(ids []uuid.UUID) []string {
+	res := make([]string, 0, len(ids))
+	for _, id := range ids {
+		res = append(res, id.String())
+	}
+	sort.Strings(res)
+	return res
+}
+
+// TestTree_Search сверяет поиск по дереву с полным перебором через strings.Contains
+// после случайных вставок и удалений.
+func TestTree_Search(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	tr := New()
+	m := map[uuid.UUID]string{}
+
+	for i := 0; i < 2000; i++ {
+		if len(m) > 0 && r.Intn(3) == 0 {
+			for id, s := range m {
+				tr.Delete(id, s)
+				delete(m, id)
+				break
+			}
+			continue
+		}
+		id := uuid.New()
+		s := randString(r, r.Intn(8))
+		tr.Insert(id, s)
+		m[id] = s
+	}
+	if tr.Len() != len(m) {
+		t.Fatalf("len %d, want %d", tr.Len(), len(m))
+	}
+
+	for i := 0; i < 500; i++ {
+		q := randString(r, 1+r.Intn(4))
+		want := []uuid.UUID{}
+		for id, s := range m {
+			if strings.Contains(s, q) {
+				want = append(want, id)
+			}
+		}
+		got := sorted(tr.Search(q))
+		ws := sorted(want)
+		if strings.Join(got, ",") != strings.Join(ws, ",") {
+			t.Fatalf("search %q: got %d ids, want %d", q, len(got), len(ws))
+		}
+	}
+}
+
+func TestTree_DeleteAll(t *testing.T) {
+	tr := New()
+	a, b := uuid.New(), uuid.New()
+	tr.Insert(a, "banana")
+	tr.Insert(b, "ananas")
+	tr.Delete(a, "banana")
+	tr.Delete(b, "ananas")
+	if len(tr.root.children) != 0 {
+		t.Errorf("tree is not empty after deleting all strings: %d children", len(tr.root.children))
+	}
+	if ids := tr.Search("an"); len(ids) != 0 {
+		t.Errorf("found deleted ids: %v", ids)
+	}
+}
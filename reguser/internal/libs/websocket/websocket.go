@@ -0,0 +1,487 @@
+// Package websocket минимальный протокол WebSocket (RFC 6455): рукопожатие на сервере и на клиенте,
+// текстовые и бинарные сообщения, фрагментация, ping/pong и закрытие.
+// Расширения (сжатие) и подпротоколы не поддерживаются. Пакет не зависит от слоев приложения.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Типы сообщений
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+)
+
+const (
+	opContinuation = 0
+	opClose        = 8
+	opPing         = 9
+	opPong         = 10
+)
+
+// Коды закрытия соединения
+const (
+	CloseNormal        = 1000
+	CloseGoingAway     = 1001
+	CloseProtocolError = 1002
+	CloseInvalidData   = 1007
+	ClosePolicy        = 1008
+	CloseTooBig        = 1009
+	CloseInternalError = 1011
+	CloseTryAgainLater = 1013
+	closeNoStatus      = 1005
+)
+
+// DefaultMaxMessageSize предел размера входящего сообщения по умолчанию
+const DefaultMaxMessageSize = 1 << 20
+
+// closeTimeout сколько ждать отправки кадра закрытия
+const closeTimeout = time.Second
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	ErrBadHandshake = errors.New("websocket: bad handshake")
+	ErrProtocol     = errors.New("websocket: protocol error")
+	ErrTooBig       = errors.New("websocket: message too big")
+	ErrInvalidUTF8  = errors.New("websocket: invalid utf-8 in text message")
+	ErrBadOrigin    = errors.New("websocket: origin not allowed")
+)
+
+// CloseError другая сторона закрыла соединение с кодом Code
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed %d %s", e.Code, e.Reason)
+}
+
+// Conn соединение после рукопожатия. Читать можно из одной горутины, писать из нескольких.
+// На ping ReadMessage отвечает сам, поэтому читать надо, даже если сообщения от клиента не нужны.
+type Conn struct {
+	nc     net.Conn
+	br     *bufio.Reader
+	client bool
+
+	// MaxMessageSize предел размера входящего сообщения, больше закрывает соединение с CloseTooBig
+	MaxMessageSize int64
+
+	wmu       sync.Mutex
+	closeSent bool
+}
+
+func newConn(nc net.Conn, br *bufio.Reader, client bool) *Conn {
+	return &Conn{nc: nc, br: br, client: client, MaxMessageSize: DefaultMaxMessageSize}
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// hasToken заголовок со списком через запятую содержит token без учета регистра
+func hasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Upgrader настройки рукопожатия на сервере
+type Upgrader struct {
+	// Origins с каких еще сайтов, кроме своего, можно открыть соединение, например https://admin.example.com
+	Origins []string
+}
+
+// CheckOrigin правило как в gorilla/websocket: запрос без Origin пришел не из браузера и проходит,
+// иначе хост из Origin должен совпадать с Host запроса или сам Origin должен быть в u.Origins.
+// Браузер открывает WebSocket с любой страницы и сам подставляет куки и basic auth,
+// поэтому без этой проверки чужой сайт действовал бы от имени пользователя.
+func (u Upgrader) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, o := range u.Origins {
+		if strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	ou, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(ou.Host, r.Host)
+}
+
+// Upgrade рукопожатие без дополнительных разрешенных Origin, см. Upgrader.Upgrade
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	return Upgrader{}.Upgrade(w, r)
+}
+
+// Upgrade проверяет запрос на рукопожатие и забирает соединение у http сервера.
+// Если запрос не WebSocket, отвечает 400 сам и возвращает ErrBadHandshake,
+// если Origin не прошел CheckOrigin, отвечает 403 и возвращает ErrBadOrigin.
+// Нужен HTTP/1.1: по HTTP/2 соединение забрать нельзя.
+func (u Upgrader) Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	bad := func(why string) error {
+		http.Error(w, "websocket: "+why, http.StatusBadRequest)
+		return fmt.Errorf("%w: %s", ErrBadHandshake, why)
+	}
+	if r.Method != http.MethodGet {
+		return nil, bad("method must be GET")
+	}
+	if !hasToken(r.Header, "Connection", "upgrade") || !hasToken(r.Header, "Upgrade", "websocket") {
+		return nil, bad("not a websocket upgrade")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, bad("unsupported version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != 16 {
+		return nil, bad("bad Sec-WebSocket-Key")
+	}
+	if !u.CheckOrigin(r) {
+		http.Error(w, "websocket: origin not allowed", http.StatusForbidden)
+		return nil, fmt.Errorf("%w: %s", ErrBadOrigin, r.Header.Get("Origin"))
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket: connection can not be hijacked", http.StatusInternalServerError)
+		return nil, fmt.Errorf("%w: response does not implement http.Hijacker", ErrBadHandshake)
+	}
+	nc, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("websocket hijack error: %w", err)
+	}
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	if _, err := nc.Write([]byte(resp)); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("websocket handshake error: %w", err)
+	}
+	return newConn(nc, brw.Reader, false), nil
+}
+
+// Dial открывает клиентское соединение по адресу ws:// или wss://, h дополнительные заголовки запроса,
+// например Authorization.
+func Dial(rawurl string, h http.Header) (*Conn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), map[string]string{"ws": "80", "wss": "443"}[u.Scheme])
+	}
+	var nc net.Conn
+	switch u.Scheme {
+	case "ws":
+		nc, err = net.Dial("tcp", host)
+	case "wss":
+		nc, err = tls.Dial("tcp", host, &tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, fmt.Errorf("%w: unsupported scheme %q", ErrBadHandshake, u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(b)
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Host:       u.Host,
+	}
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(nc); err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("websocket handshake error: %w", err)
+	}
+
+	br := bufio.NewReader(nc)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("websocket handshake error: %w", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		resp.Body.Close()
+		nc.Close()
+		return nil, fmt.Errorf("%w: status %s", ErrBadHandshake, resp.Status)
+	}
+	return newConn(nc, br, true), nil
+}
+
+// SetReadDeadline срок для ReadMessage
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.nc.SetReadDeadline(t)
+}
+
+// SetWriteDeadline срок для WriteMessage, медленный клиент не задержит отправителя дольше
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.nc.SetWriteDeadline(t)
+}
+
+// WriteMessage отправляет сообщение одним кадром, typ TextMessage или BinaryMessage
+func (c *Conn) WriteMessage(typ int, p []byte) error {
+	if typ != TextMessage && typ != BinaryMessage {
+		return fmt.Errorf("websocket: unknown message type %d", typ)
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	return c.writeFrame(byte(typ), p)
+}
+
+// Ping отправляет ping, pong на него ReadMessage пропускает. Нужен, чтобы вовремя заметить оборванное
+// соединение, когда писать больше нечего.
+func (c *Conn) Ping() error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return net.ErrClosed
+	}
+	return c.writeFrame(opPing, nil)
+}
+
+// writeFrame пишет один кадр, вызывается под wmu
+func (c *Conn) writeFrame(op byte, p []byte) error {
+	b, err := c.frame(true, op, p)
+	if err != nil {
+		return err
+	}
+	_, err = c.nc.Write(b)
+	return err
+}
+
+// frame собирает кадр. Клиент обязан маскировать кадры, сервер не должен.
+func (c *Conn) frame(fin bool, op byte, p []byte) ([]byte, error) {
+	buf := make([]byte, 0, 14+len(p))
+	if fin {
+		op |= 0x80
+	}
+	buf = append(buf, op)
+	var mask byte
+	if c.client {
+		mask = 0x80
+	}
+	switch n := len(p); {
+	case n < 126:
+		buf = append(buf, mask|byte(n))
+	case n <= 0xffff:
+		buf = append(buf, mask|126, 0, 0)
+		binary.BigEndian.PutUint16(buf[len(buf)-2:], uint16(n))
+	default:
+		buf = append(buf, mask|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(buf[len(buf)-8:], uint64(n))
+	}
+	if !c.client {
+		return append(buf, p...), nil
+	}
+	var key [4]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return nil, err
+	}
+	buf = append(buf, key[:]...)
+	start := len(buf)
+	buf = append(buf, p...)
+	maskBytes(key, buf[start:])
+	return buf, nil
+}
+
+func maskBytes(key [4]byte, p []byte) {
+	for i := range p {
+		p[i] ^= key[i%4]
+	}
+}
+
+// ReadMessage читает следующее сообщение целиком, собирая фрагменты. Ping и pong обрабатываются внутри.
+// Когда другая сторона закрывает соединение, возвращает *CloseError, ответный кадр закрытия уже отправлен.
+// На нарушение протокола соединение закрывается с соответствующим кодом.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	typ := 0
+	var msg []byte
+	for {
+		fin, op, p, err := c.readFrame()
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		switch op {
+		case opPing:
+			c.wmu.Lock()
+			if !c.closeSent {
+				err = c.writeFrame(opPong, p)
+			}
+			c.wmu.Unlock()
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			ce := &CloseError{Code: closeNoStatus}
+			if len(p) >= 2 {
+				ce.Code = int(binary.BigEndian.Uint16(p))
+				ce.Reason = string(p[2:])
+			}
+			code := ce.Code
+			if code == closeNoStatus {
+				code = CloseNormal
+			}
+			_ = c.Close(code, "")
+			return 0, nil, ce
+		case TextMessage, BinaryMessage:
+			if typ != 0 {
+				return 0, nil, c.fail(fmt.Errorf("%w: new message inside fragmented one", ErrProtocol))
+			}
+			typ = int(op)
+		case opContinuation:
+			if typ == 0 {
+				return 0, nil, c.fail(fmt.Errorf("%w: continuation without message", ErrProtocol))
+			}
+		default:
+			return 0, nil, c.fail(fmt.Errorf("%w: unknown opcode %d", ErrProtocol, op))
+		}
+		if int64(len(msg)+len(p)) > c.MaxMessageSize {
+			return 0, nil, c.fail(ErrTooBig)
+		}
+		msg = append(msg, p...)
+		if !fin {
+			continue
+		}
+		if typ == TextMessage && !utf8.Valid(msg) {
+			return 0, nil, c.fail(ErrInvalidUTF8)
+		}
+		return typ, msg, nil
+	}
+}
+
+// readFrame читает один кадр и снимает маску
+func (c *Conn) readFrame() (bool, byte, []byte, error) {
+	var h [2]byte
+	if _, err := io.ReadFull(c.br, h[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op := h[0]&0x80 != 0, h[0]&0x0f
+	if h[0]&0x70 != 0 {
+		return false, 0, nil, fmt.Errorf("%w: reserved bits set", ErrProtocol)
+	}
+	masked := h[1]&0x80 != 0
+	if masked == c.client {
+		return false, 0, nil, fmt.Errorf("%w: wrong masking", ErrProtocol)
+	}
+	n := int64(h[1] & 0x7f)
+	if op >= opClose && (n > 125 || !fin) {
+		return false, 0, nil, fmt.Errorf("%w: bad control frame", ErrProtocol)
+	}
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if n < 0 || n > c.MaxMessageSize {
+		return false, 0, nil, ErrTooBig
+	}
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, key[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	p := make([]byte, n)
+	if _, err := io.ReadFull(c.br, p); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		maskBytes(key, p)
+	}
+	return fin, op, p, nil
+}
+
+// fail закрывает соединение с кодом, подходящим к ошибке чтения, и возвращает саму ошибку
+func (c *Conn) fail(err error) error {
+	switch {
+	case errors.Is(err, ErrProtocol):
+		_ = c.Close(CloseProtocolError, "")
+	case errors.Is(err, ErrTooBig):
+		_ = c.Close(CloseTooBig, "")
+	case errors.Is(err, ErrInvalidUTF8):
+		_ = c.Close(CloseInvalidData, "")
+	default:
+		_ = c.nc.Close()
+	}
+	return err
+}
+
+// Close отправляет кадр закрытия с кодом и причиной, если еще не отправляли, и закрывает соединение.
+// Ответа другой стороны не ждет. Повторный вызов ничего не делает.
+func (c *Conn) Close(code int, reason string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return nil
+	}
+	c.closeSent = true
+	p := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(p, uint16(code))
+	p = append(p, reason...)
+	if len(p) > 125 {
+		p = p[:125]
+	}
+	_ = c.nc.SetWriteDeadline(time.Now().Add(closeTimeout))
+	err := c.writeFrame(opClose, p)
+	if cerr := c.nc.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
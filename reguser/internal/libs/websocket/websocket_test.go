@@ -0,0 +1,148 @@
+package websocket
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// echoServer отвечает тем же сообщением, пока клиент не закроет соединение
+func echoServer(t *testing.T, closed chan<- error) string {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		c.MaxMessageSize = 100000
+		for {
+			typ, p, err := c.ReadMessage()
+			if err != nil {
+				if closed != nil {
+					closed <- err
+				}
+				return
+			}
+			if err := c.WriteMessage(typ, p); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return "ws" + strings.TrimPrefix(ts.URL, "http")
+}
+
+func TestEcho(t *testing.T) {
+	closed := make(chan error, 1)
+	c, err := Dial(echoServer(t, closed), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, size := range []int{0, 5, 125, 126, 65535, 70000} {
+		msg := bytes.Repeat([]byte("x"), size)
+		if err := c.WriteMessage(BinaryMessage, msg); err != nil {
+			t.Fatal(err)
+		}
+		typ, got, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if typ != BinaryMessage || !bytes.Equal(got, msg) {
+			t.Errorf("size %d: got %d bytes of type %d", size, len(got), typ)
+		}
+	}
+
+	// фрагментированное сообщение с ping посередине: ping не ломает сборку, ответ одним сообщением
+	c.wmu.Lock()
+	for _, f := range []struct {
+		op  byte
+		fin bool
+		p   string
+	}{{TextMessage, false, "hel"}, {opPing, true, "?"}, {opContinuation, true, "lo"}} {
+		b, err := c.frame(f.fin, f.op, []byte(f.p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.nc.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.wmu.Unlock()
+	if typ, got, err := c.ReadMessage(); err != nil || typ != TextMessage || string(got) != "hello" {
+		t.Errorf("fragmented: %d %q %v", typ, got, err)
+	}
+
+	if err := c.Close(CloseNormal, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	var ce *CloseError
+	if err := <-closed; !errors.As(err, &ce) || ce.Code != CloseNormal || ce.Reason != "bye" {
+		t.Errorf("server got %v", err)
+	}
+}
+
+func TestTooBig(t *testing.T) {
+	c, err := Dial(echoServer(t, nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteMessage(TextMessage, bytes.Repeat([]byte("x"), 100001)); err != nil {
+		t.Fatal(err)
+	}
+	var ce *CloseError
+	if _, _, err := c.ReadMessage(); !errors.As(err, &ce) || ce.Code != CloseTooBig {
+		t.Errorf("got %v, want close %d", err, CloseTooBig)
+	}
+}
+
+func TestUpgrade_BadHandshake(t *testing.T) {
+	url := echoServer(t, nil)
+	resp, err := http.Get("http" + strings.TrimPrefix(url, "ws"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("plain GET: status %d", resp.StatusCode)
+	}
+	if _, err := Dial("http"+strings.TrimPrefix(url, "ws"), nil); !errors.Is(err, ErrBadHandshake) {
+		t.Errorf("dial http scheme: %v", err)
+	}
+}
+
+func TestUpgrader_CheckOrigin(t *testing.T) {
+	u := Upgrader{Origins: []string{"https://admin.example.com"}}
+	cases := map[string]bool{
+		"":                          true,
+		"http://api.example.com":    true,
+		"https://API.example.com":   true,
+		"https://admin.example.com": true,
+		"https://evil.example.com":  false,
+		"http://api.example.com:81": false,
+		"null":                      false,
+	}
+	for origin, ok := range cases {
+		r := httptest.NewRequest("GET", "http://api.example.com/watch", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		if got := u.CheckOrigin(r); got != ok {
+			t.Errorf("origin %q: %v, want %v", origin, got, ok)
+		}
+	}
+}
+
+func TestUpgrade_BadOrigin(t *testing.T) {
+	url := echoServer(t, nil)
+	if _, err := Dial(url, http.Header{"Origin": {"https://evil.example.com"}}); !errors.Is(err, ErrBadHandshake) {
+		t.Errorf("cross-site dial: %v", err)
+	}
+	c, err := Dial(url, http.Header{"Origin": {"http" + strings.TrimPrefix(url, "ws")}})
+	if err != nil {
+		t.Fatalf("same-site dial: %v", err)
+	}
+	_ = c.Close(CloseNormal, "")
+}